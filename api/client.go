@@ -17,12 +17,16 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"runtime"
 
 	"github.com/ollama/ollama/envconfig"
@@ -33,8 +37,9 @@ import (
 // Client encapsulates client state for interacting with the ollama
 // service. Use [ClientFromEnvironment] to create new Clients.
 type Client struct {
-	base *url.URL
-	http *http.Client
+	base   *url.URL
+	http   *http.Client
+	apiKey string
 }
 
 func checkError(resp *http.Response, body []byte) error {
@@ -61,19 +66,74 @@ func checkError(resp *http.Response, body []byte) error {
 //	<scheme>://<host>:<port>
 //
 // If the variable is not specified, a default ollama host and port will be
-// used.
+// used. If OLLAMA_API_KEY is set, it is sent as a bearer token with every
+// request. When connecting over https, the CA certificates in
+// OLLAMA_TLS_CA_BUNDLE are trusted in addition to the system ones and the
+// certificate in OLLAMA_TLS_CLIENT_CERT and OLLAMA_TLS_CLIENT_KEY, if set, is
+// presented to the server.
 func ClientFromEnvironment() (*Client, error) {
 	ollamaHost := envconfig.Host
 
+	client, err := httpClientFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
 		base: &url.URL{
 			Scheme: ollamaHost.Scheme,
 			Host:   net.JoinHostPort(ollamaHost.Host, ollamaHost.Port),
 		},
-		http: http.DefaultClient,
+		http:   client,
+		apiKey: envconfig.APIKey,
 	}, nil
 }
 
+// httpClientFromEnvironment returns an HTTP client that trusts the CA
+// certificates in OLLAMA_TLS_CA_BUNDLE in addition to the system ones and
+// presents the certificate in OLLAMA_TLS_CLIENT_CERT to the server
+func httpClientFromEnvironment() (*http.Client, error) {
+	if envconfig.TLSCABundle == "" && envconfig.TLSClientCert == "" && envconfig.TLSClientKey == "" {
+		return http.DefaultClient, nil
+	}
+
+	var config tls.Config
+	if envconfig.TLSCABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		bts, err := os.ReadFile(envconfig.TLSCABundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(bts) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", envconfig.TLSCABundle)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if envconfig.TLSClientCert != "" || envconfig.TLSClientKey != "" {
+		if envconfig.TLSClientCert == "" || envconfig.TLSClientKey == "" {
+			return nil, errors.New("OLLAMA_TLS_CLIENT_CERT and OLLAMA_TLS_CLIENT_KEY must be set together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(envconfig.TLSClientCert, envconfig.TLSClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &config
+	return &http.Client{Transport: transport}, nil
+}
+
 func NewClient(base *url.URL, http *http.Client) *Client {
 	return &Client{
 		base: base,
@@ -110,6 +170,9 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	respObj, err := c.http.Do(request)
 	if err != nil {
@@ -156,6 +219,9 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/x-ndjson")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	response, err := c.http.Do(request)
 	if err != nil {
@@ -312,6 +378,44 @@ func (c *Client) ListRunning(ctx context.Context) (*ProcessResponse, error) {
 	return &lr, nil
 }
 
+// Snapshot evaluates a prompt and saves the resulting KV cache of the model,
+// so that requests starting with the prompt can restore it instead of
+// evaluating the prompt again, even after the server restarts.
+func (c *Client) Snapshot(ctx context.Context, req *SnapshotRequest) (*SnapshotResponse, error) {
+	var resp SnapshotResponse
+	if err := c.do(ctx, http.MethodPost, "/api/snapshot", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Load loads a model into memory, optionally pinning it there.
+func (c *Client) Load(ctx context.Context, req *LoadRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/load", req, nil)
+}
+
+// Unload unloads a model from memory once the requests using it finish,
+// whether or not it is pinned.
+func (c *Client) Unload(ctx context.Context, req *UnloadRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/unload", req, nil)
+}
+
+// ListRequests lists the requests that are waiting for or running a model.
+func (c *Client) ListRequests(ctx context.Context) (*ListRequestsResponse, error) {
+	var lr ListRequestsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/requests", nil, &lr); err != nil {
+		return nil, err
+	}
+	return &lr, nil
+}
+
+// CancelRequest cancels a request that is waiting for or running a model.
+// The request fails with an error, or stops streaming responses if it has
+// started.
+func (c *Client) CancelRequest(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/requests/"+url.PathEscape(id), nil, nil)
+}
+
 // Copy copies a model - creating a model with another name from an existing
 // model.
 func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
@@ -356,6 +460,26 @@ func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*Embedd
 	return &resp, nil
 }
 
+// Tokenize converts text into the token IDs of a model, loading the model
+// if needed.
+func (c *Client) Tokenize(ctx context.Context, req *TokenizeRequest) (*TokenizeResponse, error) {
+	var resp TokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/tokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Detokenize converts token IDs of a model back into text, loading the model
+// if needed.
+func (c *Client) Detokenize(ctx context.Context, req *DetokenizeRequest) (*DetokenizeResponse, error) {
+	var resp DetokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/detokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // CreateBlob creates a blob from a file on the server. digest is the
 // expected SHA256 digest of the file, and r represents the file.
 func (c *Client) CreateBlob(ctx context.Context, digest string, r io.Reader) error {
@@ -1,7 +1,22 @@
 package api
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ollama/ollama/envconfig"
 )
@@ -46,3 +61,170 @@ func TestClientFromEnvironment(t *testing.T) {
 		})
 	}
 }
+
+func TestClientFromEnvironmentCABundle(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_HOST", ts.URL)
+
+	t.Run("without bundle", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CA_BUNDLE", "")
+		envconfig.LoadConfig()
+
+		client, err := ClientFromEnvironment()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := client.Heartbeat(context.Background()); err == nil {
+			t.Error("expected certificate verification to fail")
+		}
+	})
+
+	t.Run("with bundle", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CA_BUNDLE", bundle)
+		envconfig.LoadConfig()
+
+		client, err := ClientFromEnvironment()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := client.Heartbeat(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("missing bundle", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CA_BUNDLE", filepath.Join(t.TempDir(), "missing.pem"))
+		envconfig.LoadConfig()
+
+		if _, err := ClientFromEnvironment(); err == nil || !strings.Contains(err.Error(), "reading CA bundle") {
+			t.Errorf("expected error reading CA bundle, got %v", err)
+		}
+	})
+}
+
+// writeClientCert writes a self-signed client certificate and its key to dir
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath, keyPath := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, certPath, keyPath
+}
+
+func TestClientFromEnvironmentClientCert(t *testing.T) {
+	dir := t.TempDir()
+	cert, certPath, keyPath := writeClientCert(t, dir)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_HOST", ts.URL)
+	t.Setenv("OLLAMA_TLS_CA_BUNDLE", bundle)
+
+	t.Run("without certificate", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CLIENT_CERT", "")
+		t.Setenv("OLLAMA_TLS_CLIENT_KEY", "")
+		envconfig.LoadConfig()
+
+		client, err := ClientFromEnvironment()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := client.Heartbeat(context.Background()); err == nil {
+			t.Error("expected the server to reject the connection")
+		}
+	})
+
+	t.Run("with certificate", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CLIENT_CERT", certPath)
+		t.Setenv("OLLAMA_TLS_CLIENT_KEY", keyPath)
+		envconfig.LoadConfig()
+
+		client, err := ClientFromEnvironment()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := client.Heartbeat(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CLIENT_CERT", certPath)
+		t.Setenv("OLLAMA_TLS_CLIENT_KEY", "")
+		envconfig.LoadConfig()
+
+		if _, err := ClientFromEnvironment(); err == nil || !strings.Contains(err.Error(), "must be set together") {
+			t.Errorf("expected error for missing key, got %v", err)
+		}
+	})
+
+	t.Run("unreadable certificate", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CLIENT_CERT", filepath.Join(dir, "missing.crt"))
+		t.Setenv("OLLAMA_TLS_CLIENT_KEY", keyPath)
+		envconfig.LoadConfig()
+
+		if _, err := ClientFromEnvironment(); err == nil || !strings.Contains(err.Error(), "loading client certificate") {
+			t.Errorf("expected error loading client certificate, got %v", err)
+		}
+	})
+}
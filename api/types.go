@@ -47,6 +47,10 @@ type GenerateRequest struct {
 	// Prompt is the textual prompt to send to the model.
 	Prompt string `json:"prompt"`
 
+	// Suffix is the text that comes after the inserted text, for models
+	// whose template supports fill-in-the-middle completion.
+	Suffix string `json:"suffix,omitempty"`
+
 	// System overrides the model's default system message/prompt.
 	System string `json:"system"`
 
@@ -63,22 +67,55 @@ type GenerateRequest struct {
 	// Raw set to true means that no formatting will be applied to the prompt.
 	Raw bool `json:"raw,omitempty"`
 
-	// Format specifies the format to return a response in.
-	Format string `json:"format"`
+	// Format specifies the format to return a response in. It is either the
+	// string "json" or a JSON schema object the response must conform to.
+	Format json.RawMessage `json:"format,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded in memory following
 	// this request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// Priority is the scheduling class of the request, either "interactive"
+	// (the default) or "batch". Batch requests wait behind interactive ones
+	// but still get a share of the model.
+	Priority string `json:"priority,omitempty"`
+
+	// Snapshot is the digest of a KV cache snapshot created with
+	// [Client.Snapshot] to restore before the prompt is evaluated, so the part
+	// of the prompt it shares with the snapshot isn't evaluated again.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Adapters is an optional list of LoRA adapters to apply to the model for
+	// this request only, on top of any it was created with. The model stays
+	// loaded and the adapters are swapped in and out of it between requests.
+	Adapters []Adapter `json:"adapters,omitempty"`
+
 	// Images is an optional list of base64-encoded images accompanying this
 	// request, for multimodal models.
 	Images []ImageData `json:"images,omitempty"`
 
+	// Logprobs requests the log probability of each generated token.
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of most likely tokens to return at each
+	// position along with their log probabilities. It requires Logprobs.
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
 	// Options lists model-specific options. For example, temperature can be
 	// set through this field, if the model supports it.
 	Options map[string]interface{} `json:"options"`
 }
 
+// Adapter names a LoRA adapter to apply to a model for a single request.
+type Adapter struct {
+	// Name is either a model created FROM the same base model with ADAPTER
+	// commands, whose adapters are applied, or the digest of an adapter blob.
+	Name string `json:"name"`
+
+	// Scale multiplies the strength of the adapter; 1 when unset.
+	Scale float32 `json:"scale,omitempty"`
+}
+
 // ChatRequest describes a request sent by [Client.Chat].
 type ChatRequest struct {
 	// Model is the model name, as in [GenerateRequest].
@@ -90,24 +127,101 @@ type ChatRequest struct {
 	// Stream enable streaming of returned response; true by default.
 	Stream *bool `json:"stream,omitempty"`
 
-	// Format is the format to return the response in (e.g. "json").
-	Format string `json:"format"`
+	// Format is the format to return the response in, as in [GenerateRequest].
+	Format json.RawMessage `json:"format,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded into memory
 	// followin the request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// Priority is the scheduling class of the request, as in [GenerateRequest].
+	Priority string `json:"priority,omitempty"`
+
+	// Snapshot is a KV cache snapshot to restore, as in [GenerateRequest].
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Adapters is a list of LoRA adapters to apply, as in [GenerateRequest].
+	Adapters []Adapter `json:"adapters,omitempty"`
+
+	// Tools is an optional list of tools the model has access to.
+	Tools Tools `json:"tools,omitempty"`
+
+	// Logprobs requests the log probability of each generated token.
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of most likely tokens to return at each
+	// position along with their log probabilities. It requires Logprobs.
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
 
+// Tools is a list of tools; it renders as JSON in prompt templates.
+type Tools []Tool
+
+func (t Tools) String() string {
+	bts, _ := json.Marshal(t)
+	return string(bts)
+}
+
 // Message is a single message in a chat sequence. The message contains the
-// role ("system", "user", or "assistant"), the content and an optional list
-// of images.
+// role ("system", "user", "assistant" or "tool"), the content and an optional
+// list of images. Assistant messages may carry the tool calls requested by
+// the model.
 type Message struct {
-	Role    string      `json:"role"`
-	Content string      `json:"content"`
-	Images  []ImageData `json:"images,omitempty"`
+	Role      string      `json:"role"`
+	Content   string      `json:"content"`
+	Images    []ImageData `json:"images,omitempty"`
+	ToolCalls []ToolCall  `json:"tool_calls,omitempty"`
+}
+
+// ToolCall is a request from the model to call a tool.
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string                    `json:"name"`
+	Arguments ToolCallFunctionArguments `json:"arguments"`
+}
+
+// ToolCallFunctionArguments holds the arguments of a tool call; it renders as
+// JSON in prompt templates.
+type ToolCallFunctionArguments map[string]any
+
+func (t ToolCallFunctionArguments) String() string {
+	bts, _ := json.Marshal(t)
+	return string(bts)
+}
+
+// Tool is a function definition the model may call. Parameters are
+// described with a JSON schema.
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  ToolFunctionParameters `json:"parameters,omitempty"`
+}
+
+// ToolFunctionParameters holds the JSON schema of a function's parameters
+// exactly as it was sent; it renders as JSON in prompt templates.
+type ToolFunctionParameters json.RawMessage
+
+func (p ToolFunctionParameters) MarshalJSON() ([]byte, error) {
+	return json.RawMessage(p).MarshalJSON()
+}
+
+func (p *ToolFunctionParameters) UnmarshalJSON(b []byte) error {
+	return (*json.RawMessage)(p).UnmarshalJSON(b)
+}
+
+func (p ToolFunctionParameters) String() string {
+	return string(p)
 }
 
 // ChatResponse is the response returned by [Client.Chat]. Its fields are
@@ -118,18 +232,42 @@ type ChatResponse struct {
 	Message    Message   `json:"message"`
 	DoneReason string    `json:"done_reason,omitempty"`
 
+	// RequestID identifies the request in [Client.ListRequests] and
+	// [Client.CancelRequest]. It is only set in the first response.
+	RequestID string `json:"request_id,omitempty"`
+
 	Done bool `json:"done"`
 
+	// Logprobs holds the log probabilities of the tokens in Message, if
+	// requested.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
 	Metrics
 }
 
+// TokenLogprob is a token and its log probability.
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
+// Logprob is the log probability of a generated token, along with the most
+// likely tokens at its position if requested.
+type Logprob struct {
+	TokenLogprob
+	TopLogprobs []TokenLogprob `json:"top_logprobs,omitempty"`
+}
+
 type Metrics struct {
 	TotalDuration      time.Duration `json:"total_duration,omitempty"`
 	LoadDuration       time.Duration `json:"load_duration,omitempty"`
 	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
+	PromptCacheCount   int           `json:"prompt_cache_count,omitempty"`
 	EvalCount          int           `json:"eval_count,omitempty"`
 	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+	DraftCount         int           `json:"draft_count,omitempty"`
+	DraftAcceptedCount int           `json:"draft_accepted_count,omitempty"`
 }
 
 // Options specified in [GenerateRequest], if you add a new option here add it
@@ -155,6 +293,9 @@ type Options struct {
 	MirostatEta      float32  `json:"mirostat_eta,omitempty"`
 	PenalizeNewline  bool     `json:"penalize_newline,omitempty"`
 	Stop             []string `json:"stop,omitempty"`
+
+	// Grammar is a GBNF grammar that constrains the generated output
+	Grammar string `json:"grammar,omitempty"`
 }
 
 // Runner options which must be set when the model is loaded into memory
@@ -171,6 +312,8 @@ type Runner struct {
 	UseMMap   TriState `json:"use_mmap,omitempty"`
 	UseMLock  bool     `json:"use_mlock,omitempty"`
 	NumThread int      `json:"num_thread,omitempty"`
+	Draft     string   `json:"draft,omitempty"`
+	NumDraft  int      `json:"num_draft,omitempty"`
 }
 
 type TriState int
@@ -212,10 +355,26 @@ type EmbeddingRequest struct {
 	// Prompt is the textual prompt to embed.
 	Prompt string `json:"prompt"`
 
+	// Input is an alternative to Prompt for embedding several inputs at once.
+	// It may be a string, a list of strings, a list of token IDs or a list of
+	// lists of token IDs. The embeddings are returned in the same order in
+	// [EmbeddingResponse.Embeddings].
+	Input any `json:"input,omitempty"`
+
+	// Truncate cuts inputs longer than the model's context length down to
+	// size instead of returning an error. It only applies to Input.
+	Truncate bool `json:"truncate,omitempty"`
+
+	// Normalize scales each embedding to unit length (L2 norm).
+	Normalize bool `json:"normalize,omitempty"`
+
 	// KeepAlive controls how long the model will stay loaded in memory following
 	// this request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// Priority is the scheduling class of the request, as in [GenerateRequest].
+	Priority string `json:"priority,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
@@ -223,6 +382,64 @@ type EmbeddingRequest struct {
 // EmbeddingResponse is the response from [Client.Embeddings].
 type EmbeddingResponse struct {
 	Embedding []float64 `json:"embedding"`
+
+	// Embeddings is set instead of Embedding when the request uses Input.
+	Embeddings [][]float64 `json:"embeddings,omitempty"`
+
+	// PromptEvalCount is the number of input tokens embedded when the
+	// request uses Input.
+	PromptEvalCount int `json:"prompt_eval_count,omitempty"`
+}
+
+// TokenizeRequest is the request passed to [Client.Tokenize].
+type TokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Prompt is the text to tokenize. It is tokenized as is, without applying
+	// the model's template or adding special tokens.
+	Prompt string `json:"prompt"`
+
+	// Pieces requests the text of each token in [TokenizeResponse.Pieces].
+	Pieces bool `json:"pieces,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// TokenizeResponse is the response from [Client.Tokenize].
+type TokenizeResponse struct {
+	Model  string   `json:"model"`
+	Tokens []int    `json:"tokens"`
+	Count  int      `json:"count"`
+	Pieces []string `json:"pieces,omitempty"`
+}
+
+// DetokenizeRequest is the request passed to [Client.Detokenize].
+type DetokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Tokens are the token IDs to convert back to text.
+	Tokens []int `json:"tokens"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// DetokenizeResponse is the response from [Client.Detokenize].
+type DetokenizeResponse struct {
+	Model   string `json:"model"`
+	Content string `json:"content"`
+	Count   int    `json:"count"`
 }
 
 // CreateRequest is the request passed to [Client.Create].
@@ -248,6 +465,57 @@ type DeleteRequest struct {
 	Name string `json:"name"`
 }
 
+// LoadRequest is the request passed to [Client.Load].
+type LoadRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// KeepAlive controls how long the model will stay loaded in memory
+	// following this request. It has no effect if the model is pinned.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Pinned keeps the model loaded until it is unloaded with
+	// [Client.Unload], rather than until its keep alive expires or room is
+	// needed for another model. Loading a pinned model with Pinned unset
+	// unpins it.
+	Pinned bool `json:"pinned,omitempty"`
+
+	// Options lists model-specific options the model is loaded with.
+	Options map[string]interface{} `json:"options"`
+}
+
+// UnloadRequest is the request passed to [Client.Unload].
+type UnloadRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+}
+
+// SnapshotRequest is the request passed to [Client.Snapshot].
+type SnapshotRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Prompt is evaluated as is, without the model's template, and should be
+	// the start of the prompts the snapshot is restored for.
+	Prompt string `json:"prompt"`
+
+	// KeepAlive controls how long the model will stay loaded in memory
+	// following this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// SnapshotResponse is the response returned from [Client.Snapshot].
+type SnapshotResponse struct {
+	// Snapshot is the digest of the prompt, which identifies the snapshot
+	// together with the model.
+	Snapshot string `json:"snapshot"`
+
+	Metrics
+}
+
 // ShowRequest is the request passed to [Client.Show].
 type ShowRequest struct {
 	Model    string `json:"model"`
@@ -262,14 +530,15 @@ type ShowRequest struct {
 
 // ShowResponse is the response returned from [Client.Show].
 type ShowResponse struct {
-	License    string       `json:"license,omitempty"`
-	Modelfile  string       `json:"modelfile,omitempty"`
-	Parameters string       `json:"parameters,omitempty"`
-	Template   string       `json:"template,omitempty"`
-	System     string       `json:"system,omitempty"`
-	Details    ModelDetails `json:"details,omitempty"`
-	Messages   []Message    `json:"messages,omitempty"`
-	ModifiedAt time.Time    `json:"modified_at,omitempty"`
+	License    string           `json:"license,omitempty"`
+	Modelfile  string           `json:"modelfile,omitempty"`
+	Parameters string           `json:"parameters,omitempty"`
+	Template   string           `json:"template,omitempty"`
+	System     string           `json:"system,omitempty"`
+	Details    ModelDetails     `json:"details,omitempty"`
+	Adapters   []AdapterDetails `json:"adapters,omitempty"`
+	Messages   []Message        `json:"messages,omitempty"`
+	ModifiedAt time.Time        `json:"modified_at,omitempty"`
 }
 
 // CopyRequest is the request passed to [Client.Copy].
@@ -319,6 +588,17 @@ type ListResponse struct {
 // ProcessResponse is the response from [Client.Process].
 type ProcessResponse struct {
 	Models []ProcessModelResponse `json:"models"`
+
+	// PreloadErrors lists the models configured with OLLAMA_PRELOAD that
+	// failed to load when the server started
+	PreloadErrors []PreloadError `json:"preload_errors,omitempty"`
+}
+
+// PreloadError is a model in [ProcessResponse] that failed to load when the
+// server started.
+type PreloadError struct {
+	Model string `json:"model"`
+	Error string `json:"error"`
 }
 
 // ListModelResponse is a single model description in [ListResponse].
@@ -340,6 +620,29 @@ type ProcessModelResponse struct {
 	Details   ModelDetails `json:"details,omitempty"`
 	ExpiresAt time.Time    `json:"expires_at"`
 	SizeVRAM  int64        `json:"size_vram"`
+	Pinned    bool         `json:"pinned,omitempty"`
+}
+
+// ListRequestsResponse is the response from [Client.ListRequests].
+type ListRequestsResponse struct {
+	Requests []RequestInfo `json:"requests"`
+}
+
+// RequestInfo describes a request that is in progress in
+// [ListRequestsResponse].
+type RequestInfo struct {
+	ID string `json:"id"`
+
+	// Route is the endpoint the request was made to, such as /api/generate.
+	Route string `json:"route"`
+
+	Model string `json:"model"`
+
+	// Client is the name of the API key the request was made with, or else
+	// the address of the client.
+	Client string `json:"client"`
+
+	StartedAt time.Time `json:"started_at"`
 }
 
 type TokenResponse struct {
@@ -363,13 +666,27 @@ type GenerateResponse struct {
 	// DoneReason is the reason the model stopped generating text.
 	DoneReason string `json:"done_reason,omitempty"`
 
+	// RequestID identifies the request in [Client.ListRequests] and
+	// [Client.CancelRequest]. It is only set in the first response.
+	RequestID string `json:"request_id,omitempty"`
+
 	// Context is an encoding of the conversation used in this response; this
 	// can be sent in the next request to keep a conversational memory.
 	Context []int `json:"context,omitempty"`
 
+	// Logprobs holds the log probabilities of the tokens in Response, if
+	// requested.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
 	Metrics
 }
 
+// AdapterDetails provides details about a LoRA adapter applied to a model.
+type AdapterDetails struct {
+	Digest string  `json:"digest"`
+	Scale  float32 `json:"scale"`
+}
+
 // ModelDetails provides details about a model.
 type ModelDetails struct {
 	ParentModel       string   `json:"parent_model"`
@@ -393,6 +710,10 @@ func (m *Metrics) Summary() {
 		fmt.Fprintf(os.Stderr, "prompt eval count:    %d token(s)\n", m.PromptEvalCount)
 	}
 
+	if m.PromptCacheCount > 0 {
+		fmt.Fprintf(os.Stderr, "prompt cache count:   %d token(s)\n", m.PromptCacheCount)
+	}
+
 	if m.PromptEvalDuration > 0 {
 		fmt.Fprintf(os.Stderr, "prompt eval duration: %s\n", m.PromptEvalDuration)
 		fmt.Fprintf(os.Stderr, "prompt eval rate:     %.2f tokens/s\n", float64(m.PromptEvalCount)/m.PromptEvalDuration.Seconds())
@@ -406,6 +727,11 @@ func (m *Metrics) Summary() {
 		fmt.Fprintf(os.Stderr, "eval duration:        %s\n", m.EvalDuration)
 		fmt.Fprintf(os.Stderr, "eval rate:            %.2f tokens/s\n", float64(m.EvalCount)/m.EvalDuration.Seconds())
 	}
+
+	if m.DraftCount > 0 {
+		fmt.Fprintf(os.Stderr, "draft count:          %d token(s)\n", m.DraftCount)
+		fmt.Fprintf(os.Stderr, "draft acceptance:     %.2f%%\n", 100*float64(m.DraftAcceptedCount)/float64(m.DraftCount))
+	}
 }
 
 func (opts *Options) FromMap(m map[string]interface{}) error {
@@ -537,6 +863,7 @@ func DefaultOptions() Options {
 			UseMLock:  false,
 			UseMMap:   TriStateUndefined,
 			UseNUMA:   false,
+			NumDraft:  5,
 		},
 	}
 }
@@ -141,3 +141,31 @@ func TestUseMmapParsingFromJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestToolFunctionParameters(t *testing.T) {
+	parameters := `{
+		"type": "object",
+		"required": ["location"],
+		"properties": {
+			"location": {
+				"type": "object",
+				"properties": {"city": {"type": "string"}, "country": {"type": "string", "default": "FR"}}
+			},
+			"days": {"type": "array", "items": {"type": "integer", "minimum": 1}},
+			"unit": {"anyOf": [{"enum": ["celsius", "fahrenheit"]}, {"type": "null"}]}
+		}
+	}`
+
+	var tool Tool
+	err := json.Unmarshal([]byte(`{"type": "function", "function": {"name": "get_weather", "parameters": `+parameters+`}}`), &tool)
+	require.NoError(t, err)
+	assert.JSONEq(t, parameters, tool.Function.Parameters.String())
+
+	b, err := json.Marshal(tool)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type": "function", "function": {"name": "get_weather", "description": "", "parameters": `+parameters+`}}`, string(b))
+
+	b, err = json.Marshal(Tool{Type: "function", Function: ToolFunction{Name: "get_time"}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type": "function", "function": {"name": "get_time", "description": ""}}`, string(b))
+}
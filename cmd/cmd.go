@@ -7,6 +7,7 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -82,7 +83,14 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 	for i := range modelfile.Commands {
 		switch modelfile.Commands[i].Name {
 		case "model", "adapter":
-			path := modelfile.Commands[i].Args
+			path, scale := modelfile.Commands[i].Args, float32(1)
+			if modelfile.Commands[i].Name == "adapter" {
+				path, scale, err = parser.ParseAdapter(path)
+				if err != nil {
+					return err
+				}
+			}
+
 			if path == "~" {
 				path = home
 			} else if strings.HasPrefix(path, "~/") {
@@ -101,8 +109,8 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 			}
 
 			if fi.IsDir() {
-				// this is likely a safetensors or pytorch directory
-				// TODO make this work w/ adapters
+				// this is likely a safetensors or pytorch directory, or a
+				// safetensors adapter
 				tempfile, err := tempZipFiles(path)
 				if err != nil {
 					return err
@@ -118,6 +126,9 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 			}
 
 			modelfile.Commands[i].Args = "@" + digest
+			if scale != 1 {
+				modelfile.Commands[i].Args = fmt.Sprintf("@%s %g", digest, scale)
+			}
 		}
 	}
 
@@ -205,6 +216,9 @@ func tempZipFiles(path string) (string, error) {
 		// safetensors files might be unresolved git lfs references; skip if they are
 		// covers model-x-of-y.safetensors, model.fp32-x-of-y.safetensors, model.safetensors
 		files = append(files, st...)
+	} else if st, _ := glob(filepath.Join(path, "adapter_model*.safetensors"), "application/octet-stream"); len(st) > 0 {
+		// a PEFT adapter; adapter_config.json is picked up with the other json files
+		files = append(files, st...)
 	} else if pt, _ := glob(filepath.Join(path, "pytorch_model*.bin"), "application/zip"); len(pt) > 0 {
 		// pytorch files might also be unresolved git lfs references; skip if they are
 		// covers pytorch_model-x-of-y.bin, pytorch_model.fp32-x-of-y.bin, pytorch_model.bin
@@ -539,7 +553,11 @@ func ListRunningHandler(cmd *cobra.Command, args []string) error {
 				cpuPercent := math.Round(float64(sizeCPU) / float64(m.Size) * 100)
 				procStr = fmt.Sprintf("%d%%/%d%% CPU/GPU", int(cpuPercent), int(100-cpuPercent))
 			}
-			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), procStr, format.HumanTime(m.ExpiresAt, "Never")})
+			until := format.HumanTime(m.ExpiresAt, "Never")
+			if m.Pinned {
+				until = "Pinned"
+			}
+			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), procStr, until})
 		}
 	}
 
@@ -554,6 +572,60 @@ func ListRunningHandler(cmd *cobra.Command, args []string) error {
 	table.AppendBulk(data)
 	table.Render()
 
+	for _, e := range models.PreloadErrors {
+		fmt.Fprintf(os.Stderr, "failed to preload '%s': %s\n", e.Model, e.Error)
+	}
+
+	return nil
+}
+
+func LoadHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	pinned, err := cmd.Flags().GetBool("pin")
+	if err != nil {
+		return err
+	}
+
+	req := api.LoadRequest{Pinned: pinned}
+
+	keepAlive, err := cmd.Flags().GetString("keepalive")
+	if err != nil {
+		return err
+	}
+	if keepAlive != "" {
+		d, err := time.ParseDuration(keepAlive)
+		if err != nil {
+			return err
+		}
+		req.KeepAlive = &api.Duration{Duration: d}
+	}
+
+	for _, name := range args {
+		req.Model = name
+		if err := client.Load(cmd.Context(), &req); err != nil {
+			return err
+		}
+		fmt.Printf("loaded '%s'\n", name)
+	}
+	return nil
+}
+
+func StopHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args {
+		if err := client.Unload(cmd.Context(), &api.UnloadRequest{Model: name}); err != nil {
+			return err
+		}
+		fmt.Printf("stopped '%s'\n", name)
+	}
 	return nil
 }
 
@@ -834,7 +906,7 @@ func chat(cmd *cobra.Command, opts runOptions) (*api.Message, error) {
 	req := &api.ChatRequest{
 		Model:    opts.Model,
 		Messages: opts.Messages,
-		Format:   opts.Format,
+		Format:   requestFormat(opts.Format),
 		Options:  opts.Options,
 	}
 
@@ -866,6 +938,21 @@ func chat(cmd *cobra.Command, opts runOptions) (*api.Message, error) {
 	return &api.Message{Role: role, Content: fullResponse.String()}, nil
 }
 
+// requestFormat converts the --format flag into a request format. The flag is
+// either a format name such as "json" or an inline JSON schema object.
+func requestFormat(format string) json.RawMessage {
+	if format == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(format), "{") && json.Valid([]byte(format)) {
+		return json.RawMessage(format)
+	}
+
+	bts, _ := json.Marshal(format)
+	return bts
+}
+
 func generate(cmd *cobra.Command, opts runOptions) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -921,7 +1008,7 @@ func generate(cmd *cobra.Command, opts runOptions) error {
 		Prompt:    opts.Prompt,
 		Context:   generateContext,
 		Images:    opts.Images,
-		Format:    opts.Format,
+		Format:    requestFormat(opts.Format),
 		System:    opts.System,
 		Template:  opts.Template,
 		Options:   opts.Options,
@@ -1139,7 +1226,7 @@ func NewCLI() *cobra.Command {
 	runCmd.Flags().Bool("verbose", false, "Show timings for response")
 	runCmd.Flags().Bool("insecure", false, "Use an insecure registry")
 	runCmd.Flags().Bool("nowordwrap", false, "Don't wrap words to the next line automatically")
-	runCmd.Flags().String("format", "", "Response format (e.g. json)")
+	runCmd.Flags().String("format", "", "Response format (e.g. json or a JSON schema)")
 	serveCmd := &cobra.Command{
 		Use:     "serve",
 		Aliases: []string{"start"},
@@ -1183,6 +1270,25 @@ func NewCLI() *cobra.Command {
 		RunE:    ListRunningHandler,
 	}
 
+	loadCmd := &cobra.Command{
+		Use:     "load MODEL [MODEL...]",
+		Short:   "Load models into memory",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    LoadHandler,
+	}
+
+	loadCmd.Flags().Bool("pin", false, "Keep the models loaded until they are stopped")
+	loadCmd.Flags().String("keepalive", "", "Duration to keep the models loaded (e.g. 5m)")
+
+	stopCmd := &cobra.Command{
+		Use:     "stop MODEL [MODEL...]",
+		Short:   "Unload running models",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    StopHandler,
+	}
+
 	copyCmd := &cobra.Command{
 		Use:     "cp SOURCE DESTINATION",
 		Short:   "Copy a model",
@@ -1201,7 +1307,11 @@ func NewCLI() *cobra.Command {
 
 	envVars := envconfig.AsMap()
 
-	envs := []envconfig.EnvVar{envVars["OLLAMA_HOST"]}
+	// the API key is documented here rather than in AsMap so that it is not
+	// logged with the rest of the configuration
+	apiKeyEnv := envconfig.EnvVar{Name: "OLLAMA_API_KEY", Description: "API key to authenticate with the ollama server"}
+
+	envs := []envconfig.EnvVar{envVars["OLLAMA_HOST"], apiKeyEnv, envVars["OLLAMA_TLS_CA_BUNDLE"], envVars["OLLAMA_TLS_CLIENT_CERT"], envVars["OLLAMA_TLS_CLIENT_KEY"]}
 
 	for _, cmd := range []*cobra.Command{
 		createCmd,
@@ -1211,15 +1321,18 @@ func NewCLI() *cobra.Command {
 		pushCmd,
 		listCmd,
 		psCmd,
+		loadCmd,
+		stopCmd,
 		copyCmd,
 		deleteCmd,
 		serveCmd,
 	} {
 		switch cmd {
 		case runCmd:
-			appendEnvDocs(cmd, []envconfig.EnvVar{envVars["OLLAMA_HOST"], envVars["OLLAMA_NOHISTORY"]})
+			appendEnvDocs(cmd, []envconfig.EnvVar{envVars["OLLAMA_HOST"], apiKeyEnv, envVars["OLLAMA_TLS_CA_BUNDLE"], envVars["OLLAMA_TLS_CLIENT_CERT"], envVars["OLLAMA_TLS_CLIENT_KEY"], envVars["OLLAMA_NOHISTORY"]})
 		case serveCmd:
 			appendEnvDocs(cmd, []envconfig.EnvVar{
+				envVars["OLLAMA_API_KEYS_FILE"],
 				envVars["OLLAMA_DEBUG"],
 				envVars["OLLAMA_HOST"],
 				envVars["OLLAMA_KEEP_ALIVE"],
@@ -1229,10 +1342,16 @@ func NewCLI() *cobra.Command {
 				envVars["OLLAMA_NUM_PARALLEL"],
 				envVars["OLLAMA_NOPRUNE"],
 				envVars["OLLAMA_ORIGINS"],
+				envVars["OLLAMA_PRELOAD"],
 				envVars["OLLAMA_TMPDIR"],
 				envVars["OLLAMA_FLASH_ATTENTION"],
 				envVars["OLLAMA_LLM_LIBRARY"],
 				envVars["OLLAMA_MAX_VRAM"],
+				envVars["OLLAMA_RATE_LIMIT_REQUESTS"],
+				envVars["OLLAMA_RATE_LIMIT_TOKENS"],
+				envVars["OLLAMA_TLS_CERT"],
+				envVars["OLLAMA_TLS_KEY"],
+				envVars["OLLAMA_TLS_CLIENT_CA"],
 			})
 		default:
 			appendEnvDocs(cmd, envs)
@@ -1248,6 +1367,8 @@ func NewCLI() *cobra.Command {
 		pushCmd,
 		listCmd,
 		psCmd,
+		loadCmd,
+		stopCmd,
 		copyCmd,
 		deleteCmd,
 	)
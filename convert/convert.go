@@ -47,6 +47,22 @@ type Params struct {
 	Experts     int `json:"num_local_experts"`
 	ExpertsUsed int `json:"num_experts_per_tok"`
 
+	// Phi-3 models with a longer context scale rope by factors per dimension
+	OriginalContextSize int `json:"original_max_position_embeddings"`
+	RopeScaling         struct {
+		Type        string    `json:"type"`
+		LongFactor  []float32 `json:"long_factor"`
+		ShortFactor []float32 `json:"short_factor"`
+	} `json:"rope_scaling"`
+
+	// StableLM models use layer norm and rotate part of each head
+	LayerNormEPS        float64 `json:"layer_norm_eps"`
+	NormEPSLegacy       float64 `json:"norm_eps"`
+	PartialRotaryFactor float64 `json:"partial_rotary_factor"`
+	RopePercentage      float64 `json:"rope_pct"`
+	UseParallelResidual *bool   `json:"use_parallel_residual"`
+	QKLayerNorm         bool    `json:"qk_layernorm"`
+
 	PreTokenizer string
 
 	ByteOrder
@@ -79,6 +95,98 @@ type ModelData struct {
 	Format  ModelFormat
 }
 
+// getModelArch returns the architecture params describes, reading its tensors
+// in format
+func getModelArch(name, dirPath string, params *Params, format ModelFormat) (ModelArch, error) {
+	switch len(params.Architectures) {
+	case 0:
+		return nil, fmt.Errorf("No architecture specified to convert")
+	case 1:
+		switch params.Architectures[0] {
+		case "LlamaForCausalLM":
+			return &LlamaModel{
+				ModelData{
+					Name:   name,
+					Path:   dirPath,
+					Params: params,
+					Format: format,
+				},
+			}, nil
+		case "MistralForCausalLM":
+			return &MistralModel{
+				ModelData{
+					Name:   name,
+					Path:   dirPath,
+					Params: params,
+					Format: format,
+				},
+			}, nil
+		case "MixtralForCausalLM":
+			return &MixtralModel{
+				ModelData{
+					Name:   name,
+					Path:   dirPath,
+					Params: params,
+					Format: format,
+				},
+			}, nil
+		case "GemmaForCausalLM":
+			return &GemmaModel{
+				ModelData{
+					Name:   name,
+					Path:   dirPath,
+					Params: params,
+					Format: format,
+				},
+			}, nil
+		case "Qwen2ForCausalLM":
+			return &Qwen2Model{
+				ModelData{
+					Name:   name,
+					Path:   dirPath,
+					Params: params,
+					Format: format,
+				},
+			}, nil
+		case "Phi3ForCausalLM":
+			return &Phi3Model{
+				ModelData{
+					Name:   name,
+					Path:   dirPath,
+					Params: params,
+					Format: format,
+				},
+			}, nil
+		case "StableLmForCausalLM", "StableLMEpochForCausalLM":
+			return &StableLMModel{
+				ModelData{
+					Name:   name,
+					Path:   dirPath,
+					Params: params,
+					Format: format,
+				},
+			}, nil
+		default:
+			return nil, fmt.Errorf("Models based on '%s' are not yet supported", params.Architectures[0])
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown error")
+}
+
+// setRepacker sets the function a tensor's data is rearranged with as it is
+// written, whichever format the tensor is read from
+func setRepacker(t *llm.Tensor, repacker func(string, []float32, []uint64) ([]float32, error)) {
+	switch wt := t.WriterTo.(type) {
+	case safetensorWriterTo:
+		wt.repacker = repacker
+		t.WriterTo = wt
+	case torchWriterTo:
+		wt.repacker = repacker
+		t.WriterTo = wt
+	}
+}
+
 func GetModelFormat(dirname string) (ModelFormat, error) {
 	files, err := filepath.Glob(filepath.Join(dirname, "*"))
 	if err != nil {
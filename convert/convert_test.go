@@ -6,60 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
-
-	"github.com/ollama/ollama/llm"
 )
 
-func convertFull(t *testing.T, p string) (llm.KV, llm.Tensors) {
-	t.Helper()
-
-	mf, err := GetModelFormat(p)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	params, err := mf.GetParams(p)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	arch, err := mf.GetModelArch("", p, params)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	if err := arch.LoadVocab(); err != nil {
-		t.Fatal(err)
-	}
-
-	if err := arch.GetTensors(); err != nil {
-		t.Fatal(err)
-	}
-
-	f, err := os.CreateTemp(t.TempDir(), "f16")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer f.Close()
-
-	if err := arch.WriteGGUF(f); err != nil {
-		t.Fatal(err)
-	}
-
-	r, err := os.Open(f.Name())
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer r.Close()
-
-	m, _, err := llm.DecodeGGML(r)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	return m.KV(), m.Tensors()
-}
-
 func TestConvertFull(t *testing.T) {
 	cases := []struct {
 		path    string
@@ -71,6 +19,10 @@ func TestConvertFull(t *testing.T) {
 		{"Mistral-7B-Instruct-v0.2", "llama", 291, 35},
 		{"Mixtral-8x7B-Instruct-v0.1", "llama", 291, 35},
 		{"gemma-2b-it", "gemma", 164, 20},
+		{"Qwen2-0.5B-Instruct", "qwen2", 290, 26},
+		{"Phi-3-mini-4k-instruct", "phi3", 195, 35},
+		{"Phi-3-mini-128k-instruct", "phi3", 197, 37},
+		{"stablelm-2-1_6b-chat", "stablelm", 340, 27},
 	}
 
 	for _, tt := range cases {
@@ -47,9 +47,7 @@ func (m *GemmaModel) GetTensors() error {
 	slog.Debug(fmt.Sprintf("Total tensors: %d", len(t)))
 	for _, l := range t {
 		if strings.HasSuffix(l.Name, "norm.weight") {
-			wt := l.WriterTo.(safetensorWriterTo)
-			wt.repacker = m.Repack
-			l.WriterTo = wt
+			setRepacker(&l, m.Repack)
 		}
 		m.Tensors = append(m.Tensors, l)
 	}
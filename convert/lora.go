@@ -0,0 +1,242 @@
+package convert
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// AdapterParams are the parameters of a PEFT LoRA adapter, read from its
+// adapter_config.json
+type AdapterParams struct {
+	PeftType    string  `json:"peft_type"`
+	Rank        int     `json:"r"`
+	Alpha       float64 `json:"lora_alpha"`
+	FanInFanOut bool    `json:"fan_in_fan_out"`
+	UseRSLoRA   bool    `json:"use_rslora"`
+	UseDoRA     bool    `json:"use_dora"`
+}
+
+// Adapter is a PEFT LoRA adapter converted for the base model it was tuned
+// from, which its tensors are checked against
+type Adapter struct {
+	Path    string
+	Params  *AdapterParams
+	Base    *llm.GGML
+	Tensors []llm.Tensor
+}
+
+// IsAdapter reports whether dirpath holds a PEFT adapter rather than a model
+func IsAdapter(dirpath string) bool {
+	_, err := os.Stat(filepath.Join(dirpath, "adapter_config.json"))
+	return err == nil
+}
+
+// NewAdapter reads the configuration of the PEFT adapter in dirpath, which is
+// to be applied to base
+func NewAdapter(dirpath string, base *llm.GGML) (*Adapter, error) {
+	f, err := os.Open(filepath.Join(dirpath, "adapter_config.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var params AdapterParams
+	if err := json.NewDecoder(f).Decode(&params); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case params.PeftType != "" && !strings.EqualFold(params.PeftType, "lora"):
+		return nil, fmt.Errorf("%s adapters are not supported", params.PeftType)
+	case params.Rank <= 0:
+		return nil, fmt.Errorf("invalid adapter rank %d", params.Rank)
+	case params.Alpha <= 0 || params.Alpha != math.Trunc(params.Alpha):
+		return nil, fmt.Errorf("invalid adapter alpha %g", params.Alpha)
+	case params.FanInFanOut:
+		return nil, errors.New("adapters with fan_in_fan_out are not supported")
+	case params.UseRSLoRA:
+		return nil, errors.New("rank-stabilized adapters are not supported")
+	case params.UseDoRA:
+		return nil, errors.New("DoRA adapters are not supported")
+	}
+
+	if base == nil || base.Name() != "gguf" {
+		return nil, errors.New("adapters must follow a GGUF base model")
+	}
+
+	switch arch := base.KV().Architecture(); arch {
+	case "llama", "gemma":
+	default:
+		return nil, fmt.Errorf("adapters for %s models are not yet supported", arch)
+	}
+
+	return &Adapter{Path: dirpath, Params: &params, Base: base}, nil
+}
+
+// GetTensors reads the adapter's tensors, checking that each pair of LoRA
+// matrices matches the shape of the base model tensor they apply to
+func (a *Adapter) GetTensors() error {
+	matches, err := filepath.Glob(filepath.Join(a.Path, "adapter_model*.safetensors"))
+	if err != nil {
+		return err
+	} else if len(matches) == 0 {
+		return errors.New("no adapter_model.safetensors found")
+	}
+
+	base := make(map[string]*llm.Tensor)
+	for _, t := range a.Base.Tensors() {
+		base[t.Name] = t
+	}
+
+	params := &Params{
+		AttentionHeads: int(a.Base.KV().HeadCount()),
+		KeyValHeads:    int(a.Base.KV().HeadCountKV()),
+		ByteOrder:      binary.LittleEndian,
+	}
+
+	// pairs records which of the LoRA matrices of each tensor were found
+	pairs := make(map[string][2]bool)
+	for _, fn := range matches {
+		n, headers, err := readSafetensorsHeader(fn)
+		if err != nil {
+			return err
+		}
+
+		var keys []string
+		for key, value := range headers {
+			if len(value.Shape) > 0 {
+				keys = append(keys, key)
+			}
+		}
+
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			value := headers[key]
+
+			name, lora, err := adapterLayerName(key)
+			if err != nil {
+				return err
+			}
+
+			b, ok := base[name]
+			if !ok {
+				return fmt.Errorf("adapter tensor %s doesn't match a tensor of the base model", key)
+			}
+
+			// base model shapes are reversed: inputs then outputs
+			in, out := b.Shape[0], b.Shape[1]
+			rank := uint64(a.Params.Rank)
+			if len(value.Shape) != 2 ||
+				(lora == "A" && !slices.Equal(value.Shape, []uint64{rank, in})) ||
+				(lora == "B" && !slices.Equal(value.Shape, []uint64{out, rank})) {
+				return fmt.Errorf("adapter tensor %s has shape %v, which doesn't fit %s %v of the base model with rank %d", key, value.Shape, name, []uint64{out, in}, rank)
+			}
+
+			t := llm.Tensor{
+				Name:  name + ".lora" + lora,
+				Shape: []uint64{out, rank},
+			}
+
+			w := safetensorWriterTo{
+				t:        &t,
+				params:   params,
+				bo:       params.ByteOrder,
+				filename: fn,
+				dtype:    value.Type,
+				offset:   8 + n + value.Offsets[0],
+				size:     value.Offsets[1] - value.Offsets[0],
+			}
+
+			switch lora {
+			case "A":
+				// A is stored transposed so that both matrices share the
+				// rank as their first dimension
+				t.Kind = 1
+				t.Shape = []uint64{in, rank}
+				w.repacker = func(_ string, data []float32, _ []uint64) ([]float32, error) {
+					return transpose(data, int(rank), int(in)), nil
+				}
+			case "B":
+				if a.Base.KV().Architecture() == "llama" &&
+					(strings.HasSuffix(name, "attn_q.weight") || strings.HasSuffix(name, "attn_k.weight")) {
+					// the rows of q and k are permuted in the base model
+					w.repacker = func(_ string, data []float32, shape []uint64) ([]float32, error) {
+						return llamaRepack(name, params, data, shape)
+					}
+				}
+			}
+
+			t.WriterTo = w
+			a.Tensors = append(a.Tensors, t)
+
+			pair := pairs[name]
+			pair[strings.Index("AB", lora)] = true
+			pairs[name] = pair
+		}
+	}
+
+	for name, pair := range pairs {
+		if !pair[0] || !pair[1] {
+			return fmt.Errorf("adapter for %s is missing a LoRA matrix", name)
+		}
+	}
+
+	return nil
+}
+
+// WriteGGLA writes the adapter in the format the runner loads adapters in
+func (a *Adapter) WriteGGLA(ws io.WriteSeeker) error {
+	kv := llm.KV{
+		"r":     uint32(a.Params.Rank),
+		"alpha": uint32(a.Params.Alpha),
+	}
+
+	return llm.NewGGLA().Encode(ws, kv, a.Tensors)
+}
+
+// adapterLayerName returns the name of the base model tensor a PEFT tensor
+// applies to and which of the LoRA matrices it is, A or B
+func adapterLayerName(n string) (string, string, error) {
+	name := strings.TrimPrefix(n, "base_model.model.")
+	name = strings.Replace(name, ".default.weight", ".weight", 1)
+
+	var lora string
+	switch {
+	case strings.HasSuffix(name, ".lora_A.weight"):
+		lora = "A"
+	case strings.HasSuffix(name, ".lora_B.weight"):
+		lora = "B"
+	default:
+		return "", "", fmt.Errorf("unsupported adapter tensor %s", n)
+	}
+
+	name = strings.TrimSuffix(name, ".lora_"+lora+".weight") + ".weight"
+	name, err := (&SafetensorFormat{}).GetLayerName(name)
+	if err != nil {
+		return "", "", fmt.Errorf("unsupported adapter tensor %s", n)
+	}
+
+	return name, lora, nil
+}
+
+func transpose(data []float32, rows, cols int) []float32 {
+	t := make([]float32, len(data))
+	for i := range rows {
+		for j := range cols {
+			t[j*rows+i] = data[i*cols+j]
+		}
+	}
+
+	return t
+}
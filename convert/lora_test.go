@@ -0,0 +1,229 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/x448/float16"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// loraBase returns a model of arch with a single 8x6 attn_q tensor and 2 heads
+func loraBase(t *testing.T, arch string) *llm.GGML {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the encoder only knows the keys of llama models
+	kv := llm.KV{
+		"general.architecture":          arch,
+		"llama.attention.head_count":    uint32(2),
+		"llama.attention.head_count_kv": uint32(2),
+	}
+
+	tensors := []llm.Tensor{{
+		Name:     "blk.0.attn_q.weight",
+		Shape:    []uint64{8, 6},
+		WriterTo: bytes.NewReader(make([]byte, 8*6*4)),
+	}}
+
+	if err := llm.NewGGUFV3(binary.LittleEndian).Encode(f, kv, tensors); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	ggml, _, err := llm.DecodeGGML(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ggml
+}
+
+type loraTensor struct {
+	name  string
+	shape []uint64
+}
+
+// loraDir writes a PEFT adapter with config and F32 tensors counting up from 0
+func loraDir(t *testing.T, config map[string]any, tensors []loraTensor) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "adapter_config.json"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var data []float32
+	header := make(map[string]safetensorMetadata)
+	for _, tt := range tensors {
+		n := int(tt.shape[0] * tt.shape[1])
+		header[tt.name] = safetensorMetadata{
+			Type:    "F32",
+			Shape:   tt.shape,
+			Offsets: []int64{int64(len(data) * 4), int64((len(data) + n) * 4)},
+		}
+
+		for i := range n {
+			data = append(data, float32(i))
+		}
+	}
+
+	b, err = json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var st bytes.Buffer
+	for _, v := range []any{int64(len(b)), b, data} {
+		if err := binary.Write(&st, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "adapter_model.safetensors"), st.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestConvertAdapter(t *testing.T) {
+	config := map[string]any{"peft_type": "LORA", "r": 2, "lora_alpha": 16}
+	dir := loraDir(t, config, []loraTensor{
+		{"base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight", []uint64{2, 6}},
+		{"base_model.model.model.layers.0.self_attn.q_proj.lora_B.weight", []uint64{8, 2}},
+	})
+
+	adapter, err := NewAdapter(dir, loraBase(t, "llama"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := adapter.GetTensors(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "ggla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := adapter.WriteGGLA(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	ggml, _, err := llm.DecodeGGML(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ggml.Name() != "ggla" || ggml.KV()["r"] != uint32(2) || ggml.KV()["alpha"] != uint32(16) {
+		t.Fatalf("unexpected adapter %s %v", ggml.Name(), ggml.KV())
+	}
+
+	tensors := ggml.Tensors()
+	if len(tensors) != 2 {
+		t.Fatalf("expected 2 tensors, got %d", len(tensors))
+	}
+
+	read := func(tensor *llm.Tensor, v any) {
+		t.Helper()
+		if _, err := f.Seek(int64(tensor.Offset), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := binary.Read(f, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A is transposed to 6x2 and stored as F16
+	a := tensors[0]
+	if a.Name != "blk.0.attn_q.weight.loraA" || a.Kind != 1 || !slices.Equal(a.Shape, []uint64{6, 2}) {
+		t.Errorf("unexpected tensor %s %d %v", a.Name, a.Kind, a.Shape)
+	}
+
+	f16s := make([]uint16, 4)
+	read(a, f16s)
+	for i, want := range []float32{0, 6, 1, 7} {
+		if got := float16.Frombits(f16s[i]).Float32(); got != want {
+			t.Errorf("loraA[%d]: expected %g, got %g", i, want, got)
+		}
+	}
+
+	// B has its rows permuted like the base model's q
+	b := tensors[1]
+	if b.Name != "blk.0.attn_q.weight.loraB" || b.Kind != 0 || !slices.Equal(b.Shape, []uint64{8, 2}) {
+		t.Errorf("unexpected tensor %s %d %v", b.Name, b.Kind, b.Shape)
+	}
+
+	f32s := make([]float32, 16)
+	read(b, f32s)
+	for i, row := range []float32{0, 2, 1, 3, 4, 6, 5, 7} {
+		if f32s[i*2] != row*2 {
+			t.Errorf("loraB row %d: expected row %g, got %v", i, row, f32s[i*2:i*2+2])
+		}
+	}
+}
+
+func TestConvertAdapterErrors(t *testing.T) {
+	a := loraTensor{"base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight", []uint64{2, 6}}
+	b := loraTensor{"base_model.model.model.layers.0.self_attn.q_proj.lora_B.weight", []uint64{8, 2}}
+	config := map[string]any{"peft_type": "LORA", "r": 2, "lora_alpha": 16}
+
+	cases := []struct {
+		name    string
+		arch    string
+		config  map[string]any
+		tensors []loraTensor
+	}{
+		{"unsupported arch", "phi3", config, []loraTensor{a, b}},
+		{"not lora", "llama", map[string]any{"peft_type": "IA3", "r": 2, "lora_alpha": 16}, []loraTensor{a, b}},
+		{"rank mismatch", "llama", map[string]any{"peft_type": "LORA", "r": 4, "lora_alpha": 16}, []loraTensor{a, b}},
+		{"shape mismatch", "llama", config, []loraTensor{a, {b.name, []uint64{4, 2}}}},
+		{"missing base tensor", "llama", config, []loraTensor{
+			{"base_model.model.model.layers.0.self_attn.v_proj.lora_A.weight", []uint64{2, 6}},
+			{"base_model.model.model.layers.0.self_attn.v_proj.lora_B.weight", []uint64{8, 2}},
+		}},
+		{"missing pair", "llama", config, []loraTensor{a}},
+		{"not a lora tensor", "llama", config, []loraTensor{a, b, {"base_model.model.lm_head.weight", []uint64{8, 6}}}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter, err := NewAdapter(loraDir(t, tt.config, tt.tensors), loraBase(t, tt.arch))
+			if err == nil {
+				err = adapter.GetTensors()
+			}
+
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
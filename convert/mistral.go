@@ -26,9 +26,7 @@ func (m *MistralModel) GetTensors() error {
 	for _, l := range t {
 		matches := re.FindAllStringSubmatch(l.Name, -1)
 		if len(matches) > 0 {
-			wt := l.WriterTo.(safetensorWriterTo)
-			wt.repacker = m.Repack
-			l.WriterTo = wt
+			setRepacker(&l, m.Repack)
 		}
 		m.Tensors = append(m.Tensors, l)
 	}
@@ -0,0 +1,116 @@
+package convert
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/ollama/ollama/llm"
+)
+
+type Phi3Model struct {
+	ModelData
+}
+
+// ropeFactors writes the rope scaling factors of a long context Phi-3 model,
+// which are stored as tensors
+type ropeFactors []float32
+
+func (r ropeFactors) WriteTo(w io.Writer) (int64, error) {
+	return 0, binary.Write(w, binary.LittleEndian, []float32(r))
+}
+
+func (m *Phi3Model) GetTensors() error {
+	t, err := m.Format.GetTensors(m.Path, m.Params)
+	if err != nil {
+		return err
+	}
+
+	m.Tensors = append(m.Tensors, t...)
+
+	for _, factors := range []struct {
+		name    string
+		factors []float32
+	}{
+		{"rope_factors_long.weight", m.Params.RopeScaling.LongFactor},
+		{"rope_factors_short.weight", m.Params.RopeScaling.ShortFactor},
+	} {
+		if len(factors.factors) > 0 {
+			m.Tensors = append(m.Tensors, llm.Tensor{
+				Name:     factors.name,
+				Shape:    []uint64{uint64(len(factors.factors))},
+				WriterTo: ropeFactors(factors.factors),
+			})
+		}
+	}
+
+	return nil
+}
+
+func (m *Phi3Model) LoadVocab() error {
+	v, err := LoadSentencePieceTokens(m.Path, m.Params)
+	if err != nil {
+		return err
+	}
+	m.Vocab = v
+	return nil
+}
+
+func (m *Phi3Model) WriteGGUF(ws io.WriteSeeker) error {
+	kv := llm.KV{
+		"general.architecture":                  "phi3",
+		"general.name":                          m.Name,
+		"phi3.context_length":                   uint32(m.Params.ContextSize),
+		"phi3.embedding_length":                 uint32(m.Params.HiddenSize),
+		"phi3.block_count":                      uint32(m.Params.HiddenLayers),
+		"phi3.feed_forward_length":              uint32(m.Params.IntermediateSize),
+		"phi3.attention.head_count":             uint32(m.Params.AttentionHeads),
+		"phi3.attention.head_count_kv":          uint32(m.Params.KeyValHeads),
+		"phi3.attention.layer_norm_rms_epsilon": float32(m.Params.NormEPS),
+		"phi3.rope.dimension_count":             uint32(m.Params.HiddenSize / m.Params.AttentionHeads),
+		"phi3.rope.freq_base":                   float32(m.Params.RopeFrequencyBase),
+		"general.file_type":                     uint32(1),
+		"tokenizer.ggml.model":                  "llama",
+
+		"tokenizer.ggml.tokens":     m.Vocab.Tokens,
+		"tokenizer.ggml.scores":     m.Vocab.Scores,
+		"tokenizer.ggml.token_type": m.Vocab.Types,
+
+		"tokenizer.ggml.bos_token_id":     uint32(m.Params.BoSTokenID),
+		"tokenizer.ggml.eos_token_id":     uint32(m.Params.EoSTokenID),
+		"tokenizer.ggml.padding_token_id": uint32(m.Params.PaddingTokenID),
+		"tokenizer.ggml.unknown_token_id": uint32(0),
+		"tokenizer.ggml.add_bos_token":    false,
+		"tokenizer.ggml.add_eos_token":    false,
+	}
+
+	if m.Params.OriginalContextSize > 0 {
+		kv["phi3.rope.scaling.original_context_length"] = uint32(m.Params.OriginalContextSize)
+	}
+
+	// the attention factor makes up for the context extended beyond the
+	// context the model was trained with
+	scale := 1.0
+	if m.Params.OriginalContextSize > 0 {
+		scale = float64(m.Params.ContextSize) / float64(m.Params.OriginalContextSize)
+	}
+
+	switch m.Params.RopeScaling.Type {
+	case "":
+	case "su", "longrope":
+		kv["phi3.rope.scaling.attn_factor"] = float32(1)
+		if scale > 1 {
+			kv["phi3.rope.scaling.attn_factor"] = float32(math.Sqrt(1 + math.Log(scale)/math.Log(float64(m.Params.OriginalContextSize))))
+		}
+	case "yarn":
+		kv["phi3.rope.scaling.attn_factor"] = float32(1)
+		if scale > 1 {
+			kv["phi3.rope.scaling.attn_factor"] = float32(0.1*math.Log(scale) + 1)
+		}
+	default:
+		return fmt.Errorf("unsupported rope scaling type %s", m.Params.RopeScaling.Type)
+	}
+
+	return llm.NewGGUFV3(m.Params.ByteOrder).Encode(ws, kv, m.Tensors)
+}
@@ -0,0 +1,90 @@
+package convert
+
+import (
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPhi3RopeScaling(t *testing.T) {
+	config := map[string]any{
+		"architectures":                    []string{"Phi3ForCausalLM"},
+		"hidden_size":                      8,
+		"num_hidden_layers":                1,
+		"num_attention_heads":              2,
+		"max_position_embeddings":          131072,
+		"original_max_position_embeddings": 4096,
+	}
+
+	with := func(kv map[string]any) map[string]any {
+		c := maps.Clone(config)
+		maps.Copy(c, kv)
+		return c
+	}
+
+	long := []float32{1, 1.5, 2, 4}
+	short := []float32{1, 1, 1.25, 1.5}
+	scaling := func(kind string) map[string]any {
+		return map[string]any{"type": kind, "long_factor": long, "short_factor": short}
+	}
+
+	// the context is 32 times longer than the original
+	su := float32(math.Sqrt(1 + math.Log(32)/math.Log(4096)))
+	yarn := float32(0.1*math.Log(32) + 1)
+
+	cases := []struct {
+		name       string
+		config     map[string]any
+		attnFactor any
+		factors    bool
+	}{
+		{"none", with(map[string]any{"max_position_embeddings": 4096, "original_max_position_embeddings": 0}), nil, false},
+		{"su", with(map[string]any{"rope_scaling": scaling("su")}), su, true},
+		{"longrope", with(map[string]any{"rope_scaling": scaling("longrope")}), su, true},
+		{"yarn", with(map[string]any{"rope_scaling": scaling("yarn")}), yarn, true},
+		{"original context", with(map[string]any{"max_position_embeddings": 4096, "rope_scaling": scaling("su")}), float32(1), true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := t.TempDir()
+			writeJSON(t, filepath.Join(p, "config.json"), tt.config)
+			writeSentencePiece(t, p)
+			writeTorch(t, filepath.Join(p, "pytorch_model.bin"), []string{
+				"model.embed_tokens.weight",
+				"model.layers.0.self_attn.qkv_proj.weight",
+			})
+
+			kv, _ := convertFull(t, p)
+			if got := kv["phi3.rope.scaling.attn_factor"]; got != tt.attnFactor {
+				t.Errorf("expected attn_factor %v, got %v", tt.attnFactor, got)
+			}
+
+			data := tensorData(t, p)
+			for name, want := range map[string][]float32{
+				"rope_factors_long.weight":  long,
+				"rope_factors_short.weight": short,
+			} {
+				got, ok := data[name]
+				if ok != tt.factors {
+					t.Fatalf("expected %s %t, got %t", name, tt.factors, ok)
+				}
+
+				if ok && !slices.Equal(got, want) {
+					t.Errorf("%s: expected %v, got %v", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPhi3RopeScalingUnsupported(t *testing.T) {
+	m := Phi3Model{ModelData{Params: &Params{HiddenSize: 8, AttentionHeads: 2}, Vocab: &Vocab{}}}
+	m.Params.RopeScaling.Type = "linear"
+
+	if err := m.WriteGGUF(nil); err == nil {
+		t.Error("expected an error for an unsupported rope scaling type")
+	}
+}
@@ -0,0 +1,59 @@
+package convert
+
+import (
+	"io"
+
+	"github.com/ollama/ollama/llm"
+)
+
+type Qwen2Model struct {
+	ModelData
+}
+
+func (m *Qwen2Model) GetTensors() error {
+	t, err := m.Format.GetTensors(m.Path, m.Params)
+	if err != nil {
+		return err
+	}
+
+	m.Tensors = append(m.Tensors, t...)
+	return nil
+}
+
+func (m *Qwen2Model) LoadVocab() error {
+	v, err := loadBPEVocab(m.Path, m.Params)
+	if err != nil {
+		return err
+	}
+	m.Vocab = v
+	return nil
+}
+
+func (m *Qwen2Model) WriteGGUF(ws io.WriteSeeker) error {
+	kv := llm.KV{
+		"general.architecture":                   "qwen2",
+		"general.name":                           m.Name,
+		"qwen2.context_length":                   uint32(m.Params.ContextSize),
+		"qwen2.embedding_length":                 uint32(m.Params.HiddenSize),
+		"qwen2.block_count":                      uint32(m.Params.HiddenLayers),
+		"qwen2.feed_forward_length":              uint32(m.Params.IntermediateSize),
+		"qwen2.attention.head_count":             uint32(m.Params.AttentionHeads),
+		"qwen2.attention.head_count_kv":          uint32(m.Params.KeyValHeads),
+		"qwen2.attention.layer_norm_rms_epsilon": float32(m.Params.NormEPS),
+		"qwen2.rope.freq_base":                   float32(m.Params.RopeFrequencyBase),
+		"general.file_type":                      uint32(1),
+		"tokenizer.ggml.model":                   "gpt2",
+
+		"tokenizer.ggml.pre":        m.Params.PreTokenizer,
+		"tokenizer.ggml.tokens":     m.Vocab.Tokens,
+		"tokenizer.ggml.token_type": m.Vocab.Types,
+		"tokenizer.ggml.merges":     m.Vocab.Merges,
+
+		"tokenizer.ggml.bos_token_id":     uint32(m.Params.BoSTokenID),
+		"tokenizer.ggml.eos_token_id":     uint32(m.Params.EoSTokenID),
+		"tokenizer.ggml.padding_token_id": uint32(m.Params.BoSTokenID),
+		"tokenizer.ggml.add_bos_token":    false,
+	}
+
+	return llm.NewGGUFV3(m.Params.ByteOrder).Encode(ws, kv, m.Tensors)
+}
@@ -0,0 +1,31 @@
+package convert
+
+import "testing"
+
+func TestSafetensorsLayerName(t *testing.T) {
+	cases := map[string]string{
+		"model.embed_tokens.weight":                           "token_embd.weight",
+		"model.norm.bias":                                     "output_norm.bias",
+		"model.layers.0.self_attn.q_proj.weight":              "blk.0.attn_q.weight",
+		"model.layers.1.self_attn.q_proj.bias":                "blk.1.attn_q.bias",
+		"model.layers.2.self_attn.v_proj.bias":                "blk.2.attn_v.bias",
+		"model.layers.3.self_attn.qkv_proj.weight":            "blk.3.attn_qkv.weight",
+		"model.layers.4.mlp.up_proj.weight":                   "blk.4.ffn_up.weight",
+		"model.layers.5.mlp.gate_up_proj.weight":              "blk.5.ffn_up.weight",
+		"model.layers.6.mlp.gate_proj.weight":                 "blk.6.ffn_gate.weight",
+		"model.layers.7.input_layernorm.bias":                 "blk.7.attn_norm.bias",
+		"model.layers.8.post_attention_layernorm.bias":        "blk.8.ffn_norm.bias",
+		"model.layers.9.block_sparse_moe.experts.1.w2.weight": "blk.9.ffn_down.1.weight",
+	}
+
+	var m SafetensorFormat
+	for n, want := range cases {
+		if got, err := m.GetLayerName(n); err != nil || got != want {
+			t.Errorf("%s: expected %s, got %s, %v", n, want, got, err)
+		}
+	}
+
+	if _, err := m.GetLayerName("model.layers.0.self_attn.rotary_emb.inv_freq"); err == nil {
+		t.Error("expected an error for an unknown tensor")
+	}
+}
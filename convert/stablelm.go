@@ -0,0 +1,68 @@
+package convert
+
+import (
+	"cmp"
+	"errors"
+	"io"
+
+	"github.com/ollama/ollama/llm"
+)
+
+type StableLMModel struct {
+	ModelData
+}
+
+func (m *StableLMModel) GetTensors() error {
+	if m.Params.QKLayerNorm {
+		return errors.New("StableLM models with qk_layernorm are not yet supported")
+	}
+
+	t, err := m.Format.GetTensors(m.Path, m.Params)
+	if err != nil {
+		return err
+	}
+
+	m.Tensors = append(m.Tensors, t...)
+	return nil
+}
+
+func (m *StableLMModel) LoadVocab() error {
+	v, err := loadBPEVocab(m.Path, m.Params)
+	if err != nil {
+		return err
+	}
+	m.Vocab = v
+	return nil
+}
+
+func (m *StableLMModel) WriteGGUF(ws io.WriteSeeker) error {
+	// older checkpoints name some parameters differently
+	rotary := cmp.Or(m.Params.PartialRotaryFactor, m.Params.RopePercentage)
+	parallel := m.Params.UseParallelResidual == nil || *m.Params.UseParallelResidual
+
+	kv := llm.KV{
+		"general.architecture":                  "stablelm",
+		"general.name":                          m.Name,
+		"stablelm.context_length":               uint32(m.Params.ContextSize),
+		"stablelm.embedding_length":             uint32(m.Params.HiddenSize),
+		"stablelm.block_count":                  uint32(m.Params.HiddenLayers),
+		"stablelm.feed_forward_length":          uint32(m.Params.IntermediateSize),
+		"stablelm.rope.dimension_count":         uint32(rotary * float64(m.Params.HiddenSize/m.Params.AttentionHeads)),
+		"stablelm.attention.head_count":         uint32(m.Params.AttentionHeads),
+		"stablelm.attention.head_count_kv":      uint32(cmp.Or(m.Params.KeyValHeads, m.Params.AttentionHeads)),
+		"stablelm.attention.layer_norm_epsilon": float32(cmp.Or(m.Params.LayerNormEPS, m.Params.NormEPSLegacy)),
+		"stablelm.use_parallel_residual":        parallel,
+		"general.file_type":                     uint32(1),
+		"tokenizer.ggml.model":                  "gpt2",
+
+		"tokenizer.ggml.pre":        m.Params.PreTokenizer,
+		"tokenizer.ggml.tokens":     m.Vocab.Tokens,
+		"tokenizer.ggml.token_type": m.Vocab.Types,
+		"tokenizer.ggml.merges":     m.Vocab.Merges,
+
+		"tokenizer.ggml.bos_token_id": uint32(m.Params.BoSTokenID),
+		"tokenizer.ggml.eos_token_id": uint32(m.Params.EoSTokenID),
+	}
+
+	return llm.NewGGUFV3(m.Params.ByteOrder).Encode(ws, kv, m.Tensors)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"slices"
 
 	"golang.org/x/exp/maps"
@@ -64,7 +65,7 @@ func (t *Tokenizer) maxID() int {
 func parseTokens(dirpath string) (pre string, tokens []Token, merges []string, err error) {
 	f, err := os.Open(dirpath)
 	if err != nil {
-		panic(err)
+		return "", nil, nil, err
 	}
 	defer f.Close()
 
@@ -97,6 +98,9 @@ func parseTokens(dirpath string) (pre string, tokens []Token, merges []string, e
 		pre = "deepseek-llm"
 	case "21cde974d587f0d54dc8d56b183cc1e6239600172035c68fbd6d4b9f8da0576e":
 		pre = "deepseek-coder"
+	case "1ff7f41064896984db5d1bb6ff64fa4bc29007d08c1b439e505b7392777a319e":
+		// also used by StableLM 2, which splits text the same way
+		pre = "qwen2"
 	default:
 		slog.Warn("unknown pretokenizer, using default", "digest", digest)
 		pre = "default"
@@ -104,3 +108,35 @@ func parseTokens(dirpath string) (pre string, tokens []Token, merges []string, e
 
 	return pre, tokens, t.Model.Merges, nil
 }
+
+// loadBPEVocab reads the vocabulary of the tokenizer.json in dirpath, padding
+// it to the size of the model's embeddings, which may have unused rows
+func loadBPEVocab(dirpath string, params *Params) (*Vocab, error) {
+	pre, ts, merges, err := parseTokens(filepath.Join(dirpath, "tokenizer.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pad := func(id int) Token {
+		return Token{ID: id, Content: fmt.Sprintf("[PAD%d]", id), UserDefined: true}
+	}
+
+	v := &Vocab{Merges: merges}
+	for i, t := range ts {
+		if t.Content == "" {
+			t = pad(i)
+		}
+
+		v.Tokens = append(v.Tokens, t.Content)
+		v.Types = append(v.Types, t.Type())
+	}
+
+	for i := len(v.Tokens); i < params.VocabSize; i++ {
+		t := pad(i)
+		v.Tokens = append(v.Tokens, t.Content)
+		v.Types = append(v.Types, t.Type())
+	}
+
+	params.PreTokenizer = pre
+	return v, nil
+}
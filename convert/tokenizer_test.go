@@ -0,0 +1,70 @@
+package convert
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadBPEVocab(t *testing.T) {
+	p := t.TempDir()
+
+	// id 2 is missing from the tokenizer
+	writeJSON(t, filepath.Join(p, "tokenizer.json"), map[string]any{
+		"added_tokens": []map[string]any{{"id": 3, "content": "<|endoftext|>", "special": true}},
+		"model": map[string]any{
+			"type":   "BPE",
+			"vocab":  map[string]int{"a": 0, "b": 1},
+			"merges": []string{"a b"},
+		},
+	})
+
+	cases := []struct {
+		name      string
+		vocabSize int
+		tokens    []string
+		types     []int32
+	}{
+		{
+			"tokenizer size", 0,
+			[]string{"a", "b", "[PAD2]", "<|endoftext|>"},
+			[]int32{tokenTypeNormal, tokenTypeNormal, tokenTypeUserDefined, tokenTypeControl},
+		},
+		{
+			"smaller vocab size", 2,
+			[]string{"a", "b", "[PAD2]", "<|endoftext|>"},
+			[]int32{tokenTypeNormal, tokenTypeNormal, tokenTypeUserDefined, tokenTypeControl},
+		},
+		{
+			"padded to vocab size", 6,
+			[]string{"a", "b", "[PAD2]", "<|endoftext|>", "[PAD4]", "[PAD5]"},
+			[]int32{tokenTypeNormal, tokenTypeNormal, tokenTypeUserDefined, tokenTypeControl, tokenTypeUserDefined, tokenTypeUserDefined},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			params := Params{VocabSize: tt.vocabSize}
+			v, err := loadBPEVocab(p, &params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(v.Tokens, tt.tokens) {
+				t.Errorf("expected tokens %q, got %q", tt.tokens, v.Tokens)
+			}
+
+			if !slices.Equal(v.Types, tt.types) {
+				t.Errorf("expected types %v, got %v", tt.types, v.Types)
+			}
+
+			if !slices.Equal(v.Merges, []string{"a b"}) {
+				t.Errorf("unexpected merges %q", v.Merges)
+			}
+
+			if params.PreTokenizer != "default" {
+				t.Errorf("expected default pretokenizer, got %q", params.PreTokenizer)
+			}
+		})
+	}
+}
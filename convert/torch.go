@@ -24,7 +24,9 @@ type torchWriterTo struct {
 	params *Params
 	bo     ByteOrder
 
+	// tensors may share a storage, starting at offset
 	storage  pytorch.StorageInterface
+	offset   int
 	repacker func(string, []float32, []uint64) ([]float32, error)
 }
 
@@ -38,6 +40,8 @@ func (tf *TorchFormat) GetTensors(dirpath string, params *Params) ([]llm.Tensor,
 		files = append(files, pt...)
 	} else if pt, _ := filepath.Glob(filepath.Join(dirpath, "pytorch_model*.pth")); len(pt) > 0 {
 		files = append(files, pt...)
+	} else if pt, _ := filepath.Glob(filepath.Join(dirpath, "pytorch_model*.bin")); len(pt) > 0 {
+		files = append(files, pt...)
 	}
 
 	var offset uint64
@@ -49,12 +53,27 @@ func (tf *TorchFormat) GetTensors(dirpath string, params *Params) ([]llm.Tensor,
 			return []llm.Tensor{}, err
 		}
 
-		for _, k := range m.(*types.Dict).Keys() {
+		// state dicts saved straight from a model are ordered dicts
+		var keys []any
+		var get func(any) (any, bool)
+		switch d := m.(type) {
+		case *types.Dict:
+			keys, get = d.Keys(), d.Get
+		case *types.OrderedDict:
+			for e := d.List.Front(); e != nil; e = e.Next() {
+				keys = append(keys, e.Value.(*types.OrderedDictEntry).Key)
+			}
+			get = d.Get
+		default:
+			return nil, fmt.Errorf("unexpected %T in %s", m, fn)
+		}
+
+		for _, k := range keys {
 			if strings.HasSuffix(k.(string), "self_attn.rotary_emb.inv_freq") {
 				continue
 			}
 
-			t, _ := m.(*types.Dict).Get(k)
+			t, _ := get(k)
 			tshape := t.(*pytorch.Tensor).Size
 
 			var size uint64
@@ -96,6 +115,7 @@ func (tf *TorchFormat) GetTensors(dirpath string, params *Params) ([]llm.Tensor,
 				params:  params,
 				bo:      params.ByteOrder,
 				storage: t.(*pytorch.Tensor).Source,
+				offset:  t.(*pytorch.Tensor).StorageOffset,
 			}
 
 			tensors = append(tensors, tensor)
@@ -180,35 +200,23 @@ func (m *TorchFormat) GetParams(dirpath string) (*Params, error) {
 
 func (m *TorchFormat) GetLayerName(n string) (string, error) {
 	directMap := map[string]string{
-		"tok_embeddings.weight":     "token_embd.weight",
-		"output.weight":             "output.weight",
-		"norm.weight":               "output_norm.weight",
-		"rope.freqs":                "rope_freqs.weight",
-		"model.embed_tokens.weight": "token_embd.weight",
-		"lm_head.weight":            "output.weight",
-		"model.norm.weight":         "output_norm.weight",
+		"tok_embeddings.weight": "token_embd.weight",
+		"output.weight":         "output.weight",
+		"norm.weight":           "output_norm.weight",
+		"rope.freqs":            "rope_freqs.weight",
 	}
 
 	lMap := map[string]string{
-		"layers.(\\d+).attention_norm.weight":                 "blk.$1.attn_norm.weight",
-		"layers.(\\d+).attention_output_norm.weight":          "blk.$1.attn_norm.weight",
-		"layers.(\\d+).feed_forward.w2.weight":                "blk.$1.ffn_down.weight",
-		"layers.(\\d+).feed_forward.w1.weight":                "blk.$1.ffn_gate.weight",
-		"layers.(\\d+).feed_forward.w3.weight":                "blk.$1.ffn_up.weight",
-		"layers.(\\d+).ffn_norm.weight":                       "blk.$1.ffn_norm.weight",
-		"layers.(\\d+).attention.wk.weight":                   "blk.$1.attn_k.weight",
-		"layers.(\\d+).attention.wo.weight":                   "blk.$1.attn_output.weight",
-		"layers.(\\d+).attention.wq.weight":                   "blk.$1.attn_q.weight",
-		"layers.(\\d+).attention.wv.weight":                   "blk.$1.attn_v.weight",
-		"model.layers.(\\d+).input_layernorm.weight":          "blk.$1.attn_norm.weight",
-		"model.layers.(\\d+).mlp.down_proj.weight":            "blk.$1.ffn_down.weight",
-		"model.layers.(\\d+).mlp.gate_proj.weight":            "blk.$1.ffn_gate.weight",
-		"model.layers.(\\d+).mlp.up_proj.weight":              "blk.$1.ffn_up.weight",
-		"model.layers.(\\d+).post_attention_layernorm.weight": "blk.$1.ffn_norm.weight",
-		"model.layers.(\\d+).self_attn.k_proj.weight":         "blk.$1.attn_k.weight",
-		"model.layers.(\\d+).self_attn.o_proj.weight":         "blk.$1.attn_output.weight",
-		"model.layers.(\\d+).self_attn.q_proj.weight":         "blk.$1.attn_q.weight",
-		"model.layers.(\\d+).self_attn.v_proj.weight":         "blk.$1.attn_v.weight",
+		"layers.(\\d+).attention_norm.weight":        "blk.$1.attn_norm.weight",
+		"layers.(\\d+).attention_output_norm.weight": "blk.$1.attn_norm.weight",
+		"layers.(\\d+).feed_forward.w2.weight":       "blk.$1.ffn_down.weight",
+		"layers.(\\d+).feed_forward.w1.weight":       "blk.$1.ffn_gate.weight",
+		"layers.(\\d+).feed_forward.w3.weight":       "blk.$1.ffn_up.weight",
+		"layers.(\\d+).ffn_norm.weight":              "blk.$1.ffn_norm.weight",
+		"layers.(\\d+).attention.wk.weight":          "blk.$1.attn_k.weight",
+		"layers.(\\d+).attention.wo.weight":          "blk.$1.attn_output.weight",
+		"layers.(\\d+).attention.wq.weight":          "blk.$1.attn_q.weight",
+		"layers.(\\d+).attention.wv.weight":          "blk.$1.attn_v.weight",
 	}
 
 	v, ok := directMap[n]
@@ -225,7 +233,8 @@ func (m *TorchFormat) GetLayerName(n string) (string, error) {
 		}
 	}
 
-	return "", fmt.Errorf("couldn't find a layer name for '%s'", n)
+	// checkpoints saved by transformers name layers as their safetensors do
+	return (&SafetensorFormat{}).GetLayerName(n)
 }
 
 func (r torchWriterTo) WriteTo(w io.Writer) (n int64, err error) {
@@ -241,6 +250,19 @@ func (r torchWriterTo) WriteTo(w io.Writer) (n int64, err error) {
 		return 0, fmt.Errorf("unknown data type: %T", s)
 	}
 
+	size := 1
+	for _, dim := range r.t.Shape {
+		if dim > 0 {
+			size *= int(dim)
+		}
+	}
+
+	if r.offset+size > len(f32s) {
+		return 0, fmt.Errorf("tensor %s overflows its storage", r.t.Name)
+	}
+
+	f32s = f32s[r.offset : r.offset+size]
+
 	if r.repacker != nil {
 		f32s, err = r.repacker(r.t.Name, f32s, r.t.Shape)
 		if err != nil {
@@ -264,24 +286,5 @@ func (r torchWriterTo) WriteTo(w io.Writer) (n int64, err error) {
 }
 
 func (m *TorchFormat) GetModelArch(name, dirPath string, params *Params) (ModelArch, error) {
-	switch len(params.Architectures) {
-	case 0:
-		return nil, fmt.Errorf("No architecture specified to convert")
-	case 1:
-		switch params.Architectures[0] {
-		case "LlamaForCausalLM":
-			return &LlamaModel{
-				ModelData{
-					Name:   name,
-					Path:   dirPath,
-					Params: params,
-					Format: m,
-				},
-			}, nil
-		default:
-			return nil, fmt.Errorf("Models based on '%s' are not yet supported", params.Architectures[0])
-		}
-	}
-
-	return nil, fmt.Errorf("Unknown error")
+	return getModelArch(name, dirPath, params, m)
 }
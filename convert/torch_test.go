@@ -0,0 +1,556 @@
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/x448/float16"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ollama/ollama/convert/sentencepiece"
+	"github.com/ollama/ollama/llm"
+)
+
+func convertFull(t *testing.T, p string) (llm.KV, llm.Tensors) {
+	t.Helper()
+
+	mf, err := GetModelFormat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := mf.GetParams(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arch, err := mf.GetModelArch("", p, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := arch.LoadVocab(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := arch.GetTensors(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "f16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := arch.WriteGGUF(f); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	m, _, err := llm.DecodeGGML(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m.KV(), m.Tensors()
+}
+
+// torchTensor is a tensor for writeTorch to save. Its storage holds offset
+// values before the tensor's own, and every value is its index in the storage.
+type torchTensor struct {
+	name    string
+	storage string
+	offset  int
+	shape   []int
+}
+
+// writeTorch writes names as a state dict of F32 tensors, each in its own
+// storage, the way torch.save does. Norms and biases are 8 wide and every
+// other tensor is 8x8.
+func writeTorch(t *testing.T, fn string, names []string) {
+	t.Helper()
+
+	tensors := make([]torchTensor, len(names))
+	for i, name := range names {
+		tensors[i] = torchTensor{name: name, storage: "FloatStorage", shape: []int{8, 8}}
+		if strings.Contains(name, "norm") || strings.HasSuffix(name, ".bias") {
+			tensors[i].shape = []int{8}
+		}
+	}
+
+	writeTorchTensors(t, fn, tensors)
+}
+
+// writeTorchTensors writes tensors as a state dict the way torch.save does
+func writeTorchTensors(t *testing.T, fn string, tensors []torchTensor) {
+	t.Helper()
+
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	var pkl bytes.Buffer
+	str := func(s string) {
+		pkl.WriteByte('X')
+		pkl.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+		pkl.WriteString(s)
+	}
+
+	num := func(n int) {
+		pkl.WriteByte('J')
+		pkl.Write(binary.LittleEndian.AppendUint32(nil, uint32(n)))
+	}
+
+	tuple := func(ns ...int) {
+		pkl.WriteByte('(')
+		for _, n := range ns {
+			num(n)
+		}
+		pkl.WriteByte('t')
+	}
+
+	orderedDict := func() {
+		pkl.WriteString("ccollections\nOrderedDict\n)R")
+	}
+
+	pkl.WriteString("\x80\x02")
+	orderedDict()
+	pkl.WriteByte('(')
+	for i, tensor := range tensors {
+		size := 1
+		stride := make([]int, len(tensor.shape))
+		for j := len(tensor.shape) - 1; j >= 0; j-- {
+			stride[j] = size
+			size *= tensor.shape[j]
+		}
+
+		key := strconv.Itoa(i)
+		str(tensor.name)
+
+		// _rebuild_tensor_v2(storage, offset, size, stride, requires_grad, hooks)
+		pkl.WriteString("ctorch._utils\n_rebuild_tensor_v2\n(")
+
+		// the storage is loaded by its persistent id
+		pkl.WriteByte('(')
+		str("storage")
+		pkl.WriteString("ctorch\n" + tensor.storage + "\n")
+		str(key)
+		str("cpu")
+		num(tensor.offset + size)
+		pkl.WriteString("tQ")
+
+		num(tensor.offset)
+		tuple(tensor.shape...)
+		tuple(stride...)
+		pkl.WriteString("\x89")
+		orderedDict()
+		pkl.WriteString("tR")
+
+		// torch stores records uncompressed
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "archive/data/" + key, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var data any
+		switch tensor.storage {
+		case "FloatStorage":
+			f32s := make([]float32, tensor.offset+size)
+			for j := range f32s {
+				f32s[j] = float32(j)
+			}
+			data = f32s
+		case "HalfStorage":
+			f16s := make([]uint16, tensor.offset+size)
+			for j := range f16s {
+				f16s[j] = float16.Fromfloat32(float32(j)).Bits()
+			}
+			data = f16s
+		case "BFloat16Storage":
+			bf16s := make([]uint16, tensor.offset+size)
+			for j := range bf16s {
+				bf16s[j] = uint16(math.Float32bits(float32(j)) >> 16)
+			}
+			data = bf16s
+		default:
+			t.Fatalf("unknown storage %s", tensor.storage)
+		}
+
+		if err := binary.Write(w, binary.LittleEndian, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkl.WriteString("u.")
+
+	w, err := zw.Create("archive/data.pkl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write(pkl.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeJSON(t *testing.T, fn string, v any) {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(fn, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeBPE writes a tokenizer.json for models with a BPE vocabulary
+func writeBPE(t *testing.T, dir string) {
+	t.Helper()
+
+	writeJSON(t, filepath.Join(dir, "tokenizer.json"), map[string]any{
+		"added_tokens": []map[string]any{{"id": 3, "content": "<|endoftext|>", "special": true}},
+		"model": map[string]any{
+			"type":   "BPE",
+			"vocab":  map[string]int{"a": 0, "b": 1, "ab": 2},
+			"merges": []string{"a b"},
+		},
+	})
+}
+
+// writeSentencePiece writes a tokenizer.model for models with a
+// sentencepiece vocabulary
+func writeSentencePiece(t *testing.T, dir string) {
+	t.Helper()
+
+	var m sentencepiece.ModelProto
+	for _, p := range []struct {
+		piece string
+		kind  sentencepiece.ModelProto_SentencePiece_Type
+	}{
+		{"<unk>", sentencepiece.ModelProto_SentencePiece_UNKNOWN},
+		{"<s>", sentencepiece.ModelProto_SentencePiece_CONTROL},
+		{"</s>", sentencepiece.ModelProto_SentencePiece_CONTROL},
+		{"a", sentencepiece.ModelProto_SentencePiece_NORMAL},
+	} {
+		m.Pieces = append(m.Pieces, &sentencepiece.ModelProto_SentencePiece{
+			Piece: proto.String(p.piece),
+			Score: proto.Float32(0),
+			Type:  p.kind.Enum(),
+		})
+	}
+
+	b, err := proto.Marshal(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "tokenizer.model"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvertTorch(t *testing.T) {
+	config := map[string]any{
+		"hidden_size":             8,
+		"num_hidden_layers":       2,
+		"max_position_embeddings": 32,
+		"intermediate_size":       16,
+		"num_attention_heads":     2,
+		"num_key_value_heads":     2,
+		"rms_norm_eps":            1e-5,
+		"vocab_size":              4,
+		"bos_token_id":            1,
+		"eos_token_id":            2,
+	}
+
+	with := func(kv map[string]any) map[string]any {
+		c := maps.Clone(config)
+		maps.Copy(c, kv)
+		return c
+	}
+
+	globals := []string{"model.embed_tokens.weight", "model.norm.weight", "lm_head.weight"}
+	llama := []string{
+		"input_layernorm.weight",
+		"self_attn.q_proj.weight",
+		"self_attn.k_proj.weight",
+		"self_attn.v_proj.weight",
+		"self_attn.o_proj.weight",
+		"mlp.gate_proj.weight",
+		"mlp.up_proj.weight",
+		"mlp.down_proj.weight",
+		"post_attention_layernorm.weight",
+	}
+
+	cases := []struct {
+		arch      string
+		want      string
+		config    map[string]any
+		tokenizer func(*testing.T, string)
+		globals   []string
+		layer     []string
+		tensors   int
+		layers    int
+	}{
+		{"LlamaForCausalLM", "llama", config, writeBPE, globals, slices.Concat(llama, []string{"self_attn.rotary_emb.inv_freq"}), 21, 5},
+		{"MistralForCausalLM", "llama", config, writeSentencePiece, globals, llama, 21, 5},
+		{"MixtralForCausalLM", "llama", with(map[string]any{"num_local_experts": 2, "num_experts_per_tok": 1}), writeSentencePiece, globals, slices.Concat(llama[:5], []string{
+			"post_attention_layernorm.weight",
+			"block_sparse_moe.gate.weight",
+			"block_sparse_moe.experts.0.w1.weight",
+			"block_sparse_moe.experts.0.w2.weight",
+			"block_sparse_moe.experts.0.w3.weight",
+			"block_sparse_moe.experts.1.w1.weight",
+			"block_sparse_moe.experts.1.w2.weight",
+			"block_sparse_moe.experts.1.w3.weight",
+		}), 29, 5},
+		{"GemmaForCausalLM", "gemma", with(map[string]any{"head_dim": 4}), writeSentencePiece, globals[:2], llama, 20, 4},
+		{"Qwen2ForCausalLM", "qwen2", config, writeBPE, globals, slices.Concat(llama, []string{
+			"self_attn.q_proj.bias",
+			"self_attn.k_proj.bias",
+			"self_attn.v_proj.bias",
+		}), 27, 5},
+		{"Phi3ForCausalLM", "phi3", config, writeSentencePiece, globals, []string{
+			"input_layernorm.weight",
+			"self_attn.qkv_proj.weight",
+			"self_attn.o_proj.weight",
+			"mlp.gate_up_proj.weight",
+			"mlp.down_proj.weight",
+			"post_attention_layernorm.weight",
+		}, 15, 5},
+		{"StableLmForCausalLM", "stablelm", with(map[string]any{"layer_norm_eps": 1e-5, "partial_rotary_factor": 0.25}), writeBPE, slices.Concat(globals, []string{"model.norm.bias"}), slices.Concat(llama, []string{
+			"input_layernorm.bias",
+			"post_attention_layernorm.bias",
+		}), 26, 5},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.arch, func(t *testing.T) {
+			p := t.TempDir()
+			c := maps.Clone(tt.config)
+			c["architectures"] = []string{tt.arch}
+			writeJSON(t, filepath.Join(p, "config.json"), c)
+
+			tt.tokenizer(t, p)
+
+			names := slices.Clone(tt.globals)
+			for i := range 2 {
+				for _, n := range tt.layer {
+					names = append(names, fmt.Sprintf("model.layers.%d.%s", i, n))
+				}
+			}
+
+			writeTorch(t, filepath.Join(p, "pytorch_model.bin"), names)
+
+			kv, tensors := convertFull(t, p)
+
+			if kv.Architecture() != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, kv.Architecture())
+			}
+
+			if len(tensors) != tt.tensors {
+				t.Fatalf("expected %d tensors, got %d", tt.tensors, len(tensors))
+			}
+
+			layers := tensors.Layers()
+			if len(layers) != tt.layers {
+				t.Fatalf("expected %d layers, got %d", tt.layers, len(layers))
+			}
+		})
+	}
+}
+
+// tensorData reads the tensors of the torch checkpoint in dir and returns
+// the data the converter writes for each of them, by GGUF name
+func tensorData(t *testing.T, dir string) map[string][]float32 {
+	t.Helper()
+
+	mf, err := GetModelFormat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := mf.GetParams(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arch, err := mf.GetModelArch("", dir, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := arch.GetTensors(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tensors []llm.Tensor
+	switch m := arch.(type) {
+	case *LlamaModel:
+		tensors = m.Tensors
+	case *MistralModel:
+		tensors = m.Tensors
+	case *Qwen2Model:
+		tensors = m.Tensors
+	case *StableLMModel:
+		tensors = m.Tensors
+	case *Phi3Model:
+		tensors = m.Tensors
+	default:
+		t.Fatalf("unexpected %T", arch)
+	}
+
+	data := make(map[string][]float32)
+	for _, tensor := range tensors {
+		var b bytes.Buffer
+		if _, err := tensor.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		switch tensor.Kind {
+		case 0:
+			f32s := make([]float32, b.Len()/4)
+			if err := binary.Read(&b, binary.LittleEndian, f32s); err != nil {
+				t.Fatal(err)
+			}
+			data[tensor.Name] = f32s
+		case 1:
+			f16s := make([]uint16, b.Len()/2)
+			if err := binary.Read(&b, binary.LittleEndian, f16s); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, v := range f16s {
+				data[tensor.Name] = append(data[tensor.Name], float16.Frombits(v).Float32())
+			}
+		default:
+			t.Fatalf("unexpected kind %d for %s", tensor.Kind, tensor.Name)
+		}
+	}
+
+	return data
+}
+
+// count returns the values from start to start+n
+func count(start, n int) []float32 {
+	f32s := make([]float32, n)
+	for i := range f32s {
+		f32s[i] = float32(start + i)
+	}
+	return f32s
+}
+
+func TestConvertTorchPermute(t *testing.T) {
+	config := map[string]any{
+		"hidden_size":         8,
+		"num_hidden_layers":   1,
+		"num_attention_heads": 2,
+		"num_key_value_heads": 2,
+	}
+
+	cases := []struct {
+		arch    string
+		permute bool
+	}{
+		{"LlamaForCausalLM", true},
+		{"MistralForCausalLM", true},
+		{"Qwen2ForCausalLM", false},
+		{"StableLmForCausalLM", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.arch, func(t *testing.T) {
+			p := t.TempDir()
+			c := maps.Clone(config)
+			c["architectures"] = []string{tt.arch}
+			writeJSON(t, filepath.Join(p, "config.json"), c)
+			writeTorch(t, filepath.Join(p, "pytorch_model.bin"), []string{
+				"model.layers.0.self_attn.q_proj.weight",
+				"model.layers.0.self_attn.k_proj.weight",
+				"model.layers.0.self_attn.v_proj.weight",
+			})
+
+			data := tensorData(t, p)
+
+			// the rows of q and k are interleaved by head for llama's rope
+			rows := []int{0, 1, 2, 3, 4, 5, 6, 7}
+			if tt.permute {
+				rows = []int{0, 2, 1, 3, 4, 6, 5, 7}
+			}
+
+			for _, name := range []string{"blk.0.attn_q.weight", "blk.0.attn_k.weight"} {
+				for i, row := range rows {
+					if got, want := data[name][i*8:i*8+8], count(row*8, 8); !slices.Equal(got, want) {
+						t.Errorf("%s row %d: expected %v, got %v", name, i, want, got)
+					}
+				}
+			}
+
+			if got, want := data["blk.0.attn_v.weight"], count(0, 64); !slices.Equal(got, want) {
+				t.Errorf("blk.0.attn_v.weight: expected %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestConvertTorchStorage(t *testing.T) {
+	p := t.TempDir()
+	writeJSON(t, filepath.Join(p, "config.json"), map[string]any{
+		"architectures":       []string{"Qwen2ForCausalLM"},
+		"hidden_size":         8,
+		"num_hidden_layers":   1,
+		"num_attention_heads": 2,
+	})
+
+	writeTorchTensors(t, filepath.Join(p, "pytorch_model.bin"), []torchTensor{
+		{name: "model.embed_tokens.weight", storage: "FloatStorage", shape: []int{8, 8}},
+		{name: "model.norm.weight", storage: "FloatStorage", offset: 3, shape: []int{8}},
+		{name: "lm_head.weight", storage: "HalfStorage", offset: 8, shape: []int{8, 8}},
+		{name: "model.layers.0.input_layernorm.weight", storage: "HalfStorage", shape: []int{8}},
+		{name: "model.layers.0.self_attn.q_proj.weight", storage: "BFloat16Storage", offset: 16, shape: []int{8, 8}},
+		{name: "model.layers.0.post_attention_layernorm.weight", storage: "BFloat16Storage", offset: 5, shape: []int{8}},
+	})
+
+	cases := map[string][]float32{
+		"token_embd.weight":      count(0, 64),
+		"output_norm.weight":     count(3, 8),
+		"output.weight":          count(8, 64),
+		"blk.0.attn_norm.weight": count(0, 8),
+		"blk.0.attn_q.weight":    count(16, 64),
+		"blk.0.ffn_norm.weight":  count(5, 8),
+	}
+
+	data := tensorData(t, p)
+	for name, want := range cases {
+		if got := data[name]; !slices.Equal(got, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+}
@@ -27,6 +27,11 @@ var ErrInvalidHostPort = errors.New("invalid port specified in OLLAMA_HOST")
 var (
 	// Set via OLLAMA_ORIGINS in the environment
 	AllowOrigins []string
+	// Set via OLLAMA_API_KEY in the environment. It is left out of AsMap so
+	// that it is never logged.
+	APIKey string
+	// Set via OLLAMA_API_KEYS_FILE in the environment
+	APIKeysFile string
 	// Set via OLLAMA_DEBUG in the environment
 	Debug bool
 	// Experimental flash attention
@@ -51,12 +56,30 @@ var (
 	NoPrune bool
 	// Set via OLLAMA_NUM_PARALLEL in the environment
 	NumParallel int
+	// Set via OLLAMA_PRELOAD in the environment
+	Preload []string
+	// Set via OLLAMA_RATE_LIMIT_REQUESTS in the environment
+	RateLimitRequests int
+	// Set via OLLAMA_RATE_LIMIT_TOKENS in the environment
+	RateLimitTokens int
 	// Set via OLLAMA_RUNNERS_DIR in the environment
 	RunnersDir string
 	// Set via OLLAMA_SCHED_SPREAD in the environment
 	SchedSpread bool
 	// Set via OLLAMA_TMPDIR in the environment
 	TmpDir string
+	// Set via OLLAMA_TLS_CA_BUNDLE in the environment
+	TLSCABundle string
+	// Set via OLLAMA_TLS_CERT in the environment
+	TLSCert string
+	// Set via OLLAMA_TLS_CLIENT_CA in the environment
+	TLSClientCA string
+	// Set via OLLAMA_TLS_CLIENT_CERT in the environment
+	TLSClientCert string
+	// Set via OLLAMA_TLS_CLIENT_KEY in the environment
+	TLSClientKey string
+	// Set via OLLAMA_TLS_KEY in the environment
+	TLSKey string
 	// Set via OLLAMA_INTEL_GPU in the environment
 	IntelGpu bool
 
@@ -80,22 +103,32 @@ type EnvVar struct {
 
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug, "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention, "Enabled flash attention"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host, "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive, "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary, "Set LLM library to bypass autodetection"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners, "Maximum number of loaded models (default 1)"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueuedRequests, "Maximum number of queued requests"},
-		"OLLAMA_MAX_VRAM":          {"OLLAMA_MAX_VRAM", MaxVRAM, "Maximum VRAM"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", ModelsDir, "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory, "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune, "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel, "Maximum number of parallel requests (default 1)"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", AllowOrigins, "A comma separated list of allowed origins"},
-		"OLLAMA_RUNNERS_DIR":       {"OLLAMA_RUNNERS_DIR", RunnersDir, "Location for runners"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread, "Always schedule model across all GPUs"},
-		"OLLAMA_TMPDIR":            {"OLLAMA_TMPDIR", TmpDir, "Location for temporary files"},
+		"OLLAMA_API_KEYS_FILE":       {"OLLAMA_API_KEYS_FILE", APIKeysFile, "Path to a file of API keys required to access the API"},
+		"OLLAMA_DEBUG":               {"OLLAMA_DEBUG", Debug, "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_FLASH_ATTENTION":     {"OLLAMA_FLASH_ATTENTION", FlashAttention, "Enabled flash attention"},
+		"OLLAMA_HOST":                {"OLLAMA_HOST", Host, "IP Address for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_KEEP_ALIVE":          {"OLLAMA_KEEP_ALIVE", KeepAlive, "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_LLM_LIBRARY":         {"OLLAMA_LLM_LIBRARY", LLMLibrary, "Set LLM library to bypass autodetection"},
+		"OLLAMA_MAX_LOADED_MODELS":   {"OLLAMA_MAX_LOADED_MODELS", MaxRunners, "Maximum number of loaded models (default 1)"},
+		"OLLAMA_MAX_QUEUE":           {"OLLAMA_MAX_QUEUE", MaxQueuedRequests, "Maximum number of queued requests"},
+		"OLLAMA_MAX_VRAM":            {"OLLAMA_MAX_VRAM", MaxVRAM, "Maximum VRAM"},
+		"OLLAMA_MODELS":              {"OLLAMA_MODELS", ModelsDir, "The path to the models directory"},
+		"OLLAMA_NOHISTORY":           {"OLLAMA_NOHISTORY", NoHistory, "Do not preserve readline history"},
+		"OLLAMA_NOPRUNE":             {"OLLAMA_NOPRUNE", NoPrune, "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":        {"OLLAMA_NUM_PARALLEL", NumParallel, "Maximum number of parallel requests (default 1)"},
+		"OLLAMA_ORIGINS":             {"OLLAMA_ORIGINS", AllowOrigins, "A comma separated list of allowed origins"},
+		"OLLAMA_PRELOAD":             {"OLLAMA_PRELOAD", Preload, "A comma separated list of models to load on startup"},
+		"OLLAMA_RATE_LIMIT_REQUESTS": {"OLLAMA_RATE_LIMIT_REQUESTS", RateLimitRequests, "Maximum number of inference requests per minute for each client"},
+		"OLLAMA_RATE_LIMIT_TOKENS":   {"OLLAMA_RATE_LIMIT_TOKENS", RateLimitTokens, "Maximum number of generated tokens per minute for each client"},
+		"OLLAMA_RUNNERS_DIR":         {"OLLAMA_RUNNERS_DIR", RunnersDir, "Location for runners"},
+		"OLLAMA_SCHED_SPREAD":        {"OLLAMA_SCHED_SPREAD", SchedSpread, "Always schedule model across all GPUs"},
+		"OLLAMA_TMPDIR":              {"OLLAMA_TMPDIR", TmpDir, "Location for temporary files"},
+		"OLLAMA_TLS_CA_BUNDLE":       {"OLLAMA_TLS_CA_BUNDLE", TLSCABundle, "Path to additional CA certificates to trust when connecting to the ollama server"},
+		"OLLAMA_TLS_CERT":            {"OLLAMA_TLS_CERT", TLSCert, "Path to the certificate to serve the API with over TLS"},
+		"OLLAMA_TLS_CLIENT_CA":       {"OLLAMA_TLS_CLIENT_CA", TLSClientCA, "Path to CA certificates used to require and verify client certificates"},
+		"OLLAMA_TLS_CLIENT_CERT":     {"OLLAMA_TLS_CLIENT_CERT", TLSClientCert, "Path to the certificate to present when connecting to the ollama server"},
+		"OLLAMA_TLS_CLIENT_KEY":      {"OLLAMA_TLS_CLIENT_KEY", TLSClientKey, "Path to the private key of OLLAMA_TLS_CLIENT_CERT"},
+		"OLLAMA_TLS_KEY":             {"OLLAMA_TLS_KEY", TLSKey, "Path to the private key of OLLAMA_TLS_CERT"},
 	}
 	if runtime.GOOS != "darwin" {
 		ret["CUDA_VISIBLE_DEVICES"] = EnvVar{"CUDA_VISIBLE_DEVICES", CudaVisibleDevices, "Set which NVIDIA devices are visible"}
@@ -191,6 +224,13 @@ func LoadConfig() {
 
 	TmpDir = clean("OLLAMA_TMPDIR")
 
+	TLSCABundle = clean("OLLAMA_TLS_CA_BUNDLE")
+	TLSCert = clean("OLLAMA_TLS_CERT")
+	TLSClientCA = clean("OLLAMA_TLS_CLIENT_CA")
+	TLSClientCert = clean("OLLAMA_TLS_CLIENT_CERT")
+	TLSClientKey = clean("OLLAMA_TLS_CLIENT_KEY")
+	TLSKey = clean("OLLAMA_TLS_KEY")
+
 	userLimit := clean("OLLAMA_MAX_VRAM")
 	if userLimit != "" {
 		avail, err := strconv.ParseUint(userLimit, 10, 64)
@@ -229,6 +269,13 @@ func LoadConfig() {
 		NoPrune = true
 	}
 
+	Preload = nil
+	for _, model := range strings.Split(clean("OLLAMA_PRELOAD"), ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			Preload = append(Preload, model)
+		}
+	}
+
 	if origins := clean("OLLAMA_ORIGINS"); origins != "" {
 		AllowOrigins = strings.Split(origins, ",")
 	}
@@ -266,8 +313,29 @@ func LoadConfig() {
 		}
 	}
 
+	if rl := clean("OLLAMA_RATE_LIMIT_REQUESTS"); rl != "" {
+		n, err := strconv.Atoi(rl)
+		if err != nil || n < 0 {
+			slog.Error("invalid setting", "OLLAMA_RATE_LIMIT_REQUESTS", rl, "error", err)
+		} else {
+			RateLimitRequests = n
+		}
+	}
+
+	if rl := clean("OLLAMA_RATE_LIMIT_TOKENS"); rl != "" {
+		n, err := strconv.Atoi(rl)
+		if err != nil || n < 0 {
+			slog.Error("invalid setting", "OLLAMA_RATE_LIMIT_TOKENS", rl, "error", err)
+		} else {
+			RateLimitTokens = n
+		}
+	}
+
 	KeepAlive = clean("OLLAMA_KEEP_ALIVE")
 
+	APIKey = clean("OLLAMA_API_KEY")
+	APIKeysFile = clean("OLLAMA_API_KEYS_FILE")
+
 	var err error
 	ModelsDir, err = getModelsDir()
 	if err != nil {
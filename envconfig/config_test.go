@@ -69,3 +69,13 @@ func TestClientFromEnvironment(t *testing.T) {
 		})
 	}
 }
+
+func TestPreload(t *testing.T) {
+	t.Setenv("OLLAMA_PRELOAD", "")
+	LoadConfig()
+	require.Empty(t, Preload)
+
+	t.Setenv("OLLAMA_PRELOAD", " llama3?num_ctx=8192&keep_alive=-1, ,mistral ")
+	LoadConfig()
+	require.Equal(t, []string{"llama3?num_ctx=8192&keep_alive=-1", "mistral"}, Preload)
+}
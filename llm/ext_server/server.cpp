@@ -43,6 +43,7 @@
 #include <windows.h>
 #endif
 
+#include <algorithm>
 #include <cstddef>
 #include <thread>
 #include <chrono>
@@ -63,6 +64,7 @@ struct server_params {
     bool slots_endpoint = true;
     bool metrics_endpoint = false;
     int n_threads_http = -1;
+    std::string slot_save_path;
 };
 
 bool server_verbose = false;
@@ -159,6 +161,11 @@ struct server_slot {
 
     int32_t n_past_se = 0; // self-extend
 
+    // speculative decoding
+    int32_t n_past_dft       = 0; // tokens of cache_tokens in the draft model's KV cache
+    int32_t n_draft_total    = 0; // tokens drafted for the current task
+    int32_t n_draft_accepted = 0; // drafted tokens the model agreed with
+
     // multimodal
     std::vector<slot_image> images;
 
@@ -188,6 +195,8 @@ struct server_slot {
         n_sent_token_probs     = 0;
         ga_i                   = 0;
         n_past_se              = 0;
+        n_draft_total          = 0;
+        n_draft_accepted       = 0;
 
         generated_token_probs.clear();
 
@@ -337,9 +346,18 @@ struct llama_server_context
 
     clip_ctx *clp_ctx = nullptr;
 
+    // draft model for speculative decoding, if any
+    llama_model *model_dft = nullptr;
+    llama_context *ctx_dft = nullptr;
+
     gpt_params params;
 
+    // adapters applied on top of those the model was loaded with
+    std::vector<std::tuple<std::string, float>> lora_dynamic;
+
     llama_batch batch;
+    llama_batch batch_dft;  // tokens for the draft model
+    llama_batch batch_spec; // a drafted sequence verified by the model
 
     bool multimodal         = false;
     bool clean_kv_cache     = true;
@@ -373,6 +391,16 @@ struct llama_server_context
             clip_free(clp_ctx);
             clp_ctx = nullptr;
         }
+        if (ctx_dft)
+        {
+            llama_free(ctx_dft);
+            ctx_dft = nullptr;
+        }
+        if (model_dft)
+        {
+            llama_free_model(model_dft);
+            model_dft = nullptr;
+        }
         if (ctx)
         {
             llama_free(ctx);
@@ -420,6 +448,18 @@ struct llama_server_context
             }
         }
 
+        if (!params.model_draft.empty())
+        {
+            if (multimodal)
+            {
+                LOG_WARNING("speculative decoding is not supported with multimodal models", {{"model", params.model_draft}});
+            }
+            else if (!load_draft_model())
+            {
+                return false;
+            }
+        }
+
         n_ctx = llama_n_ctx(ctx);
 
         add_bos_token = llama_should_add_bos_token(model);
@@ -427,6 +467,126 @@ struct llama_server_context
         return true;
     }
 
+    // set_adapters applies adapters on top of those the model was loaded with,
+    // in place of the ones applied before. The weights changed by earlier
+    // adapters are read again from the model file rather than computed back,
+    // so switching adapters doesn't accumulate rounding errors.
+    bool set_adapters(const std::vector<std::tuple<std::string, float>> &adapters)
+    {
+        if (adapters == lora_dynamic)
+        {
+            return true;
+        }
+
+        const auto apply = [this](const std::tuple<std::string, float> &adapter, const char *path_base)
+        {
+            const std::string &path = std::get<0>(adapter);
+            const float scale = path_base == nullptr ? std::get<1>(adapter) : 0.0f;
+            if (llama_model_apply_lora_from_file(model, path.c_str(), scale, path_base, params.n_threads) != 0)
+            {
+                LOG_ERROR("failed to apply adapter", {{"adapter", path}, {"scale", scale}});
+                return false;
+            }
+
+            return true;
+        };
+
+        // whatever is cached was computed with the old weights
+        for (server_slot &slot : slots)
+        {
+            llama_kv_cache_seq_rm(ctx, slot.id, -1, -1);
+            if (ctx_dft != nullptr)
+            {
+                llama_kv_cache_seq_rm(ctx_dft, slot.id, -1, -1);
+            }
+
+            slot.cache_tokens.clear();
+            slot.n_past = 0;
+            slot.n_past_dft = 0;
+        }
+
+        if (!lora_dynamic.empty())
+        {
+            // a scale of 0 over the base model restores the weights an adapter
+            // changes, including those shared with the adapters loaded with
+            // the model, which are then applied again. The adapters are kept
+            // on failure so that the next call restores their weights again.
+            for (const auto &adapter : params.lora_adapter)
+            {
+                if (!apply(adapter, params.model.c_str()))
+                {
+                    return false;
+                }
+            }
+            for (const auto &adapter : lora_dynamic)
+            {
+                if (!apply(adapter, params.model.c_str()))
+                {
+                    return false;
+                }
+            }
+            for (const auto &adapter : params.lora_adapter)
+            {
+                if (!apply(adapter, nullptr))
+                {
+                    return false;
+                }
+            }
+        }
+
+        lora_dynamic.clear();
+        for (const auto &adapter : adapters)
+        {
+            // recorded first so that a partly applied adapter is restored too
+            lora_dynamic.push_back(adapter);
+            if (!apply(adapter, nullptr))
+            {
+                return false;
+            }
+        }
+
+        LOG_INFO("adapters applied", {{"n_adapters", lora_dynamic.size()}});
+        return true;
+    }
+
+    // load_draft_model loads the draft model with the same context as the
+    // model so it can follow every slot
+    bool load_draft_model()
+    {
+        gpt_params params_dft = params;
+        params_dft.model = params.model_draft;
+        params_dft.n_gpu_layers = params.n_gpu_layers_draft;
+        params_dft.lora_adapter.clear();
+        params_dft.lora_base.clear();
+
+        std::tie(model_dft, ctx_dft) = llama_init_from_gpt_params(params_dft);
+        if (model_dft == nullptr)
+        {
+            LOG_ERROR("unable to load draft model", {{"model", params.model_draft}});
+            return false;
+        }
+
+        // drafted token ids must mean the same to the model. Vocabularies of
+        // the same family may differ in the padding at the end.
+        const int n_vocab     = llama_n_vocab(model);
+        const int n_vocab_dft = llama_n_vocab(model_dft);
+        if (llama_vocab_type(model_dft) != llama_vocab_type(model) ||
+            std::abs(n_vocab - n_vocab_dft) > 128 ||
+            llama_token_bos(model_dft) != llama_token_bos(model) ||
+            llama_token_eos(model_dft) != llama_token_eos(model))
+        {
+            LOG_ERROR("draft model vocabulary does not match the model", {
+                {"model",       params.model_draft},
+                {"n_vocab",     n_vocab},
+                {"n_vocab_dft", n_vocab_dft},
+            });
+            return false;
+        }
+
+        LOG_INFO("draft model loaded", {{"model", params.model_draft}, {"n_draft", params.n_draft}});
+        return true;
+    }
+
     void validate_model_chat_template(server_params & sparams) {
         llama_chat_message chat[] = {{"user", "test"}};
         std::vector<char> buf(1);
@@ -483,6 +643,12 @@ struct llama_server_context
         }
 
         batch = llama_batch_init(n_ctx, 0, params.n_parallel);
+
+        if (ctx_dft != nullptr)
+        {
+            batch_dft = llama_batch_init(params.n_batch, 0, 1);
+            batch_spec = llama_batch_init(params.n_draft + 1, 0, 1);
+        }
     }
 
     std::vector<llama_token> tokenize(const json & json_prompt, bool add_bos) const
@@ -807,6 +973,11 @@ struct llama_server_context
             llama_sampling_free(slot->ctx_sampling);
         }
         slot->ctx_sampling = llama_sampling_init(slot->sparams);
+        if (slot->ctx_sampling == nullptr)
+        {
+            LOG_ERROR("failed to initialize sampling context", {{"slot_id", slot->id}});
+            return false;
+        }
         slot->command = LOAD_PROMPT;
 
         all_slots_are_idle = false;
@@ -1167,6 +1338,8 @@ struct llama_server_context
             {"stopped_limit",       slot.stopped_limit},
             {"stopping_word",       slot.stopping_word},
             {"tokens_cached",       slot.n_past},
+            {"draft_n",             slot.n_draft_total},
+            {"draft_n_accepted",    slot.n_draft_accepted},
             {"timings",             slot.get_formated_timings()}
         };
 
@@ -1407,6 +1580,19 @@ struct llama_server_context
                     break;
                 }
 
+                // parse the grammar up front so a bad grammar is reported as
+                // such rather than as an internal error
+                const std::string grammar = json_value(task.data, "grammar", std::string());
+                if (!grammar.empty())
+                {
+                    grammar_parser::parse_state parsed_grammar = grammar_parser::parse(grammar.c_str());
+                    if (parsed_grammar.rules.empty() || parsed_grammar.symbol_ids.find("root") == parsed_grammar.symbol_ids.end())
+                    {
+                        send_error(task, "failed to parse grammar");
+                        break;
+                    }
+                }
+
                 slot->reset();
 
                 slot->embedding    = task.embedding_mode;
@@ -1433,6 +1619,119 @@ struct llama_server_context
             case TASK_TYPE_NEXT_RESPONSE: {
                 // do nothing
             } break;
+            case TASK_TYPE_SLOT_SAVE:
+            case TASK_TYPE_SLOT_RESTORE: {
+                const int id_slot = json_value(task.data, "slot_id", -1);
+                if (id_slot < 0 || id_slot >= (int) slots.size())
+                {
+                    send_error(task, "invalid slot id");
+                    break;
+                }
+
+                server_slot &slot = slots[id_slot];
+                if (!slot.available())
+                {
+                    // wait for the slot to finish its current task
+                    queue_tasks.defer(task);
+                    break;
+                }
+
+                const std::string filename = json_value(task.data, "filename", std::string());
+
+                task_result res;
+                res.id = task.id;
+                res.multitask_id = task.multitask_id;
+                res.stop = true;
+                res.error = false;
+
+                if (task.type == TASK_TYPE_SLOT_SAVE)
+                {
+                    // only tokens that have been evaluated are in the KV cache
+                    const size_t n_tokens = std::min((size_t) slot.n_past, slot.cache_tokens.size());
+                    const size_t n_written = llama_state_seq_save_file(ctx, filename.c_str(), slot.id, slot.cache_tokens.data(), n_tokens);
+                    if (n_written == 0)
+                    {
+                        send_error(task, "failed to save slot");
+                        break;
+                    }
+
+                    res.result_json = {
+                        {"slot_id",   slot.id},
+                        {"n_saved",   n_tokens},
+                        {"n_written", n_written},
+                    };
+                }
+                else
+                {
+                    std::vector<llama_token> tokens(slot.n_ctx);
+                    size_t n_tokens = 0;
+
+                    llama_kv_cache_seq_rm(ctx, slot.id, -1, -1);
+                    const size_t n_read = llama_state_seq_load_file(ctx, filename.c_str(), slot.id, tokens.data(), tokens.size(), &n_tokens);
+                    if (n_read == 0)
+                    {
+                        slot.cache_tokens.clear();
+                        send_error(task, "failed to restore slot");
+                        break;
+                    }
+
+                    tokens.resize(n_tokens);
+                    slot.cache_tokens = tokens;
+                    slot.n_past = n_tokens;
+                    slot.n_past_dft = 0;
+
+                    res.result_json = {
+                        {"slot_id",    slot.id},
+                        {"n_restored", n_tokens},
+                        {"n_read",     n_read},
+                    };
+                }
+
+                queue_results.send(res);
+            } break;
+            case TASK_TYPE_SET_ADAPTERS: {
+                // adapters change the weights of every slot, so they wait
+                // for all of them to finish
+                bool busy = false;
+                for (server_slot &slot : slots)
+                {
+                    busy = busy || !slot.available();
+                }
+
+                if (busy)
+                {
+                    queue_tasks.defer(task);
+                    break;
+                }
+
+                std::vector<std::tuple<std::string, float>> adapters;
+                for (const json &adapter : task.data["adapters"])
+                {
+                    adapters.emplace_back(json_value(adapter, "path", std::string()), json_value(adapter, "scale", 1.0f));
+                }
+
+                // adapters are merged into the weights, which can't be
+                // written when they are mapped from the model file
+                if (params.use_mmap && !adapters.empty())
+                {
+                    send_error(task, "adapters require the model to be loaded without mmap");
+                    break;
+                }
+
+                if (!set_adapters(adapters))
+                {
+                    send_error(task, "failed to apply adapters");
+                    break;
+                }
+
+                task_result res;
+                res.id = task.id;
+                res.multitask_id = task.multitask_id;
+                res.stop = true;
+                res.error = false;
+                res.result_json = {{"n_adapters", lora_dynamic.size()}};
+                queue_results.send(res);
+            } break;
             case TASK_TYPE_METRICS: {
                 json slots_data        = json::array();
                 int n_idle_slots       = 0;
@@ -1508,12 +1807,17 @@ struct llama_server_context
         result.stop = true;
         result.error = false;
 
+        // subtasks finish in any order across slots, but their ids were
+        // assigned in prompt order so sort to return results in that order
+        std::sort(multitask.results.begin(), multitask.results.end(),
+            [](const task_result & a, const task_result & b) { return a.id < b.id; });
+
         // collect json results into one json result
         std::vector<json> result_jsons;
         for (auto& subres : multitask.results)
         {
             result_jsons.push_back(subres.result_json);
-            result.error = result.error && subres.error;
+            result.error = result.error || subres.error;
         }
         result.result_json = json{ { "results", result_jsons } };
         queue_results.send(result);
@@ -1569,6 +1873,20 @@ struct llama_server_context
                     llama_kv_cache_seq_rm (ctx, slot.id, n_keep            , n_keep + n_discard);
                     llama_kv_cache_seq_add(ctx, slot.id, n_keep + n_discard, system_tokens.size() + slot.n_past, -n_discard);
 
+                    if (ctx_dft != nullptr)
+                    {
+                        if (slot.n_past_dft >= n_keep + n_discard)
+                        {
+                            llama_kv_cache_seq_rm (ctx_dft, slot.id, n_keep            , n_keep + n_discard);
+                            llama_kv_cache_seq_add(ctx_dft, slot.id, n_keep + n_discard, slot.n_past_dft, -n_discard);
+                            slot.n_past_dft -= n_discard;
+                        }
+                        else
+                        {
+                            slot.n_past_dft = std::min(slot.n_past_dft, n_keep);
+                        }
+                    }
+
                     for (size_t i = n_keep + n_discard; i < slot.cache_tokens.size(); i++)
                     {
                         slot.cache_tokens[i - n_discard] = slot.cache_tokens[i];
@@ -1626,6 +1944,9 @@ struct llama_server_context
         // process in chunks of params.n_batch
         int32_t n_batch = params.n_batch;
 
+        // slots that draft their next tokens once the batch is decoded
+        std::vector<server_slot *> speculating;
+
         // assign workload to the slots
         if (params.cont_batching || batch.n_tokens == 0)
         {
@@ -1770,6 +2091,9 @@ struct llama_server_context
                     });
                     llama_kv_cache_seq_rm(ctx, slot.id, p0, -1);
 
+                    // the draft model keeps what it shares with the new prompt
+                    slot.n_past_dft = std::min(slot.n_past_dft, slot.n_past);
+
                     LOG_VERBOSE("prompt ingested", {
                                                     {"n_past",  slot.n_past},
                                                     {"cached",  tokens_to_str(ctx, slot.cache_tokens.cbegin(), slot.cache_tokens.cbegin() + slot.n_past)},
@@ -1940,6 +2264,19 @@ struct llama_server_context
                     result.probs.push_back({cur_p.data[i].id, cur_p.data[i].p});
                 }
 
+                if (n_probs > 0)
+                {
+                    // the sampled token is not necessarily among the n_probs most likely
+                    for (size_t i = 0; i < cur_p.size; ++i)
+                    {
+                        if (cur_p.data[i].id == id)
+                        {
+                            result.prob = cur_p.data[i].p;
+                            break;
+                        }
+                    }
+                }
+
                 if (!process_token(result, slot))
                 {
                     slot.release();
@@ -1947,15 +2284,150 @@ struct llama_server_context
                     send_final_response(slot);
                     metrics.on_prediction(slot);
                 }
+                else if (ctx_dft != nullptr && slot.ga_n == 1 && n_probs == 0)
+                {
+                    speculating.push_back(&slot);
+                }
 
                 slot.i_batch = -1;
             }
         }
 
+        // speculation decodes its own batches, so it waits until every slot
+        // has sampled from the logits of the batch above
+        for (server_slot *slot : speculating)
+        {
+            speculate(*slot);
+        }
+
         LOG_VERBOSE("slots updated", {});
         return true;
     }
 
+    // speculate drafts the next tokens of a slot with the draft model and
+    // verifies them with a single batch of the model. Drafted tokens are kept
+    // up to the first one the model samples differently, so the output is the
+    // same as without a draft model.
+    void speculate(server_slot &slot)
+    {
+        int n_max = params.n_draft;
+        if (slot.params.n_predict != -1)
+        {
+            n_max = std::min(n_max, slot.params.n_predict - slot.n_decoded);
+        }
+        n_max = std::min(n_max, slot.n_ctx - (int) system_tokens.size() - (int) slot.cache_tokens.size() - 1);
+        if (n_max <= 0)
+        {
+            return;
+        }
+
+        // catch the draft model up with the slot, including the token that
+        // was just sampled
+        const int n_tokens = (int) slot.cache_tokens.size();
+        slot.n_past_dft = std::min(slot.n_past_dft, n_tokens - 1);
+        llama_kv_cache_seq_rm(ctx_dft, slot.id, slot.n_past_dft, -1);
+        while (slot.n_past_dft < n_tokens)
+        {
+            const int n_eval = std::min(params.n_batch, n_tokens - slot.n_past_dft);
+
+            llama_batch_clear(batch_dft);
+            for (int i = 0; i < n_eval; i++)
+            {
+                const int pos = slot.n_past_dft + i;
+                llama_batch_add(batch_dft, slot.cache_tokens[pos], pos, { slot.id }, pos == n_tokens - 1);
+            }
+
+            if (llama_decode(ctx_dft, batch_dft) != 0)
+            {
+                LOG_ERROR("failed to decode draft batch", {{"slot_id", slot.id}});
+                llama_kv_cache_seq_rm(ctx_dft, slot.id, -1, -1);
+                slot.n_past_dft = 0;
+                return;
+            }
+
+            slot.n_past_dft += n_eval;
+        }
+
+        const int n_vocab = std::min(llama_n_vocab(model), llama_n_vocab(model_dft));
+
+        std::vector<llama_token> draft;
+        llama_token id = greedy_token(ctx_dft, batch_dft.n_tokens - 1, n_vocab);
+        while (true)
+        {
+            draft.push_back(id);
+            if ((int) draft.size() >= n_max || llama_token_is_eog(model, id))
+            {
+                break;
+            }
+
+            llama_batch_clear(batch_dft);
+            llama_batch_add(batch_dft, id, slot.n_past_dft, { slot.id }, true);
+            if (llama_decode(ctx_dft, batch_dft) != 0)
+            {
+                break;
+            }
+
+            slot.n_past_dft++;
+            id = greedy_token(ctx_dft, 0, n_vocab);
+        }
+
+        // evaluate the sampled token and the draft together
+        llama_batch_clear(batch_spec);
+        llama_batch_add(batch_spec, slot.sampled, system_tokens.size() + slot.n_past, { slot.id }, true);
+        for (size_t i = 0; i < draft.size(); i++)
+        {
+            llama_batch_add(batch_spec, draft[i], system_tokens.size() + slot.n_past + 1 + i, { slot.id }, true);
+        }
+
+        if (llama_decode(ctx, batch_spec) != 0)
+        {
+            LOG_ERROR("failed to decode speculative batch", {{"slot_id", slot.id}});
+            llama_kv_cache_seq_rm(ctx, slot.id, system_tokens.size() + slot.n_past, -1);
+            slot.n_past_dft = n_tokens;
+            llama_kv_cache_seq_rm(ctx_dft, slot.id, slot.n_past_dft, -1);
+            return;
+        }
+
+        // the sampled token is in the KV cache now, as it would be after the
+        // next batch without speculation
+        slot.n_past += 1;
+        slot.n_draft_total += draft.size();
+
+        int n_accepted = 0;
+        for (size_t i = 0; i <= draft.size(); i++)
+        {
+            completion_token_output result;
+            result.tok = llama_sampling_sample(slot.ctx_sampling, ctx, NULL, i);
+            llama_sampling_accept(slot.ctx_sampling, ctx, result.tok, true);
+            slot.n_decoded += 1;
+
+            if (!process_token(result, slot))
+            {
+                slot.release();
+                slot.print_timings();
+                send_final_response(slot);
+                metrics.on_prediction(slot);
+                break;
+            }
+
+            if (i == draft.size() || result.tok != draft[i])
+            {
+                break;
+            }
+
+            // the drafted token was right so its KV cache entry is kept
+            n_accepted++;
+            slot.n_past += 1;
+        }
+
+        slot.n_draft_accepted += n_accepted;
+
+        // drop what was evaluated for rejected tokens
+        llama_kv_cache_seq_rm(ctx, slot.id, system_tokens.size() + slot.n_past, -1);
+        slot.n_past_dft = std::min(slot.n_past_dft, n_tokens + n_accepted);
+        llama_kv_cache_seq_rm(ctx_dft, slot.id, slot.n_past_dft, -1);
+    }
+
     json model_meta() {
         return json{
                 {"vocab_type", llama_vocab_type(model)},
@@ -1968,6 +2440,24 @@ struct llama_server_context
     }
 };
 
+// greedy_token returns the most likely of the first n_vocab tokens for the
+// output at idx of the last batch
+static llama_token greedy_token(llama_context *ctx, int idx, int n_vocab)
+{
+    const float *logits = llama_get_logits_ith(ctx, idx);
+
+    llama_token best = 0;
+    for (llama_token id = 1; id < n_vocab; id++)
+    {
+        if (logits[id] > logits[best])
+        {
+            best = id;
+        }
+    }
+
+    return best;
+}
+
 static void server_print_usage(const char *argv0, const gpt_params &params,
                                const server_params &sparams)
 {
@@ -2041,6 +2531,12 @@ static void server_print_usage(const char *argv0, const gpt_params &params,
     printf("  -ctv TYPE, --cache-type-v TYPE\n");
     printf("                            KV cache data type for V (default: f16)\n");
     printf("  --mmproj MMPROJ_FILE      path to a multimodal projector file for LLaVA.\n");
+    printf("  -md FNAME, --model-draft FNAME\n");
+    printf("                            draft model for speculative decoding (default: unused)\n");
+    printf("  --draft N                 number of tokens to draft for speculative decoding (default: %d)\n", params.n_draft);
+    printf("  -ngld N, --n-gpu-layers-draft N\n");
+    printf("                            number of layers of the draft model to store in VRAM\n");
+    printf("  --slot-save-path PATH     directory slots are saved to and restored from (default: disabled)\n");
     printf("  --log-format              log output format: json or text (default: json)\n");
     printf("  --log-disable             disables logging to a file.\n");
     printf("  --slots-endpoint-disable  disables slots monitoring endpoint.\n");
@@ -2488,6 +2984,53 @@ static void server_params_parse(int argc, char **argv, server_params &sparams, g
             }
             params.mmproj = argv[i];
         }
+        else if (arg == "--model-draft" || arg == "-md")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+            params.model_draft = argv[i];
+        }
+        else if (arg == "--slot-save-path")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+            sparams.slot_save_path = argv[i];
+            // the file names of slots are appended to the path
+            if (!sparams.slot_save_path.empty() && sparams.slot_save_path.back() != '/' && sparams.slot_save_path.back() != '\\')
+            {
+                sparams.slot_save_path += '/';
+            }
+        }
+        else if (arg == "--draft")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+            params.n_draft = std::stoi(argv[i]);
+        }
+        else if (arg == "--n-gpu-layers-draft" || arg == "-ngld")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+            if (llama_supports_gpu_offload()) {
+                params.n_gpu_layers_draft = std::stoi(argv[i]);
+            } else {
+                LOG_WARNING("Not compiled with GPU offload support, --n-gpu-layers-draft option will be ignored. "
+                        "See main README.md for information on enabling GPU BLAS support",
+                        {{"n_gpu_layers_draft", params.n_gpu_layers_draft}});
+            }
+        }
         else if (arg == "--log-format")
         {
             if (++i >= argc)
@@ -3124,6 +3667,82 @@ int main(int argc, char **argv) {
                 }
             });
 
+    // save or restore the KV cache of a slot to or from a file, waiting for
+    // the slot to become idle
+    const auto handle_slot_state = [&llama, &sparams](task_type type, const httplib::Request &req, httplib::Response &res)
+            {
+                const json body = json::parse(req.body);
+
+                // only file names in the slot save path can be saved to or
+                // restored from
+                const std::string filename = json_value(body, "filename", std::string());
+                if (sparams.slot_save_path.empty() || !validate_file_name(filename))
+                {
+                    res.status = 400;
+                    return res.set_content(json{{"error", "invalid filename"}}.dump(), "application/json; charset=utf-8");
+                }
+
+                task_server task;
+                task.id = llama.queue_tasks.get_new_id();
+                task.type = type;
+                task.target_id = -1;
+                task.data = {
+                    {"slot_id",  json_value(body, "slot_id", -1)},
+                    {"filename", sparams.slot_save_path + filename},
+                };
+
+                llama.queue_results.add_waiting_task_id(task.id);
+                llama.queue_tasks.post(task);
+
+                task_result result = llama.queue_results.recv(task.id);
+                llama.queue_results.remove_waiting_task_id(task.id);
+
+                if (result.error)
+                {
+                    res.status = 500;
+                    return res.set_content(json{{"error", result.result_json["content"]}}.dump(), "application/json; charset=utf-8");
+                }
+
+                return res.set_content(result.result_json.dump(), "application/json; charset=utf-8");
+            };
+
+    svr.Post("/slots/save", [&handle_slot_state](const httplib::Request &req, httplib::Response &res)
+            {
+                handle_slot_state(TASK_TYPE_SLOT_SAVE, req, res);
+            });
+
+    svr.Post("/slots/restore", [&handle_slot_state](const httplib::Request &req, httplib::Response &res)
+            {
+                handle_slot_state(TASK_TYPE_SLOT_RESTORE, req, res);
+            });
+
+    // apply adapters to the model for the requests that follow, waiting for
+    // all slots to become idle
+    svr.Post("/adapters", [&llama](const httplib::Request &req, httplib::Response &res)
+            {
+                const json body = json::parse(req.body);
+
+                task_server task;
+                task.id = llama.queue_tasks.get_new_id();
+                task.type = TASK_TYPE_SET_ADAPTERS;
+                task.target_id = -1;
+                task.data = {{"adapters", json_value(body, "adapters", json::array())}};
+
+                llama.queue_results.add_waiting_task_id(task.id);
+                llama.queue_tasks.post(task);
+
+                task_result result = llama.queue_results.recv(task.id);
+                llama.queue_results.remove_waiting_task_id(task.id);
+
+                if (result.error)
+                {
+                    res.status = 500;
+                    return res.set_content(json{{"error", result.result_json["content"]}}.dump(), "application/json; charset=utf-8");
+                }
+
+                return res.set_content(result.result_json.dump(), "application/json; charset=utf-8");
+            });
+
     svr.Post("/tokenize", [&llama](const httplib::Request &req, httplib::Response &res)
             {
                 res.set_header("Access-Control-Allow-Origin", req.get_header_value("Origin"));
@@ -3142,13 +3761,36 @@ int main(int argc, char **argv) {
                 res.set_header("Access-Control-Allow-Origin", req.get_header_value("Origin"));
                 const json body = json::parse(req.body);
                 std::string content;
+                std::vector<std::string> pieces;
                 if (body.count("tokens") != 0)
                 {
                     const std::vector<llama_token> tokens = body["tokens"];
+                    const int n_vocab = llama_n_vocab(llama.model);
+                    for (const auto & token : tokens)
+                    {
+                        if (token < 0 || token >= n_vocab)
+                        {
+                            res.status = 400;
+                            return res.set_content(json{{"error", "invalid token id " + std::to_string(token)}}.dump(), "application/json; charset=utf-8");
+                        }
+                    }
                     content = tokens_to_str(llama.ctx, tokens.cbegin(), tokens.cend());
+
+                    // the text of each token on its own
+                    if (json_value(body, "pieces", false))
+                    {
+                        for (auto it = tokens.cbegin(); it != tokens.cend(); ++it)
+                        {
+                            pieces.push_back(tokens_to_str(llama.ctx, it, it + 1));
+                        }
+                    }
                 }
 
-                const json data = format_detokenized_response(content);
+                json data = format_detokenized_response(content);
+                if (json_value(body, "pieces", false))
+                {
+                    data["pieces"] = pieces;
+                }
                 return res.set_content(data.dump(), "application/json; charset=utf-8");
             });
 
@@ -3166,6 +3808,49 @@ int main(int argc, char **argv) {
                     prompt = "";
                 }
 
+                // when asked to, tokenize each prompt so that prompts that
+                // don't fit in the context of a slot are truncated or
+                // rejected, and count the tokens evaluated for each
+                std::vector<int> n_tokens;
+                if (body.count("truncate") != 0)
+                {
+                    const bool truncate = json_value(body, "truncate", false);
+
+                    // prompts must leave room in the context, otherwise the
+                    // slot shortens them itself
+                    const int n_max = llama.slots.front().n_ctx - 1;
+
+                    json prompts = prompt.is_array() ? prompt : json::array({prompt});
+                    for (size_t i = 0; i < prompts.size(); i++)
+                    {
+                        std::vector<llama_token> tokens = llama.tokenize(prompts[i], llama.add_bos_token);
+                        if ((int) tokens.size() > n_max)
+                        {
+                            if (!truncate)
+                            {
+                                res.status = 400;
+                                const std::string error = "input " + std::to_string(i) + " length of " + std::to_string(tokens.size()) +
+                                                          " tokens exceeds maximum context length of " + std::to_string(n_max);
+                                return res.set_content(json{{"error", error}}.dump(), "application/json; charset=utf-8");
+                            }
+
+                            tokens.resize(n_max);
+                        }
+
+                        n_tokens.push_back(tokens.size());
+                        prompts[i] = tokens;
+                    }
+
+                    prompt = prompts;
+                }
+
+                // a list of prompts is split into one subtask per prompt,
+                // a single prompt is queued as is
+                if (prompt.is_array() && prompt.size() == 1)
+                {
+                    prompt = prompt[0];
+                }
+
                 json image_data;
                 if (body.count("image_data") != 0) {
                     image_data = body["image_data"];
@@ -3184,8 +3869,31 @@ int main(int argc, char **argv) {
                 task_result result = llama.queue_results.recv(task_id);
                 llama.queue_results.remove_waiting_task_id(task_id);
 
-                // send the result
-                return res.set_content(result.result_json.dump(), "application/json; charset=utf-8");
+                if (result.error)
+                {
+                    res.status = 500;
+                    return res.set_content(result.result_json.dump(), "application/json; charset=utf-8");
+                }
+
+                // send the embeddings, one per prompt
+                std::vector<json> responses = result.result_json.value("results", std::vector<json>{result.result_json});
+                json embeddings = json::array();
+                for (const auto & elem : responses)
+                {
+                    if (!elem.contains("embedding"))
+                    {
+                        res.status = 500;
+                        return res.set_content(elem.dump(), "application/json; charset=utf-8");
+                    }
+                    embeddings.push_back(elem.at("embedding"));
+                }
+
+                json data = json{{"embedding", embeddings}};
+                if (!n_tokens.empty())
+                {
+                    data["tokens"] = n_tokens;
+                }
+                return res.set_content(data.dump(), "application/json; charset=utf-8");
             });
 
     // GG: if I put the main loop inside a thread, it crashes on the first request when build in Debug!?
@@ -70,7 +70,10 @@ enum task_type {
     TASK_TYPE_COMPLETION,
     TASK_TYPE_CANCEL,
     TASK_TYPE_NEXT_RESPONSE,
-    TASK_TYPE_METRICS
+    TASK_TYPE_METRICS,
+    TASK_TYPE_SLOT_SAVE,
+    TASK_TYPE_SLOT_RESTORE,
+    TASK_TYPE_SET_ADAPTERS
 };
 
 struct task_server {
@@ -107,6 +110,7 @@ struct completion_token_output {
 
     std::vector<token_prob> probs;
     llama_token tok;
+    float prob = 0.0f; // probability of tok itself, which may not be in probs
     std::string text_to_send;
 };
 
@@ -577,6 +581,26 @@ static size_t common_part(const std::vector<llama_token> &a, const std::vector<l
     return i;
 }
 
+// validate_file_name reports whether name is a plain file name that can't
+// refer to a file outside the directory it is joined with
+static bool validate_file_name(const std::string &name)
+{
+    if (name.empty() || name.size() > 255)
+    {
+        return false;
+    }
+
+    for (const unsigned char c : name)
+    {
+        if (c < 0x20 || c == 0x7f || c == '/' || c == '\\' || c == ':')
+        {
+            return false;
+        }
+    }
+
+    return name.find("..") == std::string::npos;
+}
+
 static bool ends_with(const std::string &str, const std::string &suffix)
 {
     return str.size() >= suffix.size() &&
@@ -651,6 +675,7 @@ static json probs_vector_to_json(const llama_context *ctx, const std::vector<com
         std::string tok_str = tokens_to_output_formatted_string(ctx, prob.tok);
         out.push_back(json{
             {"content", tok_str},
+            {"prob",    prob.prob},
             {"probs",   probs_for_token},
         });
     }
@@ -45,6 +45,11 @@ func newGGLA(container *containerGGLA) *ggla {
 	}
 }
 
+// NewGGLA returns a ggla adapter to encode
+func NewGGLA() *ggla {
+	return newGGLA(&containerGGLA{version: 1})
+}
+
 func (llm *ggla) KV() KV {
 	return llm.kv
 }
@@ -126,3 +131,44 @@ func (llm *ggla) decode(rs io.ReadSeeker) error {
 		llm.tensors = append(llm.tensors, &t)
 	}
 }
+
+// Encode writes a ggla adapter with the rank "r" and "alpha" from kv. As with
+// gguf, tensor shapes are in row major order and are written reversed.
+func (llm *ggla) Encode(ws io.WriteSeeker, kv KV, tensors []Tensor) error {
+	for _, v := range []uint32{FILE_MAGIC_GGLA, llm.version, uint32(kv.u64("r")), uint32(kv.u64("alpha"))} {
+		if err := binary.Write(ws, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range tensors {
+		header := []uint32{uint32(len(t.Shape)), uint32(len(t.Name)), t.Kind}
+		for i := range t.Shape {
+			header = append(header, uint32(t.Shape[len(t.Shape)-1-i]))
+		}
+
+		if err := binary.Write(ws, binary.LittleEndian, header); err != nil {
+			return err
+		}
+
+		if _, err := ws.Write([]byte(t.Name)); err != nil {
+			return err
+		}
+
+		// tensor data is aligned to 32 bytes
+		offset, err := ws.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		if _, err := ws.Write(make([]byte, (offset+31)&^31-offset)); err != nil {
+			return err
+		}
+
+		if _, err := t.WriteTo(ws); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
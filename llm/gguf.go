@@ -469,6 +469,34 @@ var ggufKVOrder = map[string][]string{
 		"gemma.attention.layer_norm_rms_epsilon",
 		"gemma.attention.key_length",
 		"gemma.attention.value_length",
+		"qwen2.context_length",
+		"qwen2.embedding_length",
+		"qwen2.block_count",
+		"qwen2.feed_forward_length",
+		"qwen2.attention.head_count",
+		"qwen2.attention.head_count_kv",
+		"qwen2.attention.layer_norm_rms_epsilon",
+		"qwen2.rope.freq_base",
+		"phi3.context_length",
+		"phi3.embedding_length",
+		"phi3.block_count",
+		"phi3.feed_forward_length",
+		"phi3.attention.head_count",
+		"phi3.attention.head_count_kv",
+		"phi3.attention.layer_norm_rms_epsilon",
+		"phi3.rope.dimension_count",
+		"phi3.rope.freq_base",
+		"phi3.rope.scaling.original_context_length",
+		"phi3.rope.scaling.attn_factor",
+		"stablelm.context_length",
+		"stablelm.embedding_length",
+		"stablelm.block_count",
+		"stablelm.feed_forward_length",
+		"stablelm.rope.dimension_count",
+		"stablelm.attention.head_count",
+		"stablelm.attention.head_count_kv",
+		"stablelm.attention.layer_norm_epsilon",
+		"stablelm.use_parallel_residual",
 		"general.file_type",
 		"tokenizer.ggml.pre",
 		"tokenizer.ggml.model",
@@ -0,0 +1,895 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSchemaRepetitions bounds minItems, maxItems, minLength and maxLength since
+// each repetition is spelled out in the grammar
+const maxSchemaRepetitions = 1024
+
+// schemaRules are the rules for values without further constraints, written
+// in the same style as jsonGrammar
+var schemaRules = map[string]string{
+	"value":   `object | array | string | number | boolean | null`,
+	"object":  `"{" ws ( string ":" ws value ("," ws string ":" ws value)* )? "}" ws`,
+	"array":   `"[" ws ( value ("," ws value)* )? "]" ws`,
+	"string":  `"\"" char* "\"" ws`,
+	"char":    `[^"\\\x7F\x00-\x1F] | "\\" (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F])`,
+	"number":  `("-"? ([0-9] | [1-9] [0-9]*)) ("." [0-9]+)? ([eE] [-+]? [0-9]+)? ws`,
+	"integer": `("-"? ([0-9] | [1-9] [0-9]*)) ws`,
+	"boolean": `("true" | "false") ws`,
+	"null":    `"null" ws`,
+	"ws":      `([ \t\n] ws)?`,
+}
+
+// schemaRuleDeps lists the rules each of schemaRules refers to
+var schemaRuleDeps = map[string][]string{
+	"value":   {"object", "array", "string", "number", "boolean", "null"},
+	"object":  {"string", "value", "ws"},
+	"array":   {"value", "ws"},
+	"string":  {"char", "ws"},
+	"number":  {"ws"},
+	"integer": {"ws"},
+	"boolean": {"ws"},
+	"null":    {"ws"},
+}
+
+// schemaKeywords are the JSON Schema keywords that can be compiled to a
+// grammar, or that are annotations without effect on the output
+var schemaKeywords = []string{
+	"type", "properties", "required", "additionalProperties", "items",
+	"minItems", "maxItems", "minLength", "maxLength", "enum", "const",
+	"anyOf", "oneOf", "$ref", "$defs", "definitions",
+	"$schema", "$id", "$comment", "title", "description", "default",
+	"examples", "deprecated", "readOnly", "writeOnly",
+}
+
+type jsonSchema struct {
+	Type                 json.RawMessage            `json:"type"`
+	Properties           schemaProperties           `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Enum                 []json.RawMessage          `json:"enum"`
+	Const                json.RawMessage            `json:"const"`
+	AnyOf                []json.RawMessage          `json:"anyOf"`
+	OneOf                []json.RawMessage          `json:"oneOf"`
+	Ref                  string                     `json:"$ref"`
+	Defs                 map[string]json.RawMessage `json:"$defs"`
+	Definitions          map[string]json.RawMessage `json:"definitions"`
+}
+
+type schemaProperty struct {
+	name   string
+	schema json.RawMessage
+}
+
+// schemaProperties keeps properties in the order they are declared so the
+// model generates them in that order
+type schemaProperties []schemaProperty
+
+func (p *schemaProperties) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if t != json.Delim('{') {
+		return errors.New("properties must be an object")
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		var schema json.RawMessage
+		if err := dec.Decode(&schema); err != nil {
+			return err
+		}
+
+		*p = append(*p, schemaProperty{name: t.(string), schema: schema})
+	}
+
+	return nil
+}
+
+// FormatGrammar returns the grammar constraining output to format, which is
+// either empty, "json" for any JSON object or a JSON Schema object.
+func FormatGrammar(format json.RawMessage) (string, error) {
+	format = bytes.TrimSpace(format)
+	switch {
+	case len(format) == 0, bytes.Equal(format, []byte(`""`)), bytes.Equal(format, []byte("null")):
+		return "", nil
+	case bytes.Equal(format, []byte(`"json"`)):
+		return jsonGrammar, nil
+	case format[0] == '{':
+		return SchemaToGrammar(format)
+	default:
+		return "", errors.New("format must be json or a JSON schema object")
+	}
+}
+
+// SchemaToGrammar compiles a JSON Schema into a GBNF grammar for JSON values
+// matching the schema. Schema keywords that can't be expressed in a grammar,
+// such as pattern or minimum, are reported as errors.
+func SchemaToGrammar(schema json.RawMessage) (string, error) {
+	c := schemaConverter{rules: make(map[string]string), refs: make(map[string]string)}
+
+	var root jsonSchema
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return "", fmt.Errorf("invalid schema: %w", err)
+	}
+
+	c.defs = root.Defs
+	if c.defs == nil {
+		c.defs = root.Definitions
+	}
+
+	name, err := c.visit(schema, "root")
+	if err != nil {
+		return "", err
+	}
+
+	if name != "root" {
+		c.add("root", name)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "root ::= %s\n", c.rules["root"])
+	for _, name := range c.order {
+		if name != "root" {
+			fmt.Fprintf(&sb, "%s ::= %s\n", name, c.rules[name])
+		}
+	}
+
+	return sb.String(), nil
+}
+
+type schemaConverter struct {
+	rules map[string]string
+	order []string
+	defs  map[string]json.RawMessage
+	refs  map[string]string
+}
+
+var invalidRuleChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// add adds a rule, renaming it if a different rule already uses the name
+func (c *schemaConverter) add(name, body string) string {
+	name = invalidRuleChars.ReplaceAllString(name, "-")
+	key := name
+	for i := 1; ; i++ {
+		existing, ok := c.rules[key]
+		if !ok {
+			break
+		}
+
+		if existing == body {
+			return key
+		}
+
+		key = fmt.Sprintf("%s%d", name, i)
+	}
+
+	c.rules[key] = body
+	c.order = append(c.order, key)
+	return key
+}
+
+// reserve claims an unused rule name whose body is set later, for recursive refs
+func (c *schemaConverter) reserve(name string) string {
+	name = invalidRuleChars.ReplaceAllString(name, "-")
+	key := name
+	for i := 1; ; i++ {
+		if _, ok := c.rules[key]; !ok {
+			break
+		}
+
+		key = fmt.Sprintf("%s%d", name, i)
+	}
+
+	c.rules[key] = ""
+	c.order = append(c.order, key)
+	return key
+}
+
+// primitive adds one of schemaRules along with the rules it refers to
+func (c *schemaConverter) primitive(name string) string {
+	if _, ok := c.rules[name]; !ok {
+		c.rules[name] = schemaRules[name]
+		c.order = append(c.order, name)
+		for _, dep := range schemaRuleDeps[name] {
+			c.primitive(dep)
+		}
+	}
+
+	return name
+}
+
+func (c *schemaConverter) visit(raw json.RawMessage, name string) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	switch string(raw) {
+	case "true", "{}":
+		return c.primitive("value"), nil
+	case "false":
+		return "", errors.New("unsupported schema: false")
+	}
+
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keywords); err != nil {
+		return "", fmt.Errorf("invalid schema: %w", err)
+	}
+
+	for k := range keywords {
+		if !slices.Contains(schemaKeywords, k) {
+			return "", fmt.Errorf("unsupported schema keyword %q", k)
+		}
+	}
+
+	var s jsonSchema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", fmt.Errorf("invalid schema: %w", err)
+	}
+
+	switch {
+	case s.Ref != "":
+		return c.ref(s.Ref)
+	case s.Const != nil:
+		lit, err := jsonLiteral(s.Const)
+		if err != nil {
+			return "", err
+		}
+
+		c.primitive("ws")
+		return c.add(name, lit+" ws"), nil
+	case s.Enum != nil:
+		if len(s.Enum) == 0 {
+			return "", errors.New("enum must not be empty")
+		}
+
+		alts := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			lit, err := jsonLiteral(v)
+			if err != nil {
+				return "", err
+			}
+
+			alts[i] = lit
+		}
+
+		c.primitive("ws")
+		return c.add(name, "("+strings.Join(alts, " | ")+") ws"), nil
+	case s.AnyOf != nil || s.OneOf != nil:
+		return c.alternatives(name, append(s.AnyOf, s.OneOf...))
+	}
+
+	types, err := s.types()
+	if err != nil {
+		return "", err
+	}
+
+	switch len(types) {
+	case 0:
+		return c.primitive("value"), nil
+	case 1:
+		return c.visitType(&s, types[0], name)
+	}
+
+	alts := make([]string, len(types))
+	for i, t := range types {
+		alt, err := c.visitType(&s, t, name+"-"+t)
+		if err != nil {
+			return "", err
+		}
+
+		alts[i] = alt
+	}
+
+	return c.add(name, strings.Join(alts, " | ")), nil
+}
+
+func (c *schemaConverter) alternatives(name string, schemas []json.RawMessage) (string, error) {
+	if len(schemas) == 0 {
+		return "", errors.New("anyOf and oneOf must not be empty")
+	}
+
+	alts := make([]string, len(schemas))
+	for i, schema := range schemas {
+		alt, err := c.visit(schema, fmt.Sprintf("%s-%d", name, i))
+		if err != nil {
+			return "", err
+		}
+
+		alts[i] = alt
+	}
+
+	return c.add(name, strings.Join(alts, " | ")), nil
+}
+
+func (c *schemaConverter) ref(ref string) (string, error) {
+	if name, ok := c.refs[ref]; ok {
+		return name, nil
+	}
+
+	var def string
+	for _, prefix := range []string{"#/$defs/", "#/definitions/"} {
+		if after, ok := strings.CutPrefix(ref, prefix); ok {
+			def = after
+			break
+		}
+	}
+
+	schema, ok := c.defs[def]
+	if def == "" || !ok {
+		return "", fmt.Errorf("unsupported schema reference %q: only local references to $defs or definitions are supported", ref)
+	}
+
+	// reserve the name before visiting so recursive references resolve to it
+	name := c.reserve("ref-" + def)
+	c.refs[ref] = name
+
+	body, err := c.visit(schema, name+"-def")
+	if err != nil {
+		return "", err
+	}
+
+	c.rules[name] = body
+	return name, nil
+}
+
+// types returns the types allowed by the schema, inferring object or array
+// from the keywords present if type is not set
+func (s *jsonSchema) types() ([]string, error) {
+	if s.Type == nil {
+		switch {
+		case s.Properties != nil || s.Required != nil || s.AdditionalProperties != nil:
+			return []string{"object"}, nil
+		case s.Items != nil || s.MinItems != nil || s.MaxItems != nil:
+			return []string{"array"}, nil
+		case s.MinLength != nil || s.MaxLength != nil:
+			return []string{"string"}, nil
+		}
+
+		return nil, nil
+	}
+
+	var t string
+	if err := json.Unmarshal(s.Type, &t); err == nil {
+		return []string{t}, nil
+	}
+
+	var ts []string
+	if err := json.Unmarshal(s.Type, &ts); err != nil {
+		return nil, errors.New("type must be a string or a list of strings")
+	}
+
+	return ts, nil
+}
+
+func (c *schemaConverter) visitType(s *jsonSchema, t, name string) (string, error) {
+	switch t {
+	case "object":
+		return c.visitObject(s, name)
+	case "array":
+		var item string
+		if s.Items != nil {
+			var err error
+			item, err = c.visit(s.Items, name+"-item")
+			if err != nil {
+				return "", err
+			}
+		} else {
+			item = c.primitive("value")
+		}
+
+		items, err := repetition(item, `"," ws`, s.MinItems, s.MaxItems)
+		if err != nil {
+			return "", err
+		}
+
+		c.primitive("ws")
+		return c.add(name, `"[" ws `+items+` "]" ws`), nil
+	case "string":
+		if s.MinLength == nil && s.MaxLength == nil {
+			return c.primitive("string"), nil
+		}
+
+		chars, err := repetition(c.primitive("char"), "", s.MinLength, s.MaxLength)
+		if err != nil {
+			return "", err
+		}
+
+		c.primitive("ws")
+		return c.add(name, `"\"" `+chars+` "\"" ws`), nil
+	case "number", "integer", "boolean", "null":
+		return c.primitive(t), nil
+	default:
+		return "", fmt.Errorf("unsupported schema type %q", t)
+	}
+}
+
+func (c *schemaConverter) visitObject(s *jsonSchema, name string) (string, error) {
+	c.primitive("ws")
+
+	if s.Properties == nil {
+		additional := bytes.TrimSpace(s.AdditionalProperties)
+		switch string(additional) {
+		case "", "true":
+			return c.primitive("object"), nil
+		case "false":
+			return c.add(name, `"{" ws "}" ws`), nil
+		}
+
+		// an object whose values all match a schema
+		value, err := c.visit(additional, name+"-value")
+		if err != nil {
+			return "", err
+		}
+
+		kv := c.add(name+"-kv", c.primitive("string")+` ":" ws `+value)
+		return c.add(name, `"{" ws ( `+kv+` ("," ws `+kv+`)* )? "}" ws`), nil
+	}
+
+	// additional properties are never generated since output without them
+	// still matches the schema
+	var required, optional []string
+	kvs := make(map[string]string)
+	for _, p := range s.Properties {
+		value, err := c.visit(p.schema, name+"-"+p.name)
+		if err != nil {
+			return "", err
+		}
+
+		b, err := json.Marshal(p.name)
+		if err != nil {
+			return "", err
+		}
+
+		key, err := jsonLiteral(b)
+		if err != nil {
+			return "", err
+		}
+
+		kvs[p.name] = c.add(name+"-"+p.name+"-kv", key+` ws ":" ws `+value)
+		if slices.Contains(s.Required, p.name) {
+			required = append(required, p.name)
+		} else {
+			optional = append(optional, p.name)
+		}
+	}
+
+	for _, r := range s.Required {
+		if _, ok := kvs[r]; !ok {
+			return "", fmt.Errorf("required property %q is not defined in properties", r)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`"{" ws`)
+	for i, r := range required {
+		if i > 0 {
+			sb.WriteString(` "," ws`)
+		}
+		sb.WriteString(" " + kvs[r])
+	}
+
+	if len(optional) > 0 {
+		// each optional property may be followed by any of the optional
+		// properties declared after it
+		var rest func(names []string, first bool) string
+		rest = func(names []string, first bool) string {
+			kv := kvs[names[0]]
+			expr := kv
+			if !first {
+				expr = `( "," ws ` + kv + ` )?`
+			}
+
+			if len(names) > 1 {
+				expr += " " + c.add(name+"-"+names[0]+"-rest", rest(names[1:], false))
+			}
+
+			return expr
+		}
+
+		alts := make([]string, len(optional))
+		for i := range optional {
+			alts[i] = rest(optional[i:], true)
+		}
+
+		if len(required) > 0 {
+			sb.WriteString(` ( "," ws ( ` + strings.Join(alts, " | ") + ` ) )?`)
+		} else {
+			sb.WriteString(` ( ` + strings.Join(alts, " | ") + ` )?`)
+		}
+	}
+
+	sb.WriteString(` "}" ws`)
+	return c.add(name, sb.String()), nil
+}
+
+// repetition returns an expression matching between lower and upper items
+// separated by sep. A nil upper means no upper bound.
+func repetition(item, sep string, lower, upper *int) (string, error) {
+	lo, hi := 0, -1
+	if lower != nil {
+		lo = *lower
+	}
+
+	if upper != nil {
+		hi = *upper
+	}
+
+	switch {
+	case lo < 0 || (hi >= 0 && hi < lo):
+		return "", errors.New("invalid schema: minimum must not be negative or larger than the maximum")
+	case lo > maxSchemaRepetitions || hi > maxSchemaRepetitions:
+		return "", fmt.Errorf("unsupported schema: bounds larger than %d", maxSchemaRepetitions)
+	case hi == 0:
+		return "", nil
+	}
+
+	next := item
+	if sep != "" {
+		next = sep + " " + item
+	}
+
+	var sb strings.Builder
+	sb.WriteString(item)
+	for i := 1; i < lo; i++ {
+		sb.WriteString(" " + next)
+	}
+
+	if hi < 0 {
+		sb.WriteString(" (" + next + ")*")
+	} else {
+		// nest the optional items so that each requires the one before it
+		var optional string
+		for i := max(lo, 1); i < hi; i++ {
+			optional = "(" + next + " " + optional + ")?"
+		}
+		sb.WriteString(" " + optional)
+	}
+
+	if lo == 0 {
+		return "(" + sb.String() + ")?", nil
+	}
+
+	return sb.String(), nil
+}
+
+// jsonLiteral returns a grammar literal matching the compact JSON encoding of v
+func jsonLiteral(v json.RawMessage) (string, error) {
+	var a any
+	if err := json.Unmarshal(v, &a); err != nil {
+		return "", fmt.Errorf("invalid schema value: %w", err)
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(a); err != nil {
+		return "", err
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(strings.TrimSuffix(b.String(), "\n")) + `"`, nil
+}
+
+// ValidateGrammar checks that grammar is a well-formed GBNF grammar with a
+// root rule and no references to undefined rules, so that mistakes are
+// reported before the request reaches the runner
+func ValidateGrammar(grammar string) error {
+	p := grammarParser{src: grammar, defined: make(map[string]bool)}
+	if err := p.parse(); err != nil {
+		return fmt.Errorf("invalid grammar: %w", err)
+	}
+
+	return nil
+}
+
+type grammarParser struct {
+	src string
+	pos int
+
+	defined map[string]bool
+
+	// refs maps the rules referenced in the grammar to the offset of their
+	// first reference
+	refs map[string]int
+}
+
+func (p *grammarParser) errorf(pos int, format string, args ...any) error {
+	line := strings.Count(p.src[:pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *grammarParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *grammarParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+func isGrammarWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'
+}
+
+// space skips whitespace and comments. Newlines end a rule so they are only
+// skipped inside groups and between rules.
+func (p *grammarParser) space(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == '#':
+			for !p.eof() && p.peek() != '\r' && p.peek() != '\n' {
+				p.pos++
+			}
+		case c == ' ' || c == '\t' || newlines && (c == '\r' || c == '\n'):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *grammarParser) name() string {
+	start := p.pos
+	for !p.eof() && isGrammarWordChar(p.peek()) {
+		p.pos++
+	}
+
+	return p.src[start:p.pos]
+}
+
+func (p *grammarParser) parse() error {
+	p.refs = make(map[string]int)
+
+	p.space(true)
+	for !p.eof() {
+		start := p.pos
+		name := p.name()
+		if name == "" {
+			return p.errorf(start, "expected rule name, got %q", p.peek())
+		}
+
+		p.space(false)
+		if !strings.HasPrefix(p.src[p.pos:], "::=") {
+			return p.errorf(p.pos, "expected ::= after rule name %q", name)
+		}
+
+		p.pos += len("::=")
+		p.space(true)
+
+		p.defined[name] = true
+		if err := p.alternates(false); err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasPrefix(p.src[p.pos:], "\r\n"):
+			p.pos += 2
+		case p.peek() == '\r' || p.peek() == '\n':
+			p.pos++
+		case !p.eof():
+			return p.errorf(p.pos, "unexpected %q in rule %q", p.peek(), name)
+		}
+
+		p.space(true)
+	}
+
+	if !p.defined["root"] {
+		return errors.New("missing root rule")
+	}
+
+	for name, pos := range p.refs {
+		if !p.defined[name] {
+			return p.errorf(pos, "undefined rule %q", name)
+		}
+	}
+
+	return nil
+}
+
+func (p *grammarParser) alternates(nested bool) error {
+	for {
+		if err := p.sequence(nested); err != nil {
+			return err
+		}
+
+		if p.peek() != '|' {
+			return nil
+		}
+
+		p.pos++
+		p.space(true)
+	}
+}
+
+func (p *grammarParser) sequence(nested bool) error {
+	// item reports whether there is a preceding item for a repetition
+	// operator to apply to
+	var item bool
+	for !p.eof() {
+		start := p.pos
+		switch c := p.peek(); {
+		case c == '"':
+			p.pos++
+			for p.peek() != '"' {
+				if p.eof() {
+					return p.errorf(start, "unterminated literal")
+				}
+
+				if err := p.char(); err != nil {
+					return err
+				}
+			}
+			p.pos++
+		case c == '[':
+			p.pos++
+			if p.peek() == '^' {
+				p.pos++
+			}
+
+			for p.peek() != ']' {
+				if p.eof() {
+					return p.errorf(start, "unterminated character class")
+				}
+
+				if err := p.char(); err != nil {
+					return err
+				}
+
+				if p.peek() == '-' && p.pos+1 < len(p.src) && p.src[p.pos+1] != ']' {
+					p.pos++
+					if err := p.char(); err != nil {
+						return err
+					}
+				}
+			}
+			p.pos++
+		case isGrammarWordChar(c):
+			name := p.name()
+			if _, ok := p.refs[name]; !ok {
+				p.refs[name] = start
+			}
+		case c == '(':
+			p.pos++
+			p.space(true)
+			if err := p.alternates(true); err != nil {
+				return err
+			}
+
+			if p.peek() != ')' {
+				return p.errorf(start, "unterminated group")
+			}
+			p.pos++
+		case c == '.':
+			p.pos++
+		case c == '*' || c == '+' || c == '?':
+			if !item {
+				return p.errorf(start, "expected item before %q", c)
+			}
+			p.pos++
+		case c == '{':
+			if !item {
+				return p.errorf(start, "expected item before %q", c)
+			}
+
+			if err := p.repetition(); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+
+		item = true
+		p.space(nested)
+	}
+
+	return nil
+}
+
+// repetition parses a {m}, {m,} or {m,n} repetition operator
+func (p *grammarParser) repetition() error {
+	start := p.pos
+	p.pos++
+	p.space(true)
+
+	lower, ok := p.integer()
+	if !ok {
+		return p.errorf(start, "expected number in repetition")
+	}
+
+	upper := lower
+	p.space(true)
+	if p.peek() == ',' {
+		p.pos++
+		p.space(true)
+
+		upper, ok = p.integer()
+		if !ok {
+			upper = -1
+		}
+
+		p.space(true)
+	}
+
+	if p.peek() != '}' {
+		return p.errorf(start, "unterminated repetition")
+	}
+	p.pos++
+
+	if upper >= 0 && upper < lower {
+		return p.errorf(start, "invalid repetition {%d,%d}", lower, upper)
+	}
+
+	return nil
+}
+
+func (p *grammarParser) integer() (int, bool) {
+	start := p.pos
+	for !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+		p.pos++
+	}
+
+	n, err := strconv.Atoi(p.src[start:p.pos])
+	return n, err == nil
+}
+
+// char parses a single, possibly escaped, character of a literal or
+// character class
+func (p *grammarParser) char() error {
+	if p.peek() != '\\' {
+		_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += size
+		return nil
+	}
+
+	start := p.pos
+	p.pos++
+
+	var digits int
+	switch p.peek() {
+	case 'x':
+		digits = 2
+	case 'u':
+		digits = 4
+	case 'U':
+		digits = 8
+	case 't', 'r', 'n', '\\', '"', '[', ']':
+		p.pos++
+		return nil
+	default:
+		return p.errorf(start, "unknown escape %q", p.src[start:min(p.pos+1, len(p.src))])
+	}
+
+	p.pos++
+	for range digits {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", rune(p.peek())) || p.eof() {
+			return p.errorf(start, "expected %d hex digits in escape", digits)
+		}
+		p.pos++
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package llm
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	grammarLiterals   = regexp.MustCompile(`"(\\.|[^"\\])*"|\[(\\.|[^\]\\])*\]`)
+	grammarIdentifier = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9-]*`)
+)
+
+// checkGrammar verifies that grammar is valid, has one rule per line and
+// defines every rule it references
+func checkGrammar(t *testing.T, grammar string) {
+	t.Helper()
+
+	if err := ValidateGrammar(grammar); err != nil {
+		t.Fatalf("%v in grammar:\n%s", err, grammar)
+	}
+
+	rules := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(grammar), "\n") {
+		name, body, ok := strings.Cut(line, " ::= ")
+		if !ok {
+			t.Fatalf("invalid rule %q", line)
+		}
+
+		if _, ok := rules[name]; ok {
+			t.Fatalf("duplicate rule %q", name)
+		}
+
+		rules[name] = body
+	}
+
+	if _, ok := rules["root"]; !ok {
+		t.Fatal("missing root rule")
+	}
+
+	for name, body := range rules {
+		for _, ref := range grammarIdentifier.FindAllString(grammarLiterals.ReplaceAllString(body, ""), -1) {
+			if _, ok := rules[ref]; !ok {
+				t.Errorf("rule %q refers to undefined rule %q", name, ref)
+			}
+		}
+	}
+}
+
+func TestSchemaToGrammar(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		want   []string
+	}{
+		{
+			name:   "required properties",
+			schema: `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}, "required": ["name", "age"]}`,
+			want: []string{
+				`root ::= "{" ws root-name-kv "," ws root-age-kv "}" ws`,
+				`root-name-kv ::= "\"name\"" ws ":" ws string`,
+				`root-age-kv ::= "\"age\"" ws ":" ws integer`,
+			},
+		},
+		{
+			name:   "optional properties",
+			schema: `{"type": "object", "properties": {"a": {"type": "string"}, "b": {"type": "number"}}, "required": ["a"]}`,
+			want: []string{
+				`root ::= "{" ws root-a-kv ( "," ws ( root-b-kv ) )? "}" ws`,
+			},
+		},
+		{
+			name:   "all optional properties",
+			schema: `{"properties": {"a": {"type": "string"}, "b": {"type": "boolean"}}}`,
+			want: []string{
+				`root ::= "{" ws ( root-a-kv root-a-rest | root-b-kv )? "}" ws`,
+				`root-a-rest ::= ( "," ws root-b-kv )?`,
+			},
+		},
+		{
+			name:   "enum",
+			schema: `{"type": "object", "properties": {"color": {"enum": ["red", "say \"hi\"", 1, null]}}, "required": ["color"]}`,
+			want: []string{
+				`root-color ::= ("\"red\"" | "\"say \\\"hi\\\"\"" | "1" | "null") ws`,
+			},
+		},
+		{
+			name:   "const",
+			schema: `{"const": "<b>"}`,
+			want: []string{
+				`root ::= "\"<b>\"" ws`,
+			},
+		},
+		{
+			name:   "array bounds",
+			schema: `{"type": "array", "items": {"type": "boolean"}, "minItems": 1, "maxItems": 3}`,
+			want: []string{
+				`root ::= "[" ws boolean ("," ws boolean ("," ws boolean )?)? "]" ws`,
+			},
+		},
+		{
+			name:   "array unbounded",
+			schema: `{"type": "array", "items": {"type": "number"}}`,
+			want: []string{
+				`root ::= "[" ws (number ("," ws number)*)? "]" ws`,
+			},
+		},
+		{
+			name:   "string length",
+			schema: `{"type": "string", "minLength": 1, "maxLength": 2}`,
+			want: []string{
+				`root ::= "\"" char (char )? "\"" ws`,
+			},
+		},
+		{
+			name:   "nullable",
+			schema: `{"type": ["string", "null"]}`,
+			want: []string{
+				`root ::= string | null`,
+			},
+		},
+		{
+			name:   "any of",
+			schema: `{"anyOf": [{"type": "integer"}, {"type": "object", "properties": {"x": {"type": "integer"}}, "required": ["x"]}]}`,
+			want: []string{
+				`root ::= integer | root-1`,
+				`root-1 ::= "{" ws root-1-x-kv "}" ws`,
+			},
+		},
+		{
+			name:   "map",
+			schema: `{"type": "object", "additionalProperties": {"type": "integer"}}`,
+			want: []string{
+				`root ::= "{" ws ( root-kv ("," ws root-kv)* )? "}" ws`,
+				`root-kv ::= string ":" ws integer`,
+			},
+		},
+		{
+			name:   "recursive reference",
+			schema: `{"$defs": {"node": {"type": "object", "properties": {"next": {"anyOf": [{"$ref": "#/$defs/node"}, {"type": "null"}]}}, "required": ["next"]}}, "$ref": "#/$defs/node"}`,
+			want: []string{
+				`root ::= ref-node`,
+				`ref-node ::= ref-node-def`,
+				`ref-node-def-next ::= ref-node | null`,
+			},
+		},
+		{
+			name:   "annotations",
+			schema: `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "Answer", "type": "object", "properties": {"ok": {"type": "boolean", "description": "whether it worked"}}, "required": ["ok"]}`,
+			want: []string{
+				`root ::= "{" ws root-ok-kv "}" ws`,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			grammar, err := SchemaToGrammar([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkGrammar(t, grammar)
+
+			lines := strings.Split(grammar, "\n")
+			for _, want := range tt.want {
+				found := false
+				for _, line := range lines {
+					if line == want {
+						found = true
+						break
+					}
+				}
+
+				if !found {
+					t.Errorf("expected rule %s in grammar:\n%s", want, grammar)
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaToGrammarErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"pattern", `{"type": "string", "pattern": "^a+$"}`, `unsupported schema keyword "pattern"`},
+		{"nested minimum", `{"type": "object", "properties": {"n": {"type": "integer", "minimum": 0}}}`, `unsupported schema keyword "minimum"`},
+		{"all of", `{"allOf": [{"type": "string"}]}`, `unsupported schema keyword "allOf"`},
+		{"remote reference", `{"$ref": "https://example.com/schema.json"}`, `unsupported schema reference`},
+		{"missing definition", `{"$ref": "#/$defs/missing"}`, `unsupported schema reference`},
+		{"undefined required", `{"type": "object", "properties": {"a": {}}, "required": ["b"]}`, `required property "b"`},
+		{"unknown type", `{"type": "date"}`, `unsupported schema type "date"`},
+		{"invalid bounds", `{"type": "array", "minItems": 3, "maxItems": 1}`, `invalid schema`},
+		{"large bounds", `{"type": "string", "maxLength": 100000}`, `bounds larger than`},
+		{"empty enum", `{"enum": []}`, `enum must not be empty`},
+		{"false", `{"type": "object", "properties": {"a": false}}`, `unsupported schema: false`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := SchemaToGrammar([]byte(tt.schema))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestFormatGrammar(t *testing.T) {
+	cases := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{format: ``, want: ""},
+		{format: `""`, want: ""},
+		{format: `null`, want: ""},
+		{format: `"json"`, want: jsonGrammar},
+		{format: `"xml"`, wantErr: true},
+		{format: `["json"]`, wantErr: true},
+	}
+
+	for _, tt := range cases {
+		grammar, err := FormatGrammar([]byte(tt.format))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("format %s: expected error", tt.format)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("format %s: %v", tt.format, err)
+		}
+
+		if grammar != tt.want {
+			t.Errorf("format %s: unexpected grammar %q", tt.format, grammar)
+		}
+	}
+
+	grammar, err := FormatGrammar([]byte(`{"type": "object", "properties": {"a": {"type": "string"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkGrammar(t, grammar)
+}
+
+func TestValidateGrammar(t *testing.T) {
+	valid := []string{
+		jsonGrammar,
+		`root ::= "yes" | "no"`,
+		"root ::= [a-z]+ (\",\" ws [a-z]+)*\r\nws ::= [ \\t]*\r\n",
+		"# select statements\nroot ::= \"SELECT \" column (\", \" column)* # columns\ncolumn ::= [a-zA-Z_]+",
+		`root ::= "\u00e9" [\x00-\x7F] . "ü"`,
+		`root ::= [0-9]{3} "-" [0-9]{2,} "-" [0-9]{0,4}`,
+		"root ::= (\n  \"a\" |\n  \"b\"\n)",
+		"root ::= ",
+	}
+
+	for _, grammar := range valid {
+		if err := ValidateGrammar(grammar); err != nil {
+			t.Errorf("grammar %q: %v", grammar, err)
+		}
+	}
+
+	cases := []struct {
+		grammar string
+		want    string
+	}{
+		{``, "missing root rule"},
+		{`answer ::= "yes"`, "missing root rule"},
+		{`root ::= answer`, `line 1: undefined rule "answer"`},
+		{"root ::= \"a\"\n\nitem ::= \"b\" missing", `line 3: undefined rule "missing"`},
+		{`root = "yes"`, "expected ::="},
+		{`root ::= "yes`, "unterminated literal"},
+		{`root ::= [a-z`, "unterminated character class"},
+		{`root ::= ("a" | "b"`, "unterminated group"},
+		{`root ::= * "a"`, `expected item before '*'`},
+		{`root ::= "a"{3,1}`, "invalid repetition"},
+		{`root ::= "a"{x}`, "expected number"},
+		{`root ::= "\q"`, "unknown escape"},
+		{`root ::= "\x4"`, "expected 2 hex digits"},
+		{`root ::= "a" ) "b"`, `unexpected ')'`},
+		{"root ::= \"a\"\n::= \"b\"", "expected rule name"},
+	}
+
+	for _, tt := range cases {
+		err := ValidateGrammar(tt.grammar)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("grammar %q: expected error containing %q, got %v", tt.grammar, tt.want, err)
+		}
+	}
+}
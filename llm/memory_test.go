@@ -9,6 +9,7 @@ import (
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/gpu"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -57,7 +58,7 @@ func TestEstimateGPULayers(t *testing.T) {
 	projectors := []string{}
 	opts := api.DefaultOptions()
 	t.Run("cpu", func(t *testing.T) {
-		estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate := EstimateGPULayers(gpus, ggml, nil, projectors, "", opts)
 		assert.Equal(t, 0, estimate.Layers)
 		assert.Equal(t, uint64(0), estimate.Graph)
 	})
@@ -108,7 +109,7 @@ func TestEstimateGPULayers(t *testing.T) {
 			gpus[1].FreeMemory += gpuMinimumMemory + layerSize + s.layer1*layerSize + 1
 			gpus[0].FreeMemory += max(graphFullOffload, graphPartialOffload)
 			gpus[1].FreeMemory += max(graphFullOffload, graphPartialOffload)
-			estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
+			estimate := EstimateGPULayers(gpus, ggml, nil, projectors, "", opts)
 			assert.Equal(t, int(s.expect0+s.expect1), estimate.Layers, "scenario %d: %v", i, s)
 			assert.Equal(t, fmt.Sprintf("%d,%d", s.expect0, s.expect1), estimate.TensorSplit, "scenario %d: %v", i, s)
 			var layerSums uint64
@@ -124,4 +125,40 @@ func TestEstimateGPULayers(t *testing.T) {
 			}
 		})
 	}
+	// the draft model goes to the first GPU along with the model
+	t.Run("draft", func(t *testing.T) {
+		gpus := []gpu.GpuInfo{{Library: "cuda", MinimumMemory: gpuMinimumMemory}}
+		gpus[0].FreeMemory = 8 * format.GibiByte
+		draftSize, draftLayers := draftMemoryRequirements(f.Name(), opts)
+		require.Equal(t, inputLayerCount+1, draftLayers)
+
+		base := EstimateGPULayers(gpus, ggml, nil, projectors, "", opts)
+		estimate := EstimateGPULayers(gpus, ggml, nil, projectors, f.Name(), opts)
+		assert.Equal(t, base.Layers, estimate.Layers)
+		assert.Equal(t, base.VRAMSize+draftSize, estimate.VRAMSize)
+		assert.Equal(t, draftLayers, estimate.DraftLayers)
+
+		// which doesn't fit next to a GPU already full with the model
+		gpus[0].FreeMemory = base.VRAMSize + 1
+		estimate = EstimateGPULayers(gpus, ggml, nil, projectors, f.Name(), opts)
+		assert.Less(t, estimate.Layers, base.Layers)
+
+		// and is only used without projectors
+		estimate = EstimateGPULayers(gpus, ggml, nil, []string{f.Name()}, f.Name(), opts)
+		assert.Equal(t, 0, estimate.DraftLayers)
+	})
+
+	// adapters are merged into the weights on the first GPU
+	t.Run("adapters", func(t *testing.T) {
+		gpus := []gpu.GpuInfo{{Library: "cuda", MinimumMemory: gpuMinimumMemory}}
+		gpus[0].FreeMemory = 8 * format.GibiByte
+
+		adapterSize := tensorMemoryRequirements(f.Name())
+		require.Positive(t, adapterSize)
+
+		base := EstimateGPULayers(gpus, ggml, nil, projectors, "", opts)
+		estimate := EstimateGPULayers(gpus, ggml, []Adapter{{Path: f.Name(), Scale: 1}, {Path: f.Name(), Scale: 0.5}}, projectors, "", opts)
+		assert.Equal(t, base.Layers, estimate.Layers)
+		assert.Equal(t, base.VRAMSize+2*adapterSize, estimate.VRAMSize)
+	})
 }
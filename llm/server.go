@@ -10,6 +10,7 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
@@ -17,6 +18,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -33,9 +35,10 @@ type LlamaServer interface {
 	Ping(ctx context.Context) error
 	WaitUntilRunning(ctx context.Context) error
 	Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error
-	Embedding(ctx context.Context, prompt string) ([]float64, error)
+	Embed(ctx context.Context, req EmbeddingRequest) (*EmbeddingResponse, error)
 	Tokenize(ctx context.Context, content string) ([]int, error)
 	Detokenize(ctx context.Context, tokens []int) (string, error)
+	Pieces(ctx context.Context, tokens []int) ([]string, error)
 	Close() error
 	EstimatedVRAM() uint64 // Total VRAM across all GPUs
 	EstimatedTotal() uint64
@@ -58,6 +61,21 @@ type llmServer struct {
 	loadProgress float32
 
 	sem *semaphore.Weighted
+
+	// slots is nil when the runner has a single slot
+	slots *slotCache
+
+	// adapters are applied to the runner per request on top of those it was
+	// loaded with. Requests hold one unit of adapterSem while they use them,
+	// and changing them takes all adapterSlots units, so a request waiting
+	// to change them can give up when its context is done.
+	adapterSem   *semaphore.Weighted
+	adapterSlots int64
+	adapters     []Adapter
+
+	// adaptersFailed is set when applying adapters failed, leaving the
+	// runner's weights unknown until adapters are applied again
+	adaptersFailed bool
 }
 
 func LoadModel(model string) (*GGML, error) {
@@ -75,9 +93,16 @@ func LoadModel(model string) (*GGML, error) {
 	return ggml, err
 }
 
+// Adapter is a LoRA adapter merged into the model's weights with the given
+// scale
+type Adapter struct {
+	Path  string
+	Scale float32
+}
+
 // NewLlamaServer will run a server for the given GPUs
 // The gpu list must be a single family.
-func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, projectors []string, opts api.Options) (LlamaServer, error) {
+func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters []Adapter, projectors []string, draft string, opts api.Options) (LlamaServer, error) {
 	var err error
 	var cpuRunner string
 	var estimate MemoryEstimate
@@ -89,7 +114,7 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 	}
 	if len(gpus) == 1 && gpus[0].Library == "cpu" {
 		cpuRunner = serverForCpu()
-		estimate = EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate = EstimateGPULayers(gpus, ggml, adapters, projectors, draft, opts)
 	} else {
 		if gpus[0].Library == "metal" {
 			memInfo, err := gpu.GetCPUMem()
@@ -100,7 +125,7 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 				slog.Debug("system memory", "total", format.HumanBytes2(systemMemory))
 			}
 		}
-		estimate = EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate = EstimateGPULayers(gpus, ggml, adapters, projectors, draft, opts)
 
 		switch {
 		case gpus[0].Library == "metal" && estimate.VRAMSize > systemMemory:
@@ -121,10 +146,6 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 	// Loop through potential servers
 	finalErr := errors.New("no suitable llama servers found")
 
-	if len(adapters) > 1 {
-		return nil, errors.New("ollama supports only one lora adapter, but multiple were provided")
-	}
-
 	availableServers := availableServers()
 	var servers []string
 	if cpuRunner != "" {
@@ -172,9 +193,12 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 		params = append(params, "--main-gpu", fmt.Sprintf("%d", opts.MainGPU))
 	}
 
-	if len(adapters) > 0 {
-		// TODO: applying multiple adapters is not supported by the llama.cpp server yet
-		params = append(params, "--lora", adapters[0])
+	for _, adapter := range adapters {
+		if adapter.Scale == 1 {
+			params = append(params, "--lora", adapter.Path)
+		} else {
+			params = append(params, "--lora-scaled", adapter.Path, strconv.FormatFloat(float64(adapter.Scale), 'g', -1, 32))
+		}
 	}
 
 	if len(projectors) > 0 {
@@ -182,6 +206,19 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 		params = append(params, "--mmproj", projectors[0])
 	}
 
+	if draft != "" && opts.NumDraft > 0 {
+		if len(projectors) > 0 {
+			slog.Warn("speculative decoding is not supported with multimodal models, ignoring draft model", "draft", draft)
+		} else {
+			params = append(params, "--model-draft", draft, "--draft", fmt.Sprintf("%d", opts.NumDraft))
+
+			// the draft model is offloaded along with the model
+			if opts.NumGPU >= 0 {
+				params = append(params, "--n-gpu-layers-draft", fmt.Sprintf("%d", estimate.DraftLayers))
+			}
+		}
+	}
+
 	if opts.NumThread > 0 {
 		params = append(params, "--threads", fmt.Sprintf("%d", opts.NumThread))
 	}
@@ -234,6 +271,11 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 
 	params = append(params, "--parallel", fmt.Sprintf("%d", numParallel))
 
+	// the runner only saves and restores snapshots by name in this directory
+	if envconfig.ModelsDir != "" {
+		params = append(params, "--slot-save-path", filepath.Join(envconfig.ModelsDir, "snapshots"))
+	}
+
 	if estimate.TensorSplit != "" {
 		params = append(params, "--tensor-split", estimate.TensorSplit)
 	}
@@ -308,15 +350,21 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 		}
 
 		s := &llmServer{
-			port:        port,
-			cmd:         exec.Command(server, finalParams...),
-			status:      NewStatusWriter(os.Stderr),
-			options:     opts,
-			estimate:    estimate,
-			sem:         semaphore.NewWeighted(int64(numParallel)),
-			totalLayers: ggml.KV().BlockCount() + 1,
-			gpus:        gpus,
-			done:        make(chan error, 1),
+			port:         port,
+			cmd:          exec.Command(server, finalParams...),
+			status:       NewStatusWriter(os.Stderr),
+			options:      opts,
+			estimate:     estimate,
+			sem:          semaphore.NewWeighted(int64(numParallel)),
+			adapterSem:   semaphore.NewWeighted(int64(numParallel)),
+			adapterSlots: int64(numParallel),
+			totalLayers:  ggml.KV().BlockCount() + 1,
+			gpus:         gpus,
+			done:         make(chan error, 1),
+		}
+
+		if numParallel > 1 {
+			s.slots = newSlotCache(numParallel)
 		}
 
 		s.cmd.Env = os.Environ()
@@ -401,7 +449,9 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 	return nil, finalErr
 }
 
-func projectorMemoryRequirements(filename string) uint64 {
+// tensorMemoryRequirements returns the memory needed by the tensors of a GGML
+// file, such as a projector or an adapter
+func tensorMemoryRequirements(filename string) uint64 {
 	file, err := os.Open(filename)
 	if err != nil {
 		return 0
@@ -421,6 +471,35 @@ func projectorMemoryRequirements(filename string) uint64 {
 	return mem
 }
 
+// draftMemoryRequirements returns the memory needed by a draft model and its
+// number of layers. The draft model keeps a KV cache as large as the model's.
+func draftMemoryRequirements(filename string, opts api.Options) (uint64, int) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	ggml, _, err := DecodeGGML(file)
+	if err != nil {
+		return 0, 0
+	}
+
+	var mem uint64
+	for _, layer := range ggml.Tensors().Layers() {
+		mem += layer.size()
+	}
+
+	if ggml.KV().HeadCount() > 0 {
+		mem += 2 * 2 * uint64(opts.NumCtx) * ggml.KV().BlockCount() * ggml.KV().EmbeddingLength() / ggml.KV().HeadCount() * ggml.KV().HeadCountKV()
+	}
+
+	_, graph := ggml.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, opts.NumBatch)))
+	mem += graph
+
+	return mem, int(ggml.KV().BlockCount()) + 1
+}
+
 type ServerStatus int
 
 const ( // iota is reset to 0
@@ -641,12 +720,39 @@ type ImageData struct {
 	ID   int    `json:"id"`
 }
 
+// errGrammarParse is the error the runner sends when a request's grammar
+// cannot be parsed
+const errGrammarParse = "failed to parse grammar"
+
+// completionProbability is the probability of a generated token and of the
+// most likely tokens at its position
+type completionProbability struct {
+	Content string  `json:"content"`
+	Prob    float64 `json:"prob"`
+	Probs   []struct {
+		TokStr string  `json:"tok_str"`
+		Prob   float64 `json:"prob"`
+	} `json:"probs"`
+}
+
 type completion struct {
 	Content      string `json:"content"`
 	Model        string `json:"model"`
 	Prompt       string `json:"prompt"`
 	Stop         bool   `json:"stop"`
 	StoppedLimit bool   `json:"stopped_limit"`
+	SlotID       int    `json:"slot_id"`
+
+	// TokensEvaluated is the number of tokens in the prompt, including those
+	// taken from the cache
+	TokensEvaluated int `json:"tokens_evaluated"`
+
+	// DraftN is the number of tokens drafted for speculative decoding and
+	// DraftNAccepted the number of those the model agreed with
+	DraftN         int `json:"draft_n"`
+	DraftNAccepted int `json:"draft_n_accepted"`
+
+	CompletionProbabilities []completionProbability `json:"completion_probabilities"`
 
 	Timings struct {
 		PredictedN  int     `json:"predicted_n"`
@@ -658,19 +764,69 @@ type completion struct {
 
 type CompletionRequest struct {
 	Prompt  string
-	Format  string
+	Format  json.RawMessage
 	Images  []ImageData
 	Options api.Options
+
+	// Logprobs requests the log probability of each generated token and
+	// TopLogprobs the number of most likely alternatives at each position.
+	Logprobs    bool
+	TopLogprobs int
+
+	// Snapshot is the name of a KV cache snapshot to restore before the
+	// prompt is evaluated, and SaveSnapshot a name to save the KV cache as
+	// once the request is done. Snapshots are files in the snapshots
+	// directory of the models directory. Neither is used for prompts with
+	// images.
+	Snapshot     string
+	SaveSnapshot string
+
+	// Adapters are applied to the model for this request, on top of those
+	// it was loaded with
+	Adapters []Adapter
 }
 
 type CompletionResponse struct {
 	Content            string
+	Logprobs           []api.Logprob
 	DoneReason         string
 	Done               bool
 	PromptEvalCount    int
 	PromptEvalDuration time.Duration
+	PromptCacheCount   int
 	EvalCount          int
 	EvalDuration       time.Duration
+	DraftCount         int
+	DraftAcceptedCount int
+}
+
+// logprob converts a probability into a log probability, flooring it so
+// that zero probabilities can still be encoded as JSON
+func logprob(p float64) float64 {
+	return math.Max(math.Log(p), -9999)
+}
+
+// logprobs returns the log probabilities of the tokens in c with up to top
+// of the most likely alternatives for each
+func (c *completion) logprobs(top int) []api.Logprob {
+	var logprobs []api.Logprob
+	for _, p := range c.CompletionProbabilities {
+		lp := api.Logprob{
+			TokenLogprob: api.TokenLogprob{Token: p.Content, Logprob: logprob(p.Prob)},
+		}
+
+		for i, alt := range p.Probs {
+			if i >= top {
+				break
+			}
+
+			lp.TopLogprobs = append(lp.TopLogprobs, api.TokenLogprob{Token: alt.TokStr, Logprob: logprob(alt.Prob)})
+		}
+
+		logprobs = append(logprobs, lp)
+	}
+
+	return logprobs
 }
 
 func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
@@ -711,6 +867,12 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		"cache_prompt":      true,
 	}
 
+	if req.Logprobs {
+		// the runner returns the sampled token along with its n_probs most
+		// likely alternatives, so ask for at least one
+		request["n_probs"] = max(req.TopLogprobs, 1)
+	}
+
 	// Make sure the server is ready
 	status, err := s.getServerStatusRetry(ctx)
 	if err != nil {
@@ -719,8 +881,47 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		return fmt.Errorf("unexpected server status: %s", status.ToString())
 	}
 
-	if req.Format == "json" {
-		request["grammar"] = jsonGrammar
+	grammar, err := FormatGrammar(req.Format)
+	if err != nil {
+		return err
+	}
+
+	release, err := s.useAdapters(ctx, req.Adapters)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// send the request to the slot that has cached the longest prefix of the
+	// prompt so it isn't evaluated again. The runner doesn't cache prompts
+	// with images.
+	slot, usedSlot := -1, -1
+	switch {
+	case len(req.Images) > 0:
+	case s.slots != nil:
+		if slot = s.slots.acquire(req.Prompt); slot >= 0 {
+			defer func() { s.slots.release(slot, usedSlot, req.Prompt) }()
+		}
+	default:
+		slot = 0
+	}
+
+	if slot >= 0 {
+		request["slot_id"] = slot
+
+		if req.Snapshot != "" {
+			if err := s.slotState(ctx, "restore", slot, req.Snapshot); err != nil {
+				// the prompt is evaluated in full instead
+				slog.Warn("failed to restore snapshot", "snapshot", req.Snapshot, "error", err)
+			}
+		}
+	}
+
+	switch {
+	case req.Options.Grammar != "":
+		request["grammar"] = req.Options.Grammar
+	case grammar != "":
+		request["grammar"] = grammar
 		if !strings.Contains(strings.ToLower(req.Prompt), "json") {
 			slog.Warn("Prompt does not specify that the LLM should response in JSON, but JSON format is expected. For best results specify that JSON is expected in the system prompt.")
 		}
@@ -776,6 +977,19 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 				continue
 			}
 
+			if evt, ok := bytes.CutPrefix(line, []byte("error: ")); ok {
+				var e completion
+				if err := json.Unmarshal(evt, &e); err != nil {
+					return fmt.Errorf("error unmarshalling llm error response: %v", err)
+				}
+
+				if e.Content == errGrammarParse {
+					return api.StatusError{StatusCode: http.StatusBadRequest, ErrorMessage: e.Content}
+				}
+
+				return fmt.Errorf("llm predict error: %s", e.Content)
+			}
+
 			evt, ok := bytes.CutPrefix(line, []byte("data: "))
 			if !ok {
 				return fmt.Errorf("error parsing llm response stream: %s", line)
@@ -800,10 +1014,20 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 				return ctx.Err()
 			}
 
-			if c.Content != "" {
-				fn(CompletionResponse{
-					Content: c.Content,
-				})
+			resp := CompletionResponse{
+				Content: c.Content,
+			}
+
+			// the final response repeats the probabilities of every token,
+			// so they are only taken from partial responses. Tokens that
+			// end partway through a character have no content but still
+			// have probabilities.
+			if req.Logprobs && !c.Stop {
+				resp.Logprobs = c.logprobs(req.TopLogprobs)
+			}
+
+			if resp.Content != "" || len(resp.Logprobs) > 0 {
+				fn(resp)
 			}
 
 			if c.Stop {
@@ -812,13 +1036,23 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 					doneReason = "length"
 				}
 
+				usedSlot = c.SlotID
+				if req.SaveSnapshot != "" && slot >= 0 {
+					if err := s.slotState(ctx, "save", usedSlot, req.SaveSnapshot); err != nil {
+						return fmt.Errorf("failed to save snapshot: %w", err)
+					}
+				}
+
 				fn(CompletionResponse{
 					Done:               true,
 					DoneReason:         doneReason,
 					PromptEvalCount:    c.Timings.PromptN,
 					PromptEvalDuration: parseDurationMs(c.Timings.PromptMS),
+					PromptCacheCount:   max(0, c.TokensEvaluated-c.Timings.PromptN),
 					EvalCount:          c.Timings.PredictedN,
 					EvalDuration:       parseDurationMs(c.Timings.PredictedMS),
+					DraftCount:         c.DraftN,
+					DraftAcceptedCount: c.DraftNAccepted,
 				})
 				return nil
 			}
@@ -841,15 +1075,135 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 	return nil
 }
 
+// slotState saves the KV cache of a slot to filename, or restores it from
+// filename, depending on action
+func (s *llmServer) slotState(ctx context.Context, action string, slot int, filename string) error {
+	return s.post(ctx, "/slots/"+action, map[string]any{"slot_id": slot, "filename": filename})
+}
+
+// post sends a request to the runner for which only errors are of interest
+func (s *llmServer) post(ctx context.Context, path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d%s", s.port, path), bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(body, &e); err != nil || e.Error == "" {
+			return fmt.Errorf("%s", body)
+		}
+
+		return errors.New(e.Error)
+	}
+
+	return nil
+}
+
+// useAdapters waits until the runner has adapters applied on top of those it
+// was loaded with, applying them once requests using other adapters are done.
+// The returned function must be called when the request is done with them.
+func (s *llmServer) useAdapters(ctx context.Context, adapters []Adapter) (func(), error) {
+	for {
+		if err := s.adapterSem.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+
+		if !s.adaptersFailed && slices.Equal(s.adapters, adapters) {
+			return func() { s.adapterSem.Release(1) }, nil
+		}
+		s.adapterSem.Release(1)
+
+		if err := s.adapterSem.Acquire(ctx, s.adapterSlots); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if s.adaptersFailed || !slices.Equal(s.adapters, adapters) {
+			err = s.setAdapters(ctx, adapters)
+		}
+		s.adapterSem.Release(s.adapterSlots)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// setAdapters applies adapters to the runner, replacing those applied by an
+// earlier request. The runner clears its prompt cache since it was computed
+// with other weights. The caller must hold all units of adapterSem.
+func (s *llmServer) setAdapters(ctx context.Context, adapters []Adapter) error {
+	type adapter struct {
+		Path  string  `json:"path"`
+		Scale float32 `json:"scale"`
+	}
+
+	body := struct {
+		Adapters []adapter `json:"adapters"`
+	}{Adapters: []adapter{}}
+	for _, a := range adapters {
+		body.Adapters = append(body.Adapters, adapter(a))
+	}
+
+	slog.Debug("applying adapters", "adapters", adapters)
+	if s.slots != nil {
+		s.slots.reset()
+	}
+
+	if err := s.post(ctx, "/adapters", body); err != nil {
+		s.adaptersFailed = true
+		return fmt.Errorf("failed to apply adapters: %w", err)
+	}
+
+	s.adapters = slices.Clone(adapters)
+	s.adaptersFailed = false
+	return nil
+}
+
 type EmbeddingRequest struct {
-	Content string `json:"content"`
+	// Content holds the inputs to embed, each either a string or a list of
+	// tokens
+	Content []any `json:"content"`
+
+	// Truncate, if set, has the runner check the length of each input,
+	// cutting inputs longer than its context down to fit if it is true and
+	// rejecting them otherwise. The runner shortens long inputs by its own
+	// rules if it isn't set.
+	Truncate *bool `json:"truncate,omitempty"`
 }
 
 type EmbeddingResponse struct {
-	Embedding []float64 `json:"embedding"`
+	Embedding [][]float64 `json:"embedding"`
+
+	// Tokens is the number of tokens evaluated for each input, when the
+	// request set Truncate
+	Tokens []int `json:"tokens,omitempty"`
 }
 
-func (s *llmServer) Embedding(ctx context.Context, prompt string) ([]float64, error) {
+// Embed computes the embeddings of all inputs in a single request. The runner
+// tokenizes the inputs, spreads them across its parallel slots and returns the
+// embeddings in input order.
+func (s *llmServer) Embed(ctx context.Context, r EmbeddingRequest) (*EmbeddingResponse, error) {
 	if err := s.sem.Acquire(ctx, 1); err != nil {
 		slog.Error("Failed to acquire semaphore", "error", err)
 		return nil, err
@@ -864,7 +1218,14 @@ func (s *llmServer) Embedding(ctx context.Context, prompt string) ([]float64, er
 		return nil, fmt.Errorf("unexpected server status: %s", status.ToString())
 	}
 
-	data, err := json.Marshal(TokenizeRequest{Content: prompt})
+	// embeddings come from the model without per request adapters
+	release, err := s.useAdapters(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	data, err := json.Marshal(r)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling embed data: %w", err)
 	}
@@ -888,15 +1249,29 @@ func (s *llmServer) Embedding(ctx context.Context, prompt string) ([]float64, er
 
 	if resp.StatusCode >= 400 {
 		log.Printf("llm encode error: %s", body)
-		return nil, fmt.Errorf("%s", body)
+
+		// the runner rejects inputs that are too long unless they are
+		// truncated
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(body, &e); err != nil || e.Error == "" || resp.StatusCode != http.StatusBadRequest {
+			return nil, fmt.Errorf("%s", body)
+		}
+
+		return nil, api.StatusError{StatusCode: resp.StatusCode, ErrorMessage: e.Error}
 	}
 
 	var embedding EmbeddingResponse
 	if err := json.Unmarshal(body, &embedding); err != nil {
-		return nil, fmt.Errorf("unmarshal tokenize response: %w", err)
+		return nil, fmt.Errorf("unmarshal embedding response: %w", err)
+	}
+
+	if len(embedding.Embedding) != len(r.Content) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(r.Content), len(embedding.Embedding))
 	}
 
-	return embedding.Embedding, nil
+	return &embedding, nil
 }
 
 type TokenizeRequest struct {
@@ -953,54 +1328,87 @@ func (s *llmServer) Tokenize(ctx context.Context, content string) ([]int, error)
 
 type DetokenizeRequest struct {
 	Tokens []int `json:"tokens"`
+
+	// Pieces requests the text of each token as well
+	Pieces bool `json:"pieces,omitempty"`
 }
 
 type DetokenizeResponse struct {
-	Content string `json:"content"`
+	Content string   `json:"content"`
+	Pieces  []string `json:"pieces,omitempty"`
 }
 
 func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error) {
+	decoded, err := s.detokenize(ctx, DetokenizeRequest{Tokens: tokens})
+	if err != nil {
+		return "", err
+	}
+
+	return decoded.Content, nil
+}
+
+// Pieces returns the text of each token, detokenizing them in one request to
+// the runner
+func (s *llmServer) Pieces(ctx context.Context, tokens []int) ([]string, error) {
+	decoded, err := s.detokenize(ctx, DetokenizeRequest{Tokens: tokens, Pieces: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return decoded.Pieces, nil
+}
+
+func (s *llmServer) detokenize(ctx context.Context, r DetokenizeRequest) (*DetokenizeResponse, error) {
 	// Make sure the server is ready
 	status, err := s.getServerStatus(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	} else if status != ServerStatusReady && status != ServerStatusNoSlotsAvailable {
-		return "", fmt.Errorf("unexpected server status: %s", status.ToString())
+		return nil, fmt.Errorf("unexpected server status: %s", status.ToString())
 	}
 
-	data, err := json.Marshal(DetokenizeRequest{Tokens: tokens})
+	data, err := json.Marshal(r)
 	if err != nil {
-		return "", fmt.Errorf("marshaling decode data: %w", err)
+		return nil, fmt.Errorf("marshaling decode data: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/detokenize", s.port), bytes.NewBuffer(data))
 	if err != nil {
-		return "", fmt.Errorf("decode request: %w", err)
+		return nil, fmt.Errorf("decode request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("do decode request: %w", err)
+		return nil, fmt.Errorf("do decode request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("read decode request: %w", err)
+		return nil, fmt.Errorf("read decode request: %w", err)
 	}
 
 	if resp.StatusCode >= 400 {
 		log.Printf("llm decode error: %s", body)
-		return "", fmt.Errorf("%s", body)
+
+		// the runner rejects tokens that aren't in the vocabulary
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(body, &e); err != nil || e.Error == "" {
+			return nil, fmt.Errorf("%s", body)
+		}
+
+		return nil, api.StatusError{StatusCode: resp.StatusCode, ErrorMessage: e.Error}
 	}
 
 	var decoded DetokenizeResponse
 	if err := json.Unmarshal(body, &decoded); err != nil {
-		return "", fmt.Errorf("unmarshal encode response: %w", err)
+		return nil, fmt.Errorf("unmarshal encode response: %w", err)
 	}
 
-	return decoded.Content, nil
+	return &decoded, nil
 }
 
 func (s *llmServer) Close() error {
@@ -0,0 +1,248 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestUseAdaptersCancelled(t *testing.T) {
+	s := &llmServer{adapterSem: semaphore.NewWeighted(2), adapterSlots: 2}
+
+	release, err := s.useAdapters(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a request for other adapters waits for the first request, and gives
+	// up when its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := s.useAdapters(ctx, []Adapter{{Path: "adapter.gguf", Scale: 1}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	release()
+
+	// nothing is left holding the adapters
+	if !s.adapterSem.TryAcquire(2) {
+		t.Error("expected adapters to be released")
+	}
+}
+
+// newTestRunner returns an llmServer talking to a fake runner serving mux
+func newTestRunner(t *testing.T, mux *http.ServeMux) *llmServer {
+	t.Helper()
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "ok"}`)
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &llmServer{port: port, cmd: &exec.Cmd{}}
+}
+
+func TestDetokenize(t *testing.T) {
+	vocab := []string{"a", "b", "c"}
+
+	var requests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/detokenize", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		var req DetokenizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var resp DetokenizeResponse
+		for _, token := range req.Tokens {
+			if token >= len(vocab) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error": "invalid token id %d"}`, token)
+				return
+			}
+
+			resp.Content += vocab[token]
+			if req.Pieces {
+				resp.Pieces = append(resp.Pieces, vocab[token])
+			}
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Error(err)
+		}
+	})
+
+	s := newTestRunner(t, mux)
+
+	content, err := s.Detokenize(context.Background(), []int{0, 1, 2})
+	if err != nil || content != "abc" {
+		t.Errorf("expected abc, got %q, %v", content, err)
+	}
+
+	requests = 0
+	pieces, err := s.Pieces(context.Background(), []int{2, 0, 1})
+	if err != nil || !slices.Equal(pieces, []string{"c", "a", "b"}) {
+		t.Errorf("expected pieces [c a b], got %q, %v", pieces, err)
+	}
+
+	if requests != 1 {
+		t.Errorf("expected pieces in a single request, got %d", requests)
+	}
+
+	// the runner's status and message are kept
+	_, err = s.Detokenize(context.Background(), []int{5})
+	var serr api.StatusError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected a status error, got %v", err)
+	}
+
+	if serr.StatusCode != http.StatusBadRequest || serr.ErrorMessage != "invalid token id 5" {
+		t.Errorf("unexpected error %d %q", serr.StatusCode, serr.ErrorMessage)
+	}
+}
+
+func TestCompletionLogprobs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/completion", func(w http.ResponseWriter, r *http.Request) {
+		// the first token is the start of a character split across two
+		// tokens, so it has no content of its own
+		for _, event := range []string{
+			`{"content": "", "completion_probabilities": [{"content": "\\xc3", "prob": 0.5, "probs": [{"tok_str": "\\xc3", "prob": 0.5}, {"tok_str": "e", "prob": 0.25}]}]}`,
+			`{"content": "é", "completion_probabilities": [{"content": "\\xa9", "prob": 1, "probs": [{"tok_str": "\\xa9", "prob": 1}]}]}`,
+			`{"content": "", "stop": true, "completion_probabilities": [{"content": "\\xc3", "prob": 0.5}, {"content": "\\xa9", "prob": 1}], "timings": {"predicted_n": 2}}`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	})
+
+	s := newTestRunner(t, mux)
+	s.sem = semaphore.NewWeighted(1)
+	s.adapterSem = semaphore.NewWeighted(1)
+	s.adapterSlots = 1
+
+	var resps []CompletionResponse
+	if err := s.Completion(context.Background(), CompletionRequest{Prompt: "café", Logprobs: true, TopLogprobs: 1}, func(r CompletionResponse) {
+		resps = append(resps, r)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resps) != 3 {
+		t.Fatalf("expected 3 responses, got %d: %+v", len(resps), resps)
+	}
+
+	want := [][]api.Logprob{
+		{{TokenLogprob: api.TokenLogprob{Token: "\\xc3", Logprob: math.Log(0.5)}, TopLogprobs: []api.TokenLogprob{{Token: "\\xc3", Logprob: math.Log(0.5)}}}},
+		{{TokenLogprob: api.TokenLogprob{Token: "\\xa9", Logprob: 0}, TopLogprobs: []api.TokenLogprob{{Token: "\\xa9", Logprob: 0}}}},
+		nil,
+	}
+
+	for i, r := range resps {
+		if !reflect.DeepEqual(r.Logprobs, want[i]) {
+			t.Errorf("response %d: expected logprobs %+v, got %+v", i, want[i], r.Logprobs)
+		}
+	}
+
+	if resps[0].Content != "" || resps[1].Content != "é" || !resps[2].Done {
+		t.Errorf("unexpected responses %+v", resps)
+	}
+}
+
+func TestCompletionLogprobsTop(t *testing.T) {
+	var c completion
+	if err := json.Unmarshal([]byte(`{"completion_probabilities": [
+		{"content": "a", "prob": 0.5, "probs": [{"tok_str": "a", "prob": 0.5}, {"tok_str": "b", "prob": 0.25}, {"tok_str": "c", "prob": 0}]}
+	]}`), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		top  int
+		want []api.TokenLogprob
+	}{
+		{0, nil},
+		{2, []api.TokenLogprob{{Token: "a", Logprob: math.Log(0.5)}, {Token: "b", Logprob: math.Log(0.25)}}},
+		// impossible tokens have a finite log probability
+		{5, []api.TokenLogprob{{Token: "a", Logprob: math.Log(0.5)}, {Token: "b", Logprob: math.Log(0.25)}, {Token: "c", Logprob: -9999}}},
+	}
+
+	for _, tt := range cases {
+		logprobs := c.logprobs(tt.top)
+		if len(logprobs) != 1 || logprobs[0].Token != "a" || logprobs[0].Logprob != math.Log(0.5) {
+			t.Fatalf("top %d: unexpected logprobs %+v", tt.top, logprobs)
+		}
+
+		if !reflect.DeepEqual(logprobs[0].TopLogprobs, tt.want) {
+			t.Errorf("top %d: expected %+v, got %+v", tt.top, tt.want, logprobs[0].TopLogprobs)
+		}
+	}
+}
+
+func TestCompletionSlots(t *testing.T) {
+	var slots []int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/completion", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SlotID int `json:"slot_id"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+
+		slots = append(slots, req.SlotID)
+		fmt.Fprintf(w, "data: {\"stop\": true, \"slot_id\": %d}\n\n", req.SlotID)
+	})
+	mux.HandleFunc("/tokenize", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected tokenize request to pick a slot")
+	})
+
+	s := newTestRunner(t, mux)
+	s.sem = semaphore.NewWeighted(2)
+	s.adapterSem = semaphore.NewWeighted(2)
+	s.adapterSlots = 2
+	s.slots = newSlotCache(2)
+
+	system := "You are a helpful assistant.\n"
+	for _, prompt := range []string{system + "Hi", "Why is the sky blue?", system + "Hello"} {
+		if err := s.Completion(context.Background(), CompletionRequest{Prompt: prompt}, func(CompletionResponse) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the prompt sharing the system prompt goes back to its slot
+	if !reflect.DeepEqual(slots, []int{0, 1, 0}) {
+		t.Errorf("expected slots [0 1 0], got %v", slots)
+	}
+}
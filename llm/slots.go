@@ -0,0 +1,93 @@
+package llm
+
+import "sync"
+
+// slotCache tracks the prompt cached by each parallel slot of the runner so
+// that a request can be sent to the slot that already holds the longest prefix
+// of its prompt, such as a system prompt resent on every turn of a chat.
+// Prompts are compared as text, so picking a slot doesn't need the runner to
+// tokenize them first.
+type slotCache struct {
+	mu    sync.Mutex
+	slots []cacheSlot
+
+	// releases counts releases to order slots by when they were last used
+	releases uint64
+}
+
+type cacheSlot struct {
+	prompt   string
+	busy     bool
+	lastUsed uint64
+}
+
+func newSlotCache(n int) *slotCache {
+	return &slotCache{slots: make([]cacheSlot, n)}
+}
+
+// acquire reserves the free slot whose cached prompt shares the longest prefix
+// with prompt, or the least recently used free slot if none share a prefix.
+// It returns -1 if every slot is busy.
+func (c *slotCache) acquire(prompt string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, best := -1, -1
+	for i, slot := range c.slots {
+		if slot.busy {
+			continue
+		}
+
+		n := commonPrefix(slot.prompt, prompt)
+		if n > best || (n == best && slot.lastUsed < c.slots[id].lastUsed) {
+			id, best = i, n
+		}
+	}
+
+	if id >= 0 {
+		c.slots[id].busy = true
+	}
+
+	return id
+}
+
+// release frees the slot reserved by acquire and records prompt as the prompt
+// cached by the slot the runner used, which may differ from the one reserved
+// if it was still busy in the runner
+func (c *slotCache) release(reserved, used int, prompt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if reserved >= 0 && reserved < len(c.slots) {
+		c.slots[reserved].busy = false
+	}
+
+	if used >= 0 && used < len(c.slots) {
+		c.slots[used].prompt = prompt
+		c.releases++
+		c.slots[used].lastUsed = c.releases
+	}
+}
+
+// reset forgets the prompts cached by all slots, such as after the runner
+// cleared its cache
+func (c *slotCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.slots {
+		c.slots[i].prompt = ""
+	}
+}
+
+// commonPrefix returns the length in bytes of the prefix a and b share
+func commonPrefix(a, b string) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,66 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlotCache(t *testing.T) {
+	c := newSlotCache(3)
+
+	system := "You are a helpful assistant.\n"
+
+	// empty slots are taken in order
+	require.Equal(t, 0, c.acquire(system+"Hi"))
+	require.Equal(t, 1, c.acquire("Why is the sky blue?"))
+	c.release(0, 0, system+"Hi")
+	c.release(1, 1, "Why is the sky blue?")
+
+	// a prompt sharing the system prompt goes back to its slot
+	require.Equal(t, 0, c.acquire(system+"Hello"))
+
+	// while that slot is busy, the longest remaining prefix wins
+	require.Equal(t, 1, c.acquire("Why is grass green?"))
+
+	// and then whatever is free
+	require.Equal(t, 2, c.acquire(system))
+	require.Equal(t, -1, c.acquire(system))
+
+	// the runner used another slot than the one reserved
+	c.release(0, 2, system+"Hello")
+	c.release(1, 1, "Why is grass green?")
+	c.release(2, 0, "Translate")
+
+	require.Equal(t, 0, c.acquire("Translate this"))
+	require.Equal(t, 2, c.acquire(system+"Hello again"))
+
+	// with no shared prefix the least recently used slot is taken
+	c.release(0, 0, "Translate")
+	c.release(2, 2, system)
+	require.Equal(t, 1, c.acquire("Summarize"))
+
+	// after a reset no slot holds a prefix
+	c.release(1, 1, "Summarize")
+	c.reset()
+	require.Equal(t, 0, c.acquire("Summarize"))
+}
+
+func TestCommonPrefix(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"ab", "", 0},
+		{"abc", "abc", 3},
+		{"abc", "ab", 2},
+		{"abc", "adc", 1},
+	}
+
+	for _, tt := range cases {
+		if got := commonPrefix(tt.a, tt.b); got != tt.want {
+			t.Errorf("commonPrefix(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -3,15 +3,21 @@ package openai
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
 )
 
 type Error struct {
@@ -26,20 +32,48 @@ type ErrorResponse struct {
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string `json:"id"`
+	Index    int    `json:"index"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+type LogprobContent struct {
+	TopLogprob
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+type ChoiceLogprobs struct {
+	Content []LogprobContent `json:"content"`
 }
 
 type Choice struct {
-	Index        int     `json:"index"`
-	Message      Message `json:"message"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Message      Message         `json:"message"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs"`
+	FinishReason *string         `json:"finish_reason"`
 }
 
 type ChunkChoice struct {
-	Index        int     `json:"index"`
-	Delta        Message `json:"delta"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Delta        Message         `json:"delta"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs"`
+	FinishReason *string         `json:"finish_reason"`
 }
 
 type Usage struct {
@@ -48,8 +82,15 @@ type Usage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
+type JsonSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict *bool           `json:"strict"`
+}
+
 type ResponseFormat struct {
-	Type string `json:"type"`
+	Type       string      `json:"type"`
+	JsonSchema *JsonSchema `json:"json_schema,omitempty"`
 }
 
 type ChatCompletionRequest struct {
@@ -64,6 +105,10 @@ type ChatCompletionRequest struct {
 	PresencePenalty  *float64        `json:"presence_penalty_penalty"`
 	TopP             *float64        `json:"top_p"`
 	ResponseFormat   *ResponseFormat `json:"response_format"`
+	Tools            []api.Tool      `json:"tools"`
+	ToolChoice       any             `json:"tool_choice"`
+	Logprobs         *bool           `json:"logprobs"`
+	TopLogprobs      *int            `json:"top_logprobs"`
 }
 
 type ChatCompletion struct {
@@ -76,6 +121,82 @@ type ChatCompletion struct {
 	Usage             Usage    `json:"usage,omitempty"`
 }
 
+type CompletionRequest struct {
+	Model            string   `json:"model"`
+	Prompt           string   `json:"prompt"`
+	Suffix           string   `json:"suffix"`
+	Echo             bool     `json:"echo"`
+	Stream           bool     `json:"stream"`
+	MaxTokens        *int     `json:"max_tokens"`
+	Seed             *int     `json:"seed"`
+	Stop             any      `json:"stop"`
+	Temperature      *float64 `json:"temperature"`
+	FrequencyPenalty *float64 `json:"frequency_penalty"`
+	PresencePenalty  *float64 `json:"presence_penalty"`
+	TopP             *float64 `json:"top_p"`
+}
+
+type CompleteChunkChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+type Completion struct {
+	Id                string                `json:"id"`
+	Object            string                `json:"object"`
+	Created           int64                 `json:"created"`
+	Model             string                `json:"model"`
+	SystemFingerprint string                `json:"system_fingerprint"`
+	Choices           []CompleteChunkChoice `json:"choices"`
+	Usage             Usage                 `json:"usage,omitempty"`
+}
+
+type CompletionChunk struct {
+	Id                string                `json:"id"`
+	Object            string                `json:"object"`
+	Created           int64                 `json:"created"`
+	Model             string                `json:"model"`
+	SystemFingerprint string                `json:"system_fingerprint"`
+	Choices           []CompleteChunkChoice `json:"choices"`
+}
+
+type EmbedRequest struct {
+	Input          any    `json:"input"`
+	Model          string `json:"model"`
+	EncodingFormat string `json:"encoding_format"`
+}
+
+type Embedding struct {
+	Object    string `json:"object"`
+	Embedding any    `json:"embedding"`
+	Index     int    `json:"index"`
+}
+
+type EmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+type EmbeddingList struct {
+	Object string         `json:"object"`
+	Data   []Embedding    `json:"data"`
+	Model  string         `json:"model"`
+	Usage  EmbeddingUsage `json:"usage,omitempty"`
+}
+
+type Model struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type ListCompletion struct {
+	Object string  `json:"object"`
+	Data   []Model `json:"data"`
+}
+
 type ChatCompletionChunk struct {
 	Id                string        `json:"id"`
 	Object            string        `json:"object"`
@@ -99,7 +220,81 @@ func NewError(code int, message string) ErrorResponse {
 	return ErrorResponse{Error{Type: etype, Message: message}}
 }
 
-func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
+func toolCallId() string {
+	const letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 8)
+	for i := range b {
+		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	}
+	return "call_" + string(b)
+}
+
+func toToolCalls(tc []api.ToolCall) ([]ToolCall, error) {
+	toolCalls := make([]ToolCall, len(tc))
+	for i, c := range tc {
+		toolCalls[i].ID = toolCallId()
+		toolCalls[i].Index = i
+		toolCalls[i].Type = "function"
+		toolCalls[i].Function.Name = c.Function.Name
+
+		args, err := json.Marshal(c.Function.Arguments)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal arguments of %s: %w", c.Function.Name, err)
+		}
+
+		toolCalls[i].Function.Arguments = string(args)
+	}
+	return toolCalls, nil
+}
+
+// finishReason returns the finish reason of a response that is done for
+// reason, which is tool_calls if it calls tools, or nil if it isn't done
+func finishReason(reason string, toolCalls []ToolCall) *string {
+	if len(toolCalls) > 0 {
+		reason = "tool_calls"
+	}
+	if len(reason) > 0 {
+		return &reason
+	}
+	return nil
+}
+
+func toTopLogprob(t api.TokenLogprob) TopLogprob {
+	bts := []byte(t.Token)
+	b := make([]int, len(bts))
+	for i, c := range bts {
+		b[i] = int(c)
+	}
+
+	return TopLogprob{Token: t.Token, Logprob: t.Logprob, Bytes: b}
+}
+
+func toLogprobs(lps []api.Logprob) *ChoiceLogprobs {
+	if len(lps) == 0 {
+		return nil
+	}
+
+	content := make([]LogprobContent, len(lps))
+	for i, lp := range lps {
+		content[i] = LogprobContent{
+			TopLogprob:  toTopLogprob(lp.TokenLogprob),
+			TopLogprobs: make([]TopLogprob, len(lp.TopLogprobs)),
+		}
+
+		for j, top := range lp.TopLogprobs {
+			content[i].TopLogprobs[j] = toTopLogprob(top)
+		}
+	}
+
+	return &ChoiceLogprobs{Content: content}
+}
+
+func toChatCompletion(id string, r api.ChatResponse) (ChatCompletion, error) {
+	toolCalls, err := toToolCalls(r.Message.ToolCalls)
+	if err != nil {
+		return ChatCompletion{}, err
+	}
+
 	return ChatCompletion{
 		Id:                id,
 		Object:            "chat.completion",
@@ -107,8 +302,51 @@ func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []Choice{{
-			Index:   0,
-			Message: Message{Role: r.Message.Role, Content: r.Message.Content},
+			Index:        0,
+			Message:      Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
+			Logprobs:     toLogprobs(r.Logprobs),
+			FinishReason: finishReason(r.DoneReason, toolCalls),
+		}},
+		Usage: Usage{
+			// TODO: ollama returns 0 for prompt eval if the prompt was cached, but openai returns the actual count
+			PromptTokens:     r.PromptEvalCount,
+			CompletionTokens: r.EvalCount,
+			TotalTokens:      r.PromptEvalCount + r.EvalCount,
+		},
+	}, nil
+}
+
+func toChunk(id string, r api.ChatResponse) (ChatCompletionChunk, error) {
+	toolCalls, err := toToolCalls(r.Message.ToolCalls)
+	if err != nil {
+		return ChatCompletionChunk{}, err
+	}
+
+	return ChatCompletionChunk{
+		Id:                id,
+		Object:            "chat.completion.chunk",
+		Created:           time.Now().Unix(),
+		Model:             r.Model,
+		SystemFingerprint: "fp_ollama",
+		Choices: []ChunkChoice{{
+			Index:        0,
+			Delta:        Message{Role: "assistant", Content: r.Message.Content, ToolCalls: toolCalls},
+			Logprobs:     toLogprobs(r.Logprobs),
+			FinishReason: finishReason(r.DoneReason, toolCalls),
+		}},
+	}, nil
+}
+
+func toCompletion(id string, r api.GenerateResponse) Completion {
+	return Completion{
+		Id:                id,
+		Object:            "text_completion",
+		Created:           r.CreatedAt.Unix(),
+		Model:             r.Model,
+		SystemFingerprint: "fp_ollama",
+		Choices: []CompleteChunkChoice{{
+			Text:  r.Response,
+			Index: 0,
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -125,16 +363,16 @@ func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 	}
 }
 
-func toChunk(id string, r api.ChatResponse) ChatCompletionChunk {
-	return ChatCompletionChunk{
+func toCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
+	return CompletionChunk{
 		Id:                id,
-		Object:            "chat.completion.chunk",
+		Object:            "text_completion",
 		Created:           time.Now().Unix(),
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
-		Choices: []ChunkChoice{{
+		Choices: []CompleteChunkChoice{{
+			Text:  r.Response,
 			Index: 0,
-			Delta: Message{Role: "assistant", Content: r.Message.Content},
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -145,12 +383,156 @@ func toChunk(id string, r api.ChatResponse) ChatCompletionChunk {
 	}
 }
 
-func fromRequest(r ChatCompletionRequest) api.ChatRequest {
+func toListCompletion(r api.ListResponse) ListCompletion {
+	data := make([]Model, 0, len(r.Models))
+	for _, m := range r.Models {
+		data = append(data, Model{
+			Id:      m.Name,
+			Object:  "model",
+			Created: m.ModifiedAt.Unix(),
+			OwnedBy: model.ParseName(m.Name).Namespace,
+		})
+	}
+
+	return ListCompletion{
+		Object: "list",
+		Data:   data,
+	}
+}
+
+func toModel(r api.ShowResponse, m string) Model {
+	return Model{
+		Id:      m,
+		Object:  "model",
+		Created: r.ModifiedAt.Unix(),
+		OwnedBy: model.ParseName(m).Namespace,
+	}
+}
+
+func toEmbeddingList(model, encodingFormat string, r api.EmbeddingResponse) EmbeddingList {
+	data := make([]Embedding, len(r.Embeddings))
+	for i, e := range r.Embeddings {
+		data[i] = Embedding{Object: "embedding", Index: i, Embedding: e}
+
+		if encodingFormat == "base64" {
+			// base64 encoded little endian float32s
+			b := make([]byte, 4*len(e))
+			for j, f := range e {
+				binary.LittleEndian.PutUint32(b[4*j:], math.Float32bits(float32(f)))
+			}
+			data[i].Embedding = base64.StdEncoding.EncodeToString(b)
+		}
+	}
+
+	return EmbeddingList{
+		Object: "list",
+		Data:   data,
+		Model:  model,
+		Usage: EmbeddingUsage{
+			PromptTokens: r.PromptEvalCount,
+			TotalTokens:  r.PromptEvalCount,
+		},
+	}
+}
+
+func fromRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 	var messages []api.Message
 	for _, msg := range r.Messages {
-		messages = append(messages, api.Message{Role: msg.Role, Content: msg.Content})
+		m := api.Message{Role: msg.Role, Content: msg.Content}
+		for _, tc := range msg.ToolCalls {
+			var args api.ToolCallFunctionArguments
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				return nil, fmt.Errorf("invalid arguments for tool call %q: %w", tc.Function.Name, err)
+			}
+
+			m.ToolCalls = append(m.ToolCalls, api.ToolCall{
+				Function: api.ToolCallFunction{Name: tc.Function.Name, Arguments: args},
+			})
+		}
+
+		messages = append(messages, m)
+	}
+
+	options := make(map[string]interface{})
+
+	switch stop := r.Stop.(type) {
+	case string:
+		options["stop"] = []string{stop}
+	case []interface{}:
+		var stops []string
+		for _, s := range stop {
+			if str, ok := s.(string); ok {
+				stops = append(stops, str)
+			}
+		}
+		options["stop"] = stops
+	}
+
+	if r.MaxTokens != nil {
+		options["num_predict"] = *r.MaxTokens
+	}
+
+	if r.Temperature != nil {
+		options["temperature"] = *r.Temperature * 2.0
+	} else {
+		options["temperature"] = 1.0
+	}
+
+	if r.Seed != nil {
+		options["seed"] = *r.Seed
+	}
+
+	if r.FrequencyPenalty != nil {
+		options["frequency_penalty"] = *r.FrequencyPenalty * 2.0
+	}
+
+	if r.PresencePenalty != nil {
+		options["presence_penalty"] = *r.PresencePenalty * 2.0
 	}
 
+	if r.TopP != nil {
+		options["top_p"] = *r.TopP
+	} else {
+		options["top_p"] = 1.0
+	}
+
+	var format json.RawMessage
+	if r.ResponseFormat != nil {
+		switch r.ResponseFormat.Type {
+		case "json_object":
+			format = json.RawMessage(`"json"`)
+		case "json_schema":
+			if r.ResponseFormat.JsonSchema == nil || len(r.ResponseFormat.JsonSchema.Schema) == 0 {
+				return nil, errors.New("response_format json_schema requires a schema")
+			}
+
+			format = r.ResponseFormat.JsonSchema.Schema
+		}
+	}
+
+	tools, err := fromToolChoice(r.Tools, r.ToolChoice)
+	if err != nil {
+		return nil, err
+	}
+
+	var topLogprobs int
+	if r.TopLogprobs != nil {
+		topLogprobs = *r.TopLogprobs
+	}
+
+	return &api.ChatRequest{
+		Model:       r.Model,
+		Messages:    messages,
+		Format:      format,
+		Options:     options,
+		Stream:      &r.Stream,
+		Tools:       tools,
+		Logprobs:    r.Logprobs != nil && *r.Logprobs,
+		TopLogprobs: topLogprobs,
+	}, nil
+}
+
+func fromCompleteRequest(r CompletionRequest) api.GenerateRequest {
 	options := make(map[string]interface{})
 
 	switch stop := r.Stop.(type) {
@@ -194,27 +576,80 @@ func fromRequest(r ChatCompletionRequest) api.ChatRequest {
 		options["top_p"] = 1.0
 	}
 
-	var format string
-	if r.ResponseFormat != nil && r.ResponseFormat.Type == "json_object" {
-		format = "json"
+	return api.GenerateRequest{
+		Model:   r.Model,
+		Prompt:  r.Prompt,
+		Suffix:  r.Suffix,
+		Options: options,
+		Stream:  &r.Stream,
 	}
+}
 
-	return api.ChatRequest{
-		Model:    r.Model,
-		Messages: messages,
-		Format:   format,
-		Options:  options,
-		Stream:   &r.Stream,
+// fromToolChoice narrows down the tools offered to the model according to
+// tool_choice: "none" hides all tools and a named function only offers that
+// function. "auto" and "required" offer every tool.
+func fromToolChoice(tools []api.Tool, choice any) (api.Tools, error) {
+	switch choice := choice.(type) {
+	case nil:
+		return tools, nil
+	case string:
+		switch choice {
+		case "none":
+			return nil, nil
+		case "auto", "required":
+			return tools, nil
+		default:
+			return nil, fmt.Errorf("invalid tool_choice %q", choice)
+		}
+	case map[string]any:
+		function, _ := choice["function"].(map[string]any)
+		name, _ := function["name"].(string)
+		for _, tool := range tools {
+			if tool.Function.Name == name {
+				return api.Tools{tool}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("tool_choice function %q not found in tools", name)
+	default:
+		return nil, fmt.Errorf("invalid tool_choice type %T", choice)
 	}
 }
 
-type writer struct {
+type BaseWriter struct {
+	gin.ResponseWriter
+}
+
+type ChatWriter struct {
 	stream bool
 	id     string
-	gin.ResponseWriter
+	BaseWriter
+}
+
+type CompleteWriter struct {
+	stream bool
+	id     string
+	// echo is the prompt to prepend to the completion, if requested
+	echo string
+	BaseWriter
+}
+
+type ListWriter struct {
+	BaseWriter
+}
+
+type RetrieveWriter struct {
+	BaseWriter
+	model string
+}
+
+type EmbedWriter struct {
+	BaseWriter
+	model          string
+	encodingFormat string
 }
 
-func (w *writer) writeError(code int, data []byte) (int, error) {
+func (w *BaseWriter) writeError(code int, data []byte) (int, error) {
 	var serr api.StatusError
 	err := json.Unmarshal(data, &serr)
 	if err != nil {
@@ -222,7 +657,36 @@ func (w *writer) writeError(code int, data []byte) (int, error) {
 	}
 
 	w.ResponseWriter.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w.ResponseWriter).Encode(NewError(http.StatusInternalServerError, serr.Error()))
+	err = json.NewEncoder(w.ResponseWriter).Encode(NewError(code, serr.Error()))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// streamError returns the message of an error that ends a response after it
+// has started streaming
+func streamError(data []byte) (string, bool) {
+	var r struct {
+		Error string `json:"error"`
+	}
+
+	if err := json.Unmarshal(data, &r); err != nil || r.Error == "" {
+		return "", false
+	}
+
+	return r.Error, true
+}
+
+func (w *BaseWriter) writeStreamError(message string, data []byte) (int, error) {
+	d, err := json.Marshal(NewError(http.StatusInternalServerError, message))
+	if err != nil {
+		return 0, err
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
+	_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("data: %s\n\n", d)))
 	if err != nil {
 		return 0, err
 	}
@@ -230,7 +694,7 @@ func (w *writer) writeError(code int, data []byte) (int, error) {
 	return len(data), nil
 }
 
-func (w *writer) writeResponse(data []byte) (int, error) {
+func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 	var chatResponse api.ChatResponse
 	err := json.Unmarshal(data, &chatResponse)
 	if err != nil {
@@ -239,7 +703,12 @@ func (w *writer) writeResponse(data []byte) (int, error) {
 
 	// chat chunk
 	if w.stream {
-		d, err := json.Marshal(toChunk(w.id, chatResponse))
+		chunk, err := toChunk(w.id, chatResponse)
+		if err != nil {
+			return 0, err
+		}
+
+		d, err := json.Marshal(chunk)
 		if err != nil {
 			return 0, err
 		}
@@ -261,8 +730,13 @@ func (w *writer) writeResponse(data []byte) (int, error) {
 	}
 
 	// chat completion
+	completion, err := toChatCompletion(w.id, chatResponse)
+	if err != nil {
+		return 0, err
+	}
+
 	w.ResponseWriter.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w.ResponseWriter).Encode(toChatCompletion(w.id, chatResponse))
+	err = json.NewEncoder(w.ResponseWriter).Encode(completion)
 	if err != nil {
 		return 0, err
 	}
@@ -270,16 +744,151 @@ func (w *writer) writeResponse(data []byte) (int, error) {
 	return len(data), nil
 }
 
-func (w *writer) Write(data []byte) (int, error) {
+func (w *ChatWriter) Write(data []byte) (int, error) {
 	code := w.ResponseWriter.Status()
 	if code != http.StatusOK {
 		return w.writeError(code, data)
 	}
 
+	if message, ok := streamError(data); ok && w.stream {
+		return w.writeStreamError(message, data)
+	}
+
 	return w.writeResponse(data)
 }
 
-func Middleware() gin.HandlerFunc {
+func (w *CompleteWriter) writeResponse(data []byte) (int, error) {
+	var generateResponse api.GenerateResponse
+	err := json.Unmarshal(data, &generateResponse)
+	if err != nil {
+		return 0, err
+	}
+
+	generateResponse.Response = w.echo + generateResponse.Response
+	w.echo = ""
+
+	// completion chunk
+	if w.stream {
+		d, err := json.Marshal(toCompleteChunk(w.id, generateResponse))
+		if err != nil {
+			return 0, err
+		}
+
+		w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
+		_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("data: %s\n\n", d)))
+		if err != nil {
+			return 0, err
+		}
+
+		if generateResponse.Done {
+			_, err = w.ResponseWriter.Write([]byte("data: [DONE]\n\n"))
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		return len(data), nil
+	}
+
+	// completion
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w.ResponseWriter).Encode(toCompletion(w.id, generateResponse))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *CompleteWriter) Write(data []byte) (int, error) {
+	code := w.ResponseWriter.Status()
+	if code != http.StatusOK {
+		return w.writeError(code, data)
+	}
+
+	if message, ok := streamError(data); ok && w.stream {
+		return w.writeStreamError(message, data)
+	}
+
+	return w.writeResponse(data)
+}
+
+func (w *ListWriter) writeResponse(data []byte) (int, error) {
+	var listResponse api.ListResponse
+	err := json.Unmarshal(data, &listResponse)
+	if err != nil {
+		return 0, err
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w.ResponseWriter).Encode(toListCompletion(listResponse))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *ListWriter) Write(data []byte) (int, error) {
+	code := w.ResponseWriter.Status()
+	if code != http.StatusOK {
+		return w.writeError(code, data)
+	}
+
+	return w.writeResponse(data)
+}
+
+func (w *RetrieveWriter) writeResponse(data []byte) (int, error) {
+	var showResponse api.ShowResponse
+	err := json.Unmarshal(data, &showResponse)
+	if err != nil {
+		return 0, err
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w.ResponseWriter).Encode(toModel(showResponse, w.model))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *RetrieveWriter) Write(data []byte) (int, error) {
+	code := w.ResponseWriter.Status()
+	if code != http.StatusOK {
+		return w.writeError(code, data)
+	}
+
+	return w.writeResponse(data)
+}
+
+func (w *EmbedWriter) writeResponse(data []byte) (int, error) {
+	var embedResponse api.EmbeddingResponse
+	err := json.Unmarshal(data, &embedResponse)
+	if err != nil {
+		return 0, err
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w.ResponseWriter).Encode(toEmbeddingList(w.model, w.encodingFormat, embedResponse))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *EmbedWriter) Write(data []byte) (int, error) {
+	code := w.ResponseWriter.Status()
+	if code != http.StatusOK {
+		return w.writeError(code, data)
+	}
+
+	return w.writeResponse(data)
+}
+
+func ChatMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req ChatCompletionRequest
 		err := c.ShouldBindJSON(&req)
@@ -293,18 +902,149 @@ func Middleware() gin.HandlerFunc {
 			return
 		}
 
+		chatReq, err := fromRequest(req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(chatReq); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+
+		c.Request.Body = io.NopCloser(&b)
+
+		w := &ChatWriter{
+			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
+			stream:     req.Stream,
+			id:         fmt.Sprintf("chatcmpl-%d", rand.Intn(999)),
+		}
+
+		c.Writer = w
+
+		c.Next()
+	}
+}
+
+func CompletionsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CompletionRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(fromCompleteRequest(req)); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+
+		c.Request.Body = io.NopCloser(&b)
+
+		w := &CompleteWriter{
+			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
+			stream:     req.Stream,
+			id:         fmt.Sprintf("cmpl-%d", rand.Intn(999)),
+		}
+
+		if req.Echo {
+			w.echo = req.Prompt
+		}
+
+		c.Writer = w
+
+		c.Next()
+	}
+}
+
+func ListMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &ListWriter{
+			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
+		}
+
+		c.Writer = w
+
+		c.Next()
+	}
+}
+
+func RetrieveMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// model names may contain slashes so the route uses a catch-all parameter
+		name := strings.TrimPrefix(c.Param("model"), "/")
+		if name == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "model is required"))
+			return
+		}
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(api.ShowRequest{Model: name}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+
+		c.Request.Body = io.NopCloser(&b)
+
+		w := &RetrieveWriter{
+			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
+			model:      name,
+		}
+
+		c.Writer = w
+
+		c.Next()
+	}
+}
+
+func EmbeddingsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req EmbedRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		switch req.EncodingFormat {
+		case "", "float", "base64":
+		default:
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, fmt.Sprintf("invalid encoding_format %q", req.EncodingFormat)))
+			return
+		}
+
+		switch input := req.Input.(type) {
+		case nil:
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "invalid input"))
+			return
+		case string:
+			if input == "" {
+				c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "invalid input"))
+				return
+			}
+		case []any:
+			if len(input) == 0 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "invalid input"))
+				return
+			}
+		}
+
 		var b bytes.Buffer
-		if err := json.NewEncoder(&b).Encode(fromRequest(req)); err != nil {
+		if err := json.NewEncoder(&b).Encode(api.EmbeddingRequest{Model: req.Model, Input: req.Input}); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
 			return
 		}
 
 		c.Request.Body = io.NopCloser(&b)
 
-		w := &writer{
-			ResponseWriter: c.Writer,
-			stream:         req.Stream,
-			id:             fmt.Sprintf("chatcmpl-%d", rand.Intn(999)),
+		w := &EmbedWriter{
+			BaseWriter:     BaseWriter{ResponseWriter: c.Writer},
+			model:          req.Model,
+			encodingFormat: req.EncodingFormat,
 		}
 
 		c.Writer = w
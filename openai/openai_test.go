@@ -0,0 +1,284 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestToChunkToolCalls(t *testing.T) {
+	chunk, err := toChunk("chatcmpl-1", api.ChatResponse{
+		Message: api.Message{
+			Role: "assistant",
+			ToolCalls: []api.ToolCall{
+				{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}},
+				{Function: api.ToolCallFunction{Name: "get_time", Arguments: api.ToolCallFunctionArguments{"timezone": "CET"}}},
+			},
+		},
+		Done:       true,
+		DoneReason: "stop",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	choice := chunk.Choices[0]
+	if choice.FinishReason == nil || *choice.FinishReason != "tool_calls" {
+		t.Errorf("expected finish reason tool_calls, got %v", choice.FinishReason)
+	}
+
+	if len(choice.Delta.ToolCalls) != 2 {
+		t.Fatalf("expected 2 tool calls, got %d", len(choice.Delta.ToolCalls))
+	}
+
+	for i, tc := range choice.Delta.ToolCalls {
+		if tc.Index != i {
+			t.Errorf("expected index %d, got %d", i, tc.Index)
+		}
+	}
+
+	if args := choice.Delta.ToolCalls[0].Function.Arguments; args != `{"city":"Paris"}` {
+		t.Errorf("unexpected arguments %s", args)
+	}
+}
+
+func TestToToolCallsError(t *testing.T) {
+	if _, err := toToolCalls([]api.ToolCall{
+		{Function: api.ToolCallFunction{Name: "divide", Arguments: api.ToolCallFunctionArguments{"x": math.Inf(1)}}},
+	}); err == nil {
+		t.Error("expected arguments that can't be marshalled to be an error")
+	}
+}
+
+func TestToEmbeddingList(t *testing.T) {
+	r := api.EmbeddingResponse{Embeddings: [][]float64{{0.5, -1}, {0.25, 2}}, PromptEvalCount: 7}
+
+	list := toEmbeddingList("all-minilm", "", r)
+	if list.Object != "list" || list.Model != "all-minilm" {
+		t.Errorf("unexpected list %s %s", list.Object, list.Model)
+	}
+
+	if list.Usage.PromptTokens != 7 || list.Usage.TotalTokens != 7 {
+		t.Errorf("unexpected usage %+v", list.Usage)
+	}
+
+	if len(list.Data) != 2 {
+		t.Fatalf("expected 2 embeddings, got %d", len(list.Data))
+	}
+
+	for i, e := range list.Data {
+		if e.Object != "embedding" || e.Index != i || !reflect.DeepEqual(e.Embedding, r.Embeddings[i]) {
+			t.Errorf("unexpected embedding %d: %+v", i, e)
+		}
+	}
+
+	// base64 is little endian float32s
+	list = toEmbeddingList("all-minilm", "base64", r)
+	for i, e := range list.Data {
+		b, err := base64.StdEncoding.DecodeString(e.Embedding.(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f32s := make([]float32, len(b)/4)
+		if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, f32s); err != nil {
+			t.Fatal(err)
+		}
+
+		for j, f := range f32s {
+			if f != float32(r.Embeddings[i][j]) {
+				t.Errorf("embedding %d[%d]: expected %g, got %g", i, j, r.Embeddings[i][j], f)
+			}
+		}
+	}
+}
+
+func TestEmbeddingsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var got api.EmbeddingRequest
+	r := gin.New()
+	r.POST("/v1/embeddings", EmbeddingsMiddleware(), func(c *gin.Context) {
+		got = api.EmbeddingRequest{}
+		if err := c.ShouldBindJSON(&got); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if got.Model == "missing" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "model 'missing' not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, api.EmbeddingResponse{Embeddings: [][]float64{{1, 0}}, PromptEvalCount: 3})
+	})
+
+	cases := []struct {
+		name  string
+		body  string
+		input any
+	}{
+		{"string", `{"model": "test", "input": "hello"}`, "hello"},
+		{"strings", `{"model": "test", "input": ["hello", "world"]}`, []any{"hello", "world"}},
+		{"tokens", `{"model": "test", "input": [1, 2, 3]}`, []any{float64(1), float64(2), float64(3)}},
+		{"token lists", `{"model": "test", "input": [[1, 2], [3]]}`, []any{[]any{float64(1), float64(2)}, []any{float64(3)}}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(tt.body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			if got.Model != "test" || !reflect.DeepEqual(got.Input, tt.input) {
+				t.Errorf("unexpected request %+v", got)
+			}
+
+			var list EmbeddingList
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatal(err)
+			}
+
+			if list.Object != "list" || len(list.Data) != 1 || list.Usage.PromptTokens != 3 {
+				t.Errorf("unexpected response %+v", list)
+			}
+		})
+	}
+
+	failures := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"missing input", `{"model": "test"}`, http.StatusBadRequest},
+		{"empty input", `{"model": "test", "input": ""}`, http.StatusBadRequest},
+		{"empty list", `{"model": "test", "input": []}`, http.StatusBadRequest},
+		{"encoding format", `{"model": "test", "input": "hello", "encoding_format": "int8"}`, http.StatusBadRequest},
+		{"handler error", `{"model": "missing", "input": "hello"}`, http.StatusNotFound},
+	}
+
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(tt.body)))
+			if w.Code != tt.code {
+				t.Fatalf("expected status %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Error.Message == "" {
+				t.Error("expected an error message")
+			}
+		})
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// the handler fails after it has started streaming
+	handler := func(chunk any) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Header("Content-Type", "application/x-ndjson")
+			for _, v := range []any{chunk, gin.H{"error": "request canceled"}} {
+				b, err := json.Marshal(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := c.Writer.Write(append(b, '\n')); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", ChatMiddleware(), handler(api.ChatResponse{Message: api.Message{Role: "assistant", Content: "The sky"}}))
+	r.POST("/v1/completions", CompletionsMiddleware(), handler(api.GenerateResponse{Response: "The sky"}))
+
+	cases := map[string]string{
+		"/v1/chat/completions": `{"model": "test", "stream": true, "messages": [{"role": "user", "content": "Why is the sky blue?"}]}`,
+		"/v1/completions":      `{"model": "test", "stream": true, "prompt": "Why is the sky blue?"}`,
+	}
+
+	for path, body := range cases {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+			if len(events) != 2 {
+				t.Fatalf("expected 2 events, got %q", events)
+			}
+
+			var resp ErrorResponse
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(events[1], "data: ")), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Error.Message != "request canceled" {
+				t.Errorf("expected error request canceled, got %q", resp.Error.Message)
+			}
+		})
+	}
+}
+
+func TestToLogprobs(t *testing.T) {
+	if lp := toLogprobs(nil); lp != nil {
+		t.Errorf("expected no logprobs, got %+v", lp)
+	}
+
+	lp := toLogprobs([]api.Logprob{
+		{TokenLogprob: api.TokenLogprob{Token: "é", Logprob: -0.5}, TopLogprobs: []api.TokenLogprob{{Token: "é", Logprob: -0.5}, {Token: "e", Logprob: -1}}},
+		{TokenLogprob: api.TokenLogprob{Token: "!", Logprob: 0}},
+	})
+
+	want := &ChoiceLogprobs{Content: []LogprobContent{
+		{
+			TopLogprob:  TopLogprob{Token: "é", Logprob: -0.5, Bytes: []int{0xc3, 0xa9}},
+			TopLogprobs: []TopLogprob{{Token: "é", Logprob: -0.5, Bytes: []int{0xc3, 0xa9}}, {Token: "e", Logprob: -1, Bytes: []int{'e'}}},
+		},
+		{
+			TopLogprob:  TopLogprob{Token: "!", Logprob: 0, Bytes: []int{'!'}},
+			TopLogprobs: []TopLogprob{},
+		},
+	}}
+
+	if !reflect.DeepEqual(lp, want) {
+		t.Errorf("expected %+v, got %+v", want, lp)
+	}
+
+	// a chunk without content keeps its logprobs
+	chunk, err := toChunk("chatcmpl-1", api.ChatResponse{
+		Message:  api.Message{Role: "assistant"},
+		Logprobs: []api.Logprob{{TokenLogprob: api.TokenLogprob{Token: "\xc3", Logprob: -0.5}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := chunk.Choices[0].Logprobs; l == nil || len(l.Content) != 1 || l.Content[0].Token != "\xc3" {
+		t.Errorf("expected chunk logprobs, got %+v", l)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 
@@ -36,7 +37,7 @@ func (c Command) String() string {
 	switch c.Name {
 	case "model":
 		fmt.Fprintf(&sb, "FROM %s", c.Args)
-	case "license", "template", "system", "adapter":
+	case "license", "template", "system", "adapter", "draft":
 		fmt.Fprintf(&sb, "%s %s", strings.ToUpper(c.Name), quote(c.Args))
 	case "message":
 		role, message, _ := strings.Cut(c.Args, ": ")
@@ -48,6 +49,29 @@ func (c Command) String() string {
 	return sb.String()
 }
 
+// ParseAdapter splits the arguments of an ADAPTER command into the adapter and
+// its scale, which is the last argument if it's a number and 1 otherwise
+func ParseAdapter(args string) (string, float32, error) {
+	args = strings.TrimSpace(args)
+
+	i := strings.LastIndexAny(args, " \t")
+	if i < 0 {
+		return args, 1, nil
+	}
+
+	scale, err := strconv.ParseFloat(args[i+1:], 32)
+	if err != nil {
+		// the adapter has spaces in its path
+		return args, 1, nil
+	}
+
+	if scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+		return "", 0, fmt.Errorf("%w: %s", errInvalidAdapterScale, args[i+1:])
+	}
+
+	return strings.TrimSpace(args[:i]), float32(scale), nil
+}
+
 type state int
 
 const (
@@ -60,9 +84,10 @@ const (
 )
 
 var (
-	errMissingFrom        = errors.New("no FROM line")
-	errInvalidMessageRole = errors.New("message role must be one of \"system\", \"user\", or \"assistant\"")
-	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"parameter\", or \"message\"")
+	errMissingFrom         = errors.New("no FROM line")
+	errInvalidMessageRole  = errors.New("message role must be one of \"system\", \"user\", or \"assistant\"")
+	errInvalidAdapterScale = errors.New("adapter scale must be a non-zero number")
+	errInvalidCommand      = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"draft\", \"parameter\", or \"message\"")
 )
 
 func ParseFile(r io.Reader) (*File, error) {
@@ -296,7 +321,7 @@ func isValidMessageRole(role string) bool {
 
 func isValidCommand(cmd string) bool {
 	switch strings.ToLower(cmd) {
-	case "from", "license", "template", "system", "adapter", "parameter", "message":
+	case "from", "license", "template", "system", "adapter", "draft", "parameter", "message":
 		return true
 	default:
 		return false
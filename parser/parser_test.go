@@ -19,6 +19,8 @@ func TestParseFileFile(t *testing.T) {
 	input := `
 FROM model1
 ADAPTER adapter1
+ADAPTER adapter2 0.5
+DRAFT draft1
 LICENSE MIT
 PARAMETER param1 value1
 PARAMETER param2 value2
@@ -33,6 +35,8 @@ TEMPLATE template1
 	expectedCommands := []Command{
 		{Name: "model", Args: "model1"},
 		{Name: "adapter", Args: "adapter1"},
+		{Name: "adapter", Args: "adapter2 0.5"},
+		{Name: "draft", Args: "draft1"},
 		{Name: "license", Args: "MIT"},
 		{Name: "param1", Args: "value1"},
 		{Name: "param2", Args: "value2"},
@@ -455,6 +459,7 @@ func TestParseFileFormatParseFile(t *testing.T) {
 		`
 FROM foo
 ADAPTER adapter1
+DRAFT foo:mini
 LICENSE MIT
 PARAMETER param1 value1
 PARAMETER param2 value2
@@ -576,3 +581,29 @@ func TestParseMultiByte(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAdapter(t *testing.T) {
+	cases := []struct {
+		args  string
+		name  string
+		scale float32
+		err   error
+	}{
+		{"./adapter.bin", "./adapter.bin", 1, nil},
+		{"./adapter.bin 0.5", "./adapter.bin", 0.5, nil},
+		{"@sha256:abc -1", "@sha256:abc", -1, nil},
+		{"./my adapter.bin", "./my adapter.bin", 1, nil},
+		{"./my adapter.bin 2", "./my adapter.bin", 2, nil},
+		{"./adapter.bin 0", "", 0, errInvalidAdapterScale},
+		{"./adapter.bin NaN", "", 0, errInvalidAdapterScale},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			name, scale, err := ParseAdapter(c.args)
+			require.ErrorIs(t, err, c.err)
+			assert.Equal(t, c.name, name)
+			assert.Equal(t, c.scale, scale)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+var errInvalidAdapter = errors.New("invalid adapter")
+
+// requestAdapters resolves the adapters named by a request for model. An
+// adapter is either the digest of an adapter blob or a model created from the
+// same base model, whose adapters are applied with their scales multiplied.
+func requestAdapters(model *Model, adapters []api.Adapter) ([]llm.Adapter, error) {
+	var resolved []llm.Adapter
+	for _, a := range adapters {
+		if a.Name == "" {
+			return nil, fmt.Errorf("%w: name is required", errInvalidAdapter)
+		}
+
+		scale := cmp.Or(a.Scale, 1)
+		if strings.HasPrefix(a.Name, "sha256:") {
+			path, err := adapterBlob(a.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			resolved = append(resolved, llm.Adapter{Path: path, Scale: scale})
+			continue
+		}
+
+		m, err := GetModel(a.Name)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("adapter '%s' not found: %w", a.Name, os.ErrNotExist)
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case len(m.Adapters) == 0:
+			return nil, fmt.Errorf("%w: model '%s' has no adapters", errInvalidAdapter, a.Name)
+		case m.ModelPath != model.ModelPath:
+			return nil, fmt.Errorf("%w: model '%s' has a different base model than '%s'", errInvalidAdapter, a.Name, model.ShortName)
+		}
+
+		for _, adapter := range m.Adapters {
+			resolved = append(resolved, llm.Adapter{Path: adapter.Path, Scale: adapter.Scale * scale})
+		}
+	}
+
+	return resolved, nil
+}
+
+// adapterBlob returns the path of the adapter blob with digest
+func adapterBlob(digest string) (string, error) {
+	path, err := GetBlobsPath(digest)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("adapter '%s' not found: %w", digest, os.ErrNotExist)
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	ggml, _, err := llm.DecodeGGML(f)
+	if err != nil || ggml.Name() != "ggla" {
+		return "", fmt.Errorf("%w: blob '%s' is not an adapter", errInvalidAdapter, digest)
+	}
+
+	return path, nil
+}
+
+// abortWithAdapterError writes the response for an error returned by
+// requestAdapters
+func abortWithAdapterError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidDigestFormat), errors.Is(err, errInvalidAdapter):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, os.ErrNotExist):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// adapterOptions checks that opts allow adapters to be applied to the runner,
+// which needs its weights loaded in memory rather than mapped from the model
+// file, and sets them to load it that way
+func adapterOptions(opts *api.Options) error {
+	if opts.UseMMap == api.TriStateTrue {
+		return fmt.Errorf("%w: adapters can't be applied with use_mmap", errInvalidAdapter)
+	}
+
+	opts.UseMMap = api.TriStateFalse
+	return nil
+}
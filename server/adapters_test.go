@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+func TestRequestAdapters(t *testing.T) {
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	envconfig.LoadConfig()
+	var s Server
+
+	bin := createBinFile(t, nil, nil)
+	for name, modelfile := range map[string]string{
+		"base":  fmt.Sprintf("FROM %s", bin),
+		"tuned": fmt.Sprintf("FROM %s\nADAPTER %s 0.5", bin, createAdapterFile(t, 8)),
+		"other": fmt.Sprintf("FROM %s\nADAPTER %s", createBinFile(t, map[string]any{"general.architecture": "llama"}, nil), createAdapterFile(t, 8)),
+	} {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{Name: name, Modelfile: modelfile, Stream: &stream})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code 200, actual %d", name, w.Code)
+		}
+	}
+
+	base, err := GetModel("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tuned, err := GetModel("tuned")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if adapters, err := requestAdapters(base, nil); adapters != nil || err != nil {
+		t.Errorf("expected no adapters, got %v, %v", adapters, err)
+	}
+
+	// a model's adapters are applied with their scales multiplied
+	adapters, err := requestAdapters(base, []api.Adapter{{Name: "tuned", Scale: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(adapters) != 1 || adapters[0].Path != tuned.Adapters[0].Path || adapters[0].Scale != 1 {
+		t.Errorf("unexpected adapters %v", adapters)
+	}
+
+	// a blob is applied by digest
+	digest := "sha256:" + strings.TrimPrefix(filepath.Base(tuned.Adapters[0].Path), "sha256-")
+	adapters, err = requestAdapters(base, []api.Adapter{{Name: digest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(adapters) != 1 || adapters[0].Path != tuned.Adapters[0].Path || adapters[0].Scale != 1 {
+		t.Errorf("unexpected adapters %v", adapters)
+	}
+
+	modelDigest := "sha256:" + strings.TrimPrefix(filepath.Base(base.ModelPath), "sha256-")
+	cases := []struct {
+		name string
+		want error
+	}{
+		{"", errInvalidAdapter},
+		{"missing", os.ErrNotExist},
+		{"base", errInvalidAdapter},
+		{"other", errInvalidAdapter},
+		{"sha256:1234", ErrInvalidDigestFormat},
+		{"sha256:" + strings.Repeat("0", 64), os.ErrNotExist},
+		{modelDigest, errInvalidAdapter},
+	}
+
+	for _, tt := range cases {
+		if _, err := requestAdapters(base, []api.Adapter{{Name: tt.name}}); !errors.Is(err, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/openai"
+)
+
+// apiPermission is a class of operations an API key can be allowed to perform
+type apiPermission string
+
+const (
+	// permissionInference allows running models
+	permissionInference apiPermission = "inference"
+
+	// permissionManage allows pulling, pushing, creating, copying and
+	// deleting models
+	permissionManage apiPermission = "manage"
+
+	// permissionMetrics allows scraping /metrics
+	permissionMetrics apiPermission = "metrics"
+)
+
+// routePermissions is the permission needed for each route under /api and
+// /v1 and for /metrics. Routes that only describe the available models need no permission
+// beyond a valid key. Routes that are not listed need permissionManage.
+var routePermissions = map[string]apiPermission{
+	"/api/generate":        permissionInference,
+	"/api/chat":            permissionInference,
+	"/api/embeddings":      permissionInference,
+	"/api/tokenize":        permissionInference,
+	"/api/detokenize":      permissionInference,
+	"/v1/chat/completions": permissionInference,
+	"/v1/completions":      permissionInference,
+	"/v1/embeddings":       permissionInference,
+	"/metrics":             permissionMetrics,
+
+	"/api/tags":         "",
+	"/api/show":         "",
+	"/api/ps":           "",
+	"/api/version":      "",
+	"/v1/models":        "",
+	"/v1/models/*model": "",
+}
+
+// apiKeyContextKey is the gin context key holding the name of the API key that
+// authenticated the request
+const apiKeyContextKey = "apiKey"
+
+type apiKey struct {
+	Name        string          `json:"name"`
+	Key         string          `json:"key"`
+	Permissions []apiPermission `json:"permissions"`
+}
+
+func (k *apiKey) allows(p apiPermission) bool {
+	return p == "" || slices.Contains(k.Permissions, p)
+}
+
+// apiKeys holds API keys by the SHA-256 digest of the key, so looking up a
+// key takes the same time however much of it matches
+type apiKeys map[[sha256.Size]byte]*apiKey
+
+// loadAPIKeys reads API keys from a JSON file of the form
+//
+//	{"keys": [{"name": "team", "key": "...", "permissions": ["inference"]}]}
+func loadAPIKeys(path string) (apiKeys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file struct {
+		Keys []*apiKey `json:"keys"`
+	}
+
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(file.Keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys defined", path)
+	}
+
+	keys := make(apiKeys)
+	names := make(map[string]bool)
+	for _, k := range file.Keys {
+		switch {
+		case k.Name == "":
+			return nil, fmt.Errorf("%s: key is missing a name", path)
+		case names[k.Name]:
+			return nil, fmt.Errorf("%s: duplicate key name %q", path, k.Name)
+		case k.Key == "":
+			return nil, fmt.Errorf("%s: key %q is empty", path, k.Name)
+		case len(k.Permissions) == 0:
+			return nil, fmt.Errorf("%s: key %q has no permissions", path, k.Name)
+		}
+
+		for _, p := range k.Permissions {
+			if p != permissionInference && p != permissionManage && p != permissionMetrics {
+				return nil, fmt.Errorf("%s: key %q has unknown permission %q", path, k.Name, p)
+			}
+		}
+
+		digest := sha256.Sum256([]byte(k.Key))
+		if _, ok := keys[digest]; ok {
+			return nil, fmt.Errorf("%s: key %q is the same as another key", path, k.Name)
+		}
+
+		names[k.Name] = true
+		keys[digest] = k
+	}
+
+	return keys, nil
+}
+
+// authMiddleware requires requests to /api and /v1 routes and to /metrics to
+// carry a bearer token matching one of keys with the permission the route needs. All
+// requests are allowed when there are no keys.
+func authMiddleware(keys apiKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if len(keys) == 0 || !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/v1/") && path != "/metrics" {
+			c.Next()
+			return
+		}
+
+		var key *apiKey
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			key = keys[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+		}
+
+		if key == nil {
+			c.Header("WWW-Authenticate", `Bearer realm="ollama"`)
+			abortWithError(c, http.StatusUnauthorized, errors.New("invalid or missing API key"))
+			return
+		}
+
+		permission, ok := routePermissions[c.FullPath()]
+		if !ok {
+			permission = permissionManage
+		}
+
+		if !key.allows(permission) {
+			abortWithError(c, http.StatusForbidden, fmt.Errorf("API key %q does not have the %s permission", key.Name, permission))
+			return
+		}
+
+		c.Set(apiKeyContextKey, key.Name)
+		c.Next()
+	}
+}
+
+// abortWithError aborts the request with an error in the format of the API
+// the request was made to
+func abortWithError(c *gin.Context, code int, err error) {
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/") {
+		c.AbortWithStatusJSON(code, openai.NewError(code, err.Error()))
+		return
+	}
+
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
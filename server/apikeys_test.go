@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func writeAPIKeys(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	keys, err := loadAPIKeys(writeAPIKeys(t, `{"keys": [
+		{"name": "team", "key": "team-secret", "permissions": ["inference"]},
+		{"name": "admin", "key": "admin-secret", "permissions": ["inference", "manage"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+
+	cases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"empty", `{"keys": []}`, "no keys defined"},
+		{"missing name", `{"keys": [{"key": "a", "permissions": ["inference"]}]}`, "missing a name"},
+		{"duplicate name", `{"keys": [{"name": "a", "key": "a", "permissions": ["inference"]}, {"name": "a", "key": "b", "permissions": ["inference"]}]}`, `duplicate key name "a"`},
+		{"duplicate key", `{"keys": [{"name": "a", "key": "a", "permissions": ["inference"]}, {"name": "b", "key": "a", "permissions": ["inference"]}]}`, `key "b" is the same as another key`},
+		{"empty key", `{"keys": [{"name": "a", "permissions": ["inference"]}]}`, `key "a" is empty`},
+		{"no permissions", `{"keys": [{"name": "a", "key": "a"}]}`, `key "a" has no permissions`},
+		{"unknown permission", `{"keys": [{"name": "a", "key": "a", "permissions": ["admin"]}]}`, `unknown permission "admin"`},
+		{"unknown field", `{"keys": [{"name": "a", "key": "a", "permission": ["inference"]}]}`, `unknown field "permission"`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadAPIKeys(writeAPIKeys(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys, err := loadAPIKeys(writeAPIKeys(t, `{"keys": [
+		{"name": "team", "key": "team-secret", "permissions": ["inference"]},
+		{"name": "admin", "key": "admin-secret", "permissions": ["inference", "manage"]},
+		{"name": "prometheus", "key": "metrics-secret", "permissions": ["metrics"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(authMiddleware(keys))
+	ok := func(c *gin.Context) { c.String(http.StatusOK, c.GetString(apiKeyContextKey)) }
+	r.HEAD("/", ok)
+	r.POST("/api/generate", ok)
+	r.POST("/v1/chat/completions", ok)
+	r.GET("/api/tags", ok)
+	r.DELETE("/api/delete", ok)
+	r.POST("/api/push", ok)
+	r.GET("/metrics", ok)
+
+	cases := []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{http.MethodHead, "/", "", http.StatusOK},
+		{http.MethodPost, "/api/generate", "", http.StatusUnauthorized},
+		{http.MethodPost, "/api/generate", "wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/api/generate", "team-secret", http.StatusOK},
+		{http.MethodPost, "/v1/chat/completions", "team-secret", http.StatusOK},
+		{http.MethodGet, "/api/tags", "team-secret", http.StatusOK},
+		{http.MethodDelete, "/api/delete", "team-secret", http.StatusForbidden},
+		{http.MethodPost, "/api/push", "team-secret", http.StatusForbidden},
+		{http.MethodDelete, "/api/delete", "admin-secret", http.StatusOK},
+		{http.MethodPost, "/api/push", "admin-secret", http.StatusOK},
+		{http.MethodGet, "/metrics", "", http.StatusUnauthorized},
+		{http.MethodGet, "/metrics", "team-secret", http.StatusForbidden},
+		{http.MethodGet, "/metrics", "admin-secret", http.StatusForbidden},
+		{http.MethodGet, "/metrics", "metrics-secret", http.StatusOK},
+		{http.MethodPost, "/api/generate", "metrics-secret", http.StatusForbidden},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.method+" "+tt.path+" "+tt.token, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Fatalf("expected status %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+
+			if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected WWW-Authenticate header")
+			}
+		})
+	}
+
+	t.Run("openai error", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status 401, got %d", w.Code)
+		}
+
+		var resp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Error.Message != "invalid or missing API key" {
+			t.Errorf("unexpected error %q", resp.Error.Message)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := gin.New()
+		r.Use(authMiddleware(nil))
+		r.DELETE("/api/delete", ok)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/delete", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+	})
+}
@@ -46,8 +46,9 @@ type Model struct {
 	ShortName      string
 	ModelPath      string
 	ParentModel    string
-	AdapterPaths   []string
+	Adapters       []llm.Adapter
 	ProjectorPaths []string
+	DraftPath      string
 	Template       string
 	System         string
 	License        []string
@@ -68,10 +69,15 @@ func (m *Model) String() string {
 		Args: m.ModelPath,
 	})
 
-	for _, adapter := range m.AdapterPaths {
+	for _, adapter := range m.Adapters {
+		args := adapter.Path
+		if adapter.Scale != 1 {
+			args = fmt.Sprintf("%s %g", adapter.Path, adapter.Scale)
+		}
+
 		modelfile.Commands = append(modelfile.Commands, parser.Command{
 			Name: "adapter",
-			Args: adapter,
+			Args: args,
 		})
 	}
 
@@ -82,6 +88,13 @@ func (m *Model) String() string {
 		})
 	}
 
+	if m.DraftPath != "" {
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "draft",
+			Args: m.DraftPath,
+		})
+	}
+
 	if m.Template != "" {
 		modelfile.Commands = append(modelfile.Commands, parser.Command{
 			Name: "template",
@@ -232,9 +245,11 @@ func GetModel(name string) (*Model, error) {
 			// TODO: remove this warning in a future version
 			slog.Info("WARNING: model contains embeddings, but embeddings in modelfiles have been deprecated and will be ignored.")
 		case "application/vnd.ollama.image.adapter":
-			model.AdapterPaths = append(model.AdapterPaths, filename)
+			model.Adapters = append(model.Adapters, llm.Adapter{Path: filename, Scale: cmp.Or(layer.Scale, 1)})
 		case "application/vnd.ollama.image.projector":
 			model.ProjectorPaths = append(model.ProjectorPaths, filename)
+		case "application/vnd.ollama.image.draft":
+			model.DraftPath = filename
 		case "application/vnd.ollama.image.template":
 			bts, err := os.ReadFile(filename)
 			if err != nil {
@@ -327,19 +342,30 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 	parameters := make(map[string]any)
 
 	var layers []*Layer
+
+	// base is the model that adapters are converted for
+	var base *llm.GGML
 	for _, c := range modelfile.Commands {
 		mediatype := fmt.Sprintf("application/vnd.ollama.image.%s", c.Name)
 
 		switch c.Name {
 		case "model", "adapter":
+			ref, scale := c.Args, float32(1)
+			if c.Name == "adapter" {
+				ref, scale, err = parser.ParseAdapter(c.Args)
+				if err != nil {
+					return err
+				}
+			}
+
 			var baseLayers []*layerGGML
-			if name := model.ParseName(c.Args); name.IsValid() {
+			if name := model.ParseName(ref); name.IsValid() {
 				baseLayers, err = parseFromModel(ctx, name, fn)
 				if err != nil {
 					return err
 				}
-			} else if strings.HasPrefix(c.Args, "@") {
-				digest := strings.TrimPrefix(c.Args, "@")
+			} else if strings.HasPrefix(ref, "@") {
+				digest := strings.TrimPrefix(ref, "@")
 				if ib, ok := intermediateBlobs[digest]; ok {
 					p, err := GetBlobsPath(ib)
 					if err != nil {
@@ -367,22 +393,26 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 				}
 				defer blob.Close()
 
-				baseLayers, err = parseFromFile(ctx, blob, digest, fn)
+				baseLayers, err = parseFromFile(ctx, blob, digest, base, fn)
 				if err != nil {
 					return err
 				}
-			} else if file, err := os.Open(realpath(modelFileDir, c.Args)); err == nil {
+			} else if file, err := os.Open(realpath(modelFileDir, ref)); err == nil {
 				defer file.Close()
 
-				baseLayers, err = parseFromFile(ctx, file, "", fn)
+				baseLayers, err = parseFromFile(ctx, file, "", base, fn)
 				if err != nil {
 					return err
 				}
 			} else {
-				return fmt.Errorf("invalid model reference: %s", c.Args)
+				return fmt.Errorf("invalid model reference: %s", ref)
 			}
 
 			for _, baseLayer := range baseLayers {
+				if baseLayer.MediaType == "application/vnd.ollama.image.adapter" && scale != 1 {
+					baseLayer.Scale = scale
+				}
+
 				if quantization != "" &&
 					baseLayer.MediaType == "application/vnd.ollama.image.model" &&
 					baseLayer.GGML != nil &&
@@ -414,7 +444,7 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 							return err
 						}
 
-						layers, err := parseFromFile(ctx, temp, "", fn)
+						layers, err := parseFromFile(ctx, temp, "", nil, fn)
 						if err != nil {
 							return err
 						}
@@ -428,6 +458,10 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 					}
 				}
 
+				if baseLayer.MediaType == "application/vnd.ollama.image.model" && baseLayer.GGML != nil {
+					base = baseLayer.GGML
+				}
+
 				if baseLayer.GGML != nil {
 					config.ModelFormat = cmp.Or(config.ModelFormat, baseLayer.GGML.Name())
 					config.ModelFamily = cmp.Or(config.ModelFamily, baseLayer.GGML.KV().Architecture())
@@ -438,6 +472,18 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 
 				layers = append(layers, baseLayer.Layer)
 			}
+		case "draft":
+			layer, err := parseDraft(ctx, modelFileDir, c.Args, fn)
+			if err != nil {
+				return err
+			}
+
+			// replace the draft model inherited from FROM
+			layers = slices.DeleteFunc(layers, func(layer *Layer) bool {
+				return layer.MediaType == mediatype
+			})
+
+			layers = append(layers, layer)
 		case "license", "template", "system":
 			if c.Name != "license" {
 				// replace
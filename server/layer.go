@@ -12,7 +12,11 @@ type Layer struct {
 	Digest    string `json:"digest"`
 	Size      int64  `json:"size"`
 	From      string `json:"from,omitempty"`
-	status    string
+
+	// Scale is the scale of an adapter, if other than 1
+	Scale float32 `json:"scale,omitempty"`
+
+	status string
 }
 
 func NewLayer(r io.Reader, mediatype string) (*Layer, error) {
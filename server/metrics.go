@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ollama/ollama/api"
+)
+
+var (
+	requestsTotal       = newCounter("ollama_requests_total", "Total number of HTTP requests.", "route", "model", "code")
+	requestDuration     = newHistogram("ollama_request_duration_seconds", "Time taken to serve HTTP requests.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}, "route", "model")
+	promptTokens        = newCounter("ollama_prompt_tokens_total", "Total number of prompt tokens evaluated.", "model")
+	promptCacheTokens   = newCounter("ollama_prompt_cache_tokens_total", "Total number of prompt tokens reused from the cache instead of evaluated.", "model")
+	completionTokens    = newCounter("ollama_completion_tokens_total", "Total number of tokens generated.", "model")
+	draftTokens         = newCounter("ollama_draft_tokens_total", "Total number of tokens predicted by draft models.", "model")
+	draftAcceptedTokens = newCounter("ollama_draft_accepted_tokens_total", "Total number of tokens predicted by draft models that were kept.", "model")
+	loadDuration        = newHistogram("ollama_model_load_duration_seconds", "Time taken to load models into memory.", []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}, "model")
+	runnerEvictions     = newCounter("ollama_runner_evictions_total", "Total number of runners unloaded before their keep alive expired.", "model", "reason")
+	pullBytes           = newCounter("ollama_pull_bytes_total", "Total number of bytes downloaded while pulling models.")
+	pushBytes           = newCounter("ollama_push_bytes_total", "Total number of bytes uploaded while pushing models.")
+)
+
+// metrics lists the metrics that are written by MetricsHandler, in order
+var metrics = []*metricVec{
+	requestsTotal,
+	requestDuration,
+	promptTokens,
+	promptCacheTokens,
+	completionTokens,
+	draftTokens,
+	draftAcceptedTokens,
+	loadDuration,
+	runnerEvictions,
+	pullBytes,
+	pushBytes,
+}
+
+// metricVec is a family of metrics of the same kind, with one series per
+// combination of label values, which is written in the Prometheus text
+// exposition format
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	// buckets are the upper bounds of histogram buckets
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels []string
+
+	// value is the value of a counter or gauge, or the sum of the
+	// observations of a histogram
+	value  float64
+	count  uint64
+	counts []uint64
+}
+
+func newMetricVec(name, help, kind string, buckets []float64, labels []string) *metricVec {
+	m := &metricVec{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*metricSeries)}
+	if len(labels) == 0 {
+		// metrics without labels have a single series which is always written
+		m.with(nil)
+	}
+
+	return m
+}
+
+func newCounter(name, help string, labels ...string) *metricVec {
+	return newMetricVec(name, help, "counter", nil, labels)
+}
+
+func newGauge(name, help string, labels ...string) *metricVec {
+	return newMetricVec(name, help, "gauge", nil, labels)
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricVec {
+	return newMetricVec(name, help, "histogram", buckets, labels)
+}
+
+// with returns the series for labels, creating it if needed. The caller must
+// hold m.mu.
+func (m *metricVec) with(labels []string) *metricSeries {
+	if len(labels) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", m.name, len(m.labels), len(labels)))
+	}
+
+	key := strings.Join(labels, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labels: labels, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+
+	return s
+}
+
+// add adds v to a counter
+func (m *metricVec) add(v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.with(labels).value += v
+}
+
+// set sets the value of a gauge
+func (m *metricVec) set(v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.with(labels).value = v
+}
+
+// observe records v in a histogram
+func (m *metricVec) observe(v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.with(labels)
+	s.value += v
+	s.count++
+	if i, _ := slices.BinarySearch(m.buckets, v); i < len(m.buckets) {
+		s.counts[i]++
+	}
+}
+
+func (m *metricVec) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind != "histogram" {
+			fmt.Fprintf(&b, "%s%s %s\n", m.name, formatLabels(m.labels, s.labels), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, formatLabels(append(slices.Clone(m.labels), "le"), append(slices.Clone(s.labels), formatFloat(le))), cumulative)
+		}
+
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, formatLabels(append(slices.Clone(m.labels), "le"), append(slices.Clone(s.labels), "+Inf")), s.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labels), formatFloat(s.value))
+		fmt.Fprintf(&b, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labels), s.count)
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// recordTokens adds the token counts of a finished completion to the token
+// metrics
+func recordTokens(model string, m api.Metrics) {
+	promptTokens.add(float64(m.PromptEvalCount), model)
+	promptCacheTokens.add(float64(m.PromptCacheCount), model)
+	completionTokens.add(float64(m.EvalCount), model)
+
+	if m.DraftCount > 0 {
+		draftTokens.add(float64(m.DraftCount), model)
+		draftAcceptedTokens.add(float64(m.DraftAcceptedCount), model)
+	}
+}
+
+// modelLabelContextKey holds the installed model a handler resolved the
+// request to, which labels the request's metrics
+const modelLabelContextKey = "modelLabel"
+
+// requestModelContextKey holds the model named in the request body once it's
+// been decoded
+const requestModelContextKey = "requestModel"
+
+// metricsMiddleware records the count and duration of requests by route and
+// the installed model they're for
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" || route == "/metrics" {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+
+		c.Next()
+
+		// handlers only label requests with models they find installed, or
+		// create or pull, so that clients can't add a series for every name
+		// they make up
+		name := c.GetString(modelLabelContextKey)
+		requestsTotal.add(1, route, name, strconv.Itoa(c.Writer.Status()))
+		requestDuration.observe(time.Since(start).Seconds(), route, name)
+	}
+}
+
+// setModelLabel labels the metrics of the request with name, which must name
+// an installed model
+func setModelLabel(c *gin.Context, name string) {
+	c.Set(modelLabelContextKey, ParseModelPath(name).GetShortTagname())
+}
+
+// requestModel returns the model named in a JSON request body. The body is
+// only decoded the first time.
+func requestModel(c *gin.Context) string {
+	if name, ok := c.Get(requestModelContextKey); ok {
+		return name.(string)
+	}
+
+	name := decodeRequestModel(c)
+	c.Set(requestModelContextKey, name)
+	return name
+}
+
+// decodeRequestModel decodes the model named in a JSON request body and
+// restores the body so it can be read again by handlers
+func decodeRequestModel(c *gin.Context) string {
+	switch {
+	case c.Request.Body == nil,
+		c.Request.Method == http.MethodGet,
+		c.Request.Method == http.MethodHead,
+		strings.HasPrefix(c.FullPath(), "/api/blobs/"):
+		return ""
+	}
+
+	bts, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(bts))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+
+	if err := json.Unmarshal(bts, &req); err != nil {
+		return ""
+	}
+
+	name := cmp.Or(req.Model, req.Name)
+	if name == "" {
+		return ""
+	}
+
+	return ParseModelPath(name).GetShortTagname()
+}
+
+func (s *Server) MetricsHandler(c *gin.Context) {
+	gauges := []*metricVec{
+		newGauge("ollama_queued_requests", "Number of requests waiting to be scheduled."),
+		newGauge("ollama_loaded_runners", "Number of runners loaded in memory."),
+		newGauge("ollama_runner_vram_bytes", "Estimated VRAM used by each loaded runner.", "digest"),
+	}
+
+	if s.sched != nil {
+		gauges[0].set(float64(s.sched.queued()))
+
+		s.sched.loadedMu.Lock()
+		gauges[1].set(float64(len(s.sched.loaded)))
+		// runners are labelled by the blob they load since models that
+		// share it share a runner
+		for _, runner := range s.sched.loaded {
+			gauges[2].set(float64(runner.estimatedVRAM), filepath.Base(runner.modelPath))
+		}
+		s.sched.loadedMu.Unlock()
+	}
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	for _, m := range append(slices.Clone(metrics), gauges...) {
+		if err := m.write(c.Writer); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+func TestMetricVecWrite(t *testing.T) {
+	counter := newCounter("test_total", "A counter.", "model")
+	counter.add(1, "b")
+	counter.add(2, `a"b`)
+	counter.add(0.5, "b")
+
+	histogram := newHistogram("test_seconds", "A histogram.", []float64{1, 5}, "model")
+	histogram.observe(0.5, "a")
+	histogram.observe(1, "a")
+	histogram.observe(3, "a")
+	histogram.observe(10, "a")
+
+	gauge := newGauge("test_gauge", "A gauge.")
+	gauge.set(3)
+	gauge.set(7)
+
+	var b bytes.Buffer
+	for _, m := range []*metricVec{counter, histogram, gauge} {
+		if err := m.write(&b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{model="a\"b"} 2
+test_total{model="b"} 1.5
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{model="a",le="1"} 2
+test_seconds_bucket{model="a",le="5"} 3
+test_seconds_bucket{model="a",le="+Inf"} 4
+test_seconds_sum{model="a"} 14.5
+test_seconds_count{model="a"} 4
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 7
+`
+
+	if b.String() != expect {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", b.String(), expect)
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	s := Server{sched: InitScheduler(context.Background())}
+	s.sched.loaded["/models/blobs/sha256-1234"] = &runnerRef{modelPath: "/models/blobs/sha256-1234", estimatedVRAM: 1024}
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "metrics-test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	r := gin.New()
+	r.Use(metricsMiddleware())
+	r.POST("/api/show", s.ShowModelHandler)
+	r.GET("/metrics", s.MetricsHandler)
+
+	for name, code := range map[string]int{"metrics-test": http.StatusOK, "not-installed": http.StatusNotFound} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/show", strings.NewReader(fmt.Sprintf(`{"name": %q}`, name))))
+		if w.Code != code {
+			t.Fatalf("expected status %d for %s, got %d", code, name, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	body, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`ollama_requests_total{route="/api/show",model="metrics-test:latest",code="200"} 1`,
+		`ollama_request_duration_seconds_count{route="/api/show",model="metrics-test:latest"} 1`,
+		`ollama_requests_total{route="/api/show",model="",code="404"} 1`,
+		`ollama_queued_requests 0`,
+		`ollama_loaded_runners 1`,
+		`ollama_runner_vram_bytes{digest="sha256-1234"} 1024`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected %q in metrics:\n%s", line, body)
+		}
+	}
+
+	if strings.Contains(string(body), `route="/metrics"`) {
+		t.Errorf("expected metrics requests not to be recorded:\n%s", body)
+	}
+
+	if strings.Contains(string(body), "not-installed") {
+		t.Errorf("expected models that aren't installed not to be recorded:\n%s", body)
+	}
+}
+
+func TestRequestModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model": "llama3", "messages": []}`))
+
+	if name := requestModel(c); name != "llama3:latest" {
+		t.Fatalf("expected llama3:latest, got %q", name)
+	}
+
+	// the body is restored for the handler
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != `{"model": "llama3", "messages": []}` {
+		t.Errorf("expected the body to be restored, got %s", body)
+	}
+
+	// and only decoded once
+	c.Request.Body = io.NopCloser(strings.NewReader(`{"model": "other"}`))
+	if name := requestModel(c); name != "llama3:latest" {
+		t.Errorf("expected llama3:latest, got %q", name)
+	}
+}
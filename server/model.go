@@ -42,12 +42,14 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 		return nil, err
 	}
 
-	for _, layer := range m.Layers {
-		layer, err := NewLayerFromLayer(layer.Digest, layer.MediaType, name.DisplayShortest())
+	for _, l := range m.Layers {
+		layer, err := NewLayerFromLayer(l.Digest, l.MediaType, name.DisplayShortest())
 		if err != nil {
 			return nil, err
 		}
 
+		layer.Scale = l.Scale
+
 		switch layer.MediaType {
 		case "application/vnd.ollama.image.model",
 			"application/vnd.ollama.image.projector",
@@ -77,7 +79,7 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 	return layers, nil
 }
 
-func parseFromZipFile(_ context.Context, file *os.File, digest string, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
+func parseFromZipFile(_ context.Context, file *os.File, digest string, base *llm.GGML, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
 	stat, err := file.Stat()
 	if err != nil {
 		return nil, err
@@ -122,6 +124,10 @@ func parseFromZipFile(_ context.Context, file *os.File, digest string, fn func(a
 		}
 	}
 
+	if convert.IsAdapter(tempdir) {
+		return parseAdapterFromDir(tempdir, base, fn)
+	}
+
 	mf, err := convert.GetModelFormat(tempdir)
 	if err != nil {
 		return nil, err
@@ -187,7 +193,9 @@ func parseFromZipFile(_ context.Context, file *os.File, digest string, fn func(a
 	return detectChatTemplate(layers)
 }
 
-func parseFromFile(ctx context.Context, file *os.File, digest string, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
+// parseFromFile returns the layers of a model file. base is the model that an
+// adapter in the file is converted for, if any.
+func parseFromFile(ctx context.Context, file *os.File, digest string, base *llm.GGML, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
 	sr := io.NewSectionReader(file, 0, 512)
 	contentType, err := detectContentType(sr)
 	if err != nil {
@@ -198,7 +206,7 @@ func parseFromFile(ctx context.Context, file *os.File, digest string, fn func(ap
 	case "gguf", "ggla":
 		// noop
 	case "application/zip":
-		return parseFromZipFile(ctx, file, digest, fn)
+		return parseFromZipFile(ctx, file, digest, base, fn)
 	default:
 		return nil, fmt.Errorf("unsupported content type: %s", contentType)
 	}
@@ -236,6 +244,89 @@ func parseFromFile(ctx context.Context, file *os.File, digest string, fn func(ap
 	return detectChatTemplate(layers)
 }
 
+// parseAdapterFromDir converts the PEFT adapter in dir to an adapter layer for
+// the base model
+func parseAdapterFromDir(dir string, base *llm.GGML, fn func(api.ProgressResponse)) ([]*layerGGML, error) {
+	adapter, err := convert.NewAdapter(dir, base)
+	if err != nil {
+		return nil, err
+	}
+
+	fn(api.ProgressResponse{Status: "processing adapter tensors"})
+	if err := adapter.GetTensors(); err != nil {
+		return nil, err
+	}
+
+	fn(api.ProgressResponse{Status: "converting adapter"})
+	temp, err := os.CreateTemp(dir, "ggla")
+	if err != nil {
+		return nil, err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := adapter.WriteGGLA(temp); err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	layer, err := NewLayer(temp, "application/vnd.ollama.image.adapter")
+	if err != nil {
+		return nil, err
+	}
+
+	bin, err := layer.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer bin.Close()
+
+	ggml, _, err := llm.DecodeGGML(bin)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*layerGGML{{layer, ggml}}, nil
+}
+
+// parseDraft returns the layer of the draft model named by a DRAFT command,
+// which may be a model or a GGUF file
+func parseDraft(ctx context.Context, modelFileDir, ref string, fn func(api.ProgressResponse)) (*Layer, error) {
+	var layers []*layerGGML
+	var err error
+	if name := model.ParseName(ref); name.IsValid() {
+		layers, err = parseFromModel(ctx, name, fn)
+	} else if file, ferr := os.Open(realpath(modelFileDir, ref)); ferr == nil {
+		defer file.Close()
+		layers, err = parseFromFile(ctx, file, "", nil, fn)
+	} else {
+		return nil, fmt.Errorf("invalid draft model reference: %s", ref)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var draft *layerGGML
+	for _, layer := range layers {
+		switch layer.MediaType {
+		case "application/vnd.ollama.image.model":
+			draft = layer
+		case "application/vnd.ollama.image.adapter", "application/vnd.ollama.image.projector":
+			return nil, fmt.Errorf("draft model %s can't have adapters or projectors", ref)
+		}
+	}
+
+	if draft == nil || draft.GGML == nil || draft.GGML.Name() != "gguf" {
+		return nil, fmt.Errorf("draft model %s is not a GGUF model", ref)
+	}
+
+	return NewLayerFromLayer(draft.Digest, "application/vnd.ollama.image.draft", draft.From)
+}
+
 func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
 	for _, layer := range layers {
 		if s := layer.GGML.KV().ChatTemplate(); s != "" {
@@ -167,3 +167,20 @@ func GetBlobsPath(digest string) (string, error) {
 
 	return path, nil
 }
+
+// GetSnapshotPath returns the path of the KV cache snapshot of the prompt with
+// the given digest for the model with modelDigest. Snapshots of all models
+// share a directory, since the runner only refers to them by file name.
+func GetSnapshotPath(modelDigest, digest string) (string, error) {
+	dir, err := modelsDir()
+	if err != nil {
+		return "", err
+	}
+
+	re := regexp.MustCompile("^sha256[:-][0-9a-fA-F]{64}$")
+	if !re.MatchString(digest) {
+		return "", ErrInvalidDigestFormat
+	}
+
+	return filepath.Join(dir, "snapshots", fmt.Sprintf("sha256-%s-%s", modelDigest, digest[len("sha256:"):])), nil
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// preloadModel is a model to load when the server starts
+type preloadModel struct {
+	name      string
+	options   map[string]interface{}
+	keepAlive *api.Duration
+	pinned    bool
+}
+
+// parsePreload parses the models in OLLAMA_PRELOAD. Each entry is a model name
+// optionally followed by query parameters holding its options, keep_alive and
+// pinned, e.g. llama3?num_ctx=8192&keep_alive=-1.
+func parsePreload(entries []string) ([]preloadModel, error) {
+	models := make([]preloadModel, 0, len(entries))
+	for _, entry := range entries {
+		name, query, _ := strings.Cut(entry, "?")
+		if name == "" {
+			return nil, fmt.Errorf("invalid OLLAMA_PRELOAD entry %q: missing model name", entry)
+		}
+
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OLLAMA_PRELOAD entry %q: %w", entry, err)
+		}
+
+		m := preloadModel{name: name}
+		if v := params.Get("keep_alive"); v != "" {
+			// keep_alive is given as in the API, either as a duration or a
+			// number of seconds
+			raw := []byte(v)
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				raw = []byte(strconv.Quote(v))
+			}
+
+			m.keepAlive = &api.Duration{}
+			if err := m.keepAlive.UnmarshalJSON(raw); err != nil {
+				return nil, fmt.Errorf("invalid OLLAMA_PRELOAD entry %q: invalid keep_alive: %w", entry, err)
+			}
+		}
+
+		if v := params.Get("pinned"); v != "" {
+			if m.pinned, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid OLLAMA_PRELOAD entry %q: invalid pinned: %w", entry, err)
+			}
+		}
+
+		params.Del("keep_alive")
+		params.Del("pinned")
+
+		if m.options, err = api.FormatParams(params); err != nil {
+			return nil, fmt.Errorf("invalid OLLAMA_PRELOAD entry %q: %w", entry, err)
+		}
+
+		// options are decoded as if they came from a JSON request
+		for k, v := range m.options {
+			if f, ok := v.(float32); ok {
+				m.options[k] = float64(f)
+			}
+		}
+
+		models = append(models, m)
+	}
+
+	return models, nil
+}
+
+// Preload loads models one at a time in order. Models that fail to load are
+// logged and reported by /api/ps. It returns when all models have been tried
+// or ctx is done.
+func (s *Scheduler) Preload(ctx context.Context, models []preloadModel) {
+	for _, m := range models {
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Info("preloading model", "model", m.name)
+		if err := s.preload(ctx, m); err != nil {
+			var pErr *fs.PathError
+			if errors.As(err, &pErr) {
+				err = errors.New("model not found, try pulling it first")
+			}
+
+			slog.Error("failed to preload model", "model", m.name, "error", err)
+			s.setPreloadError(ParseModelPath(m.name).GetShortTagname(), err)
+		}
+	}
+}
+
+func (s *Scheduler) preload(ctx context.Context, m preloadModel) error {
+	model, err := GetModel(m.name)
+	if err != nil {
+		return err
+	}
+
+	opts, err := modelOptions(model, m.options)
+	if err != nil {
+		return err
+	}
+
+	if err := modelDraft(model, opts); err != nil {
+		return err
+	}
+
+	sessionDuration := getDefaultSessionDuration()
+	if m.keepAlive != nil {
+		sessionDuration = m.keepAlive.Duration
+	}
+
+	// the runner is released for expiry once the load is done
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rCh, eCh := s.GetRunner(withLoad(ctx), model, opts, sessionDuration)
+	select {
+	case runner := <-rCh:
+		runner.refMu.Lock()
+		runner.pinned = m.pinned
+		runner.refMu.Unlock()
+		return nil
+	case err := <-eCh:
+		return err
+	}
+}
+
+// setPreloadError records that the model name failed to preload
+func (s *Scheduler) setPreloadError(name string, err error) {
+	s.preloadMu.Lock()
+	defer s.preloadMu.Unlock()
+
+	s.preloadErrors = slices.DeleteFunc(s.preloadErrors, func(e api.PreloadError) bool {
+		return e.Model == name
+	})
+	s.preloadErrors = append(s.preloadErrors, api.PreloadError{Model: name, Error: err.Error()})
+}
+
+// clearPreloadError forgets a preload failure of a model once it has loaded
+func (s *Scheduler) clearPreloadError(model *Model) {
+	s.preloadMu.Lock()
+	defer s.preloadMu.Unlock()
+
+	s.preloadErrors = slices.DeleteFunc(s.preloadErrors, func(e api.PreloadError) bool {
+		return e.Model == model.ShortName
+	})
+}
+
+// preloadFailures returns the models that failed to preload
+func (s *Scheduler) preloadFailures() []api.PreloadError {
+	s.preloadMu.Lock()
+	defer s.preloadMu.Unlock()
+	return slices.Clone(s.preloadErrors)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+func TestParsePreload(t *testing.T) {
+	models, err := parsePreload([]string{
+		"llama3",
+		"mistral:7b?num_ctx=8192&temperature=0.5&keep_alive=1h&pinned=true",
+		"phi3?keep_alive=-1",
+		"gemma?keep_alive=300",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(models) != 4 {
+		t.Fatalf("expected 4 models, got %d", len(models))
+	}
+
+	if m := models[0]; m.name != "llama3" || m.keepAlive != nil || m.pinned || len(m.options) != 0 {
+		t.Errorf("unexpected model %+v", m)
+	}
+
+	m := models[1]
+	if m.name != "mistral:7b" || !m.pinned || m.keepAlive.Duration != time.Hour {
+		t.Errorf("unexpected model %+v", m)
+	}
+
+	var opts api.Options
+	if err := opts.FromMap(m.options); err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.NumCtx != 8192 || opts.Temperature != 0.5 {
+		t.Errorf("unexpected options %+v", m.options)
+	}
+
+	if d := models[2].keepAlive.Duration; d >= 0 && d < time.Hour {
+		t.Errorf("expected phi3 to be kept loaded forever, got %s", d)
+	}
+
+	if d := models[3].keepAlive.Duration; d != 5*time.Minute {
+		t.Errorf("expected gemma to be kept loaded for 5m, got %s", d)
+	}
+
+	cases := map[string]string{
+		"missing name":   "?num_ctx=2048",
+		"bad keep_alive": "llama3?keep_alive=soon",
+		"bad pinned":     "llama3?pinned=maybe",
+		"bad option":     "llama3?num_ctx=large",
+		"unknown option": "llama3?colour=blue",
+	}
+
+	for name, entry := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parsePreload([]string{entry}); err == nil || !strings.Contains(err.Error(), "OLLAMA_PRELOAD") {
+				t.Errorf("expected error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPreloadErrors(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := InitScheduler(ctx)
+	s.Preload(ctx, []preloadModel{{name: "missing"}, {name: "other:7b"}})
+
+	failures := s.preloadFailures()
+	if len(failures) != 2 {
+		t.Fatalf("expected 2 failures, got %v", failures)
+	}
+
+	if failures[0].Model != "missing:latest" || !strings.Contains(failures[0].Error, "not found") {
+		t.Errorf("unexpected failure %+v", failures[0])
+	}
+
+	// a model that loads later is no longer reported
+	s.clearPreloadError(&Model{ShortName: "missing:latest"})
+	if failures := s.preloadFailures(); len(failures) != 1 || failures[0].Model != "other:7b" {
+		t.Errorf("unexpected failures %v", failures)
+	}
+}
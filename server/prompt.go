@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"strings"
@@ -54,19 +56,32 @@ func formatTemplateForResponse(tmpl *template.Template, generate bool) {
 // Prompt renders a prompt from a template. If generate is set to true,
 // the response and parts of the template following it are not rendered
 func Prompt(tmpl, system, prompt, response string, generate bool) (string, error) {
-	parsed, err := template.New("").Option("missingkey=zero").Parse(tmpl)
+	return renderPrompt(tmpl, map[string]any{
+		"System":   system,
+		"Prompt":   prompt,
+		"Response": response,
+	}, generate)
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+}
+
+func parseTemplate(tmpl string) (*template.Template, error) {
+	return template.New("").Option("missingkey=zero").Funcs(templateFuncs).Parse(tmpl)
+}
+
+func renderPrompt(tmpl string, vars map[string]any, generate bool) (string, error) {
+	parsed, err := parseTemplate(tmpl)
 	if err != nil {
 		return "", err
 	}
 
 	formatTemplateForResponse(parsed, generate)
 
-	vars := map[string]any{
-		"System":   system,
-		"Prompt":   prompt,
-		"Response": response,
-	}
-
 	var sb strings.Builder
 	if err := parsed.Execute(&sb, vars); err != nil {
 		return "", err
@@ -75,8 +90,8 @@ func Prompt(tmpl, system, prompt, response string, generate bool) (string, error
 	return sb.String(), nil
 }
 
-func countTokens(tmpl string, system string, prompt string, response string, encode func(string) ([]int, error)) (int, error) {
-	rendered, err := Prompt(tmpl, system, prompt, response, false)
+func countTokens(tmpl string, vars map[string]any, encode func(string) ([]int, error)) (int, error) {
+	rendered, err := renderPrompt(tmpl, vars, false)
 	if err != nil {
 		return 0, err
 	}
@@ -90,12 +105,15 @@ func countTokens(tmpl string, system string, prompt string, response string, enc
 	return len(tokens), err
 }
 
-// ChatPrompt builds up a prompt from a series of messages, truncating based on context window size
-func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(string) ([]int, error)) (string, error) {
+// ChatPrompt builds up a prompt from a series of messages, truncating based on context window size.
+// Tools, if any, are only made available to the template when rendering the final prompt.
+func ChatPrompt(tmpl string, messages []api.Message, tools api.Tools, window int, encode func(string) ([]int, error)) (string, error) {
 	type prompt struct {
-		System   string
-		Prompt   string
-		Response string
+		System      string
+		Prompt      string
+		Response    string
+		ToolCalls   []api.ToolCall
+		ToolResults []string
 
 		images []int
 		tokens int
@@ -103,20 +121,28 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 
 	var p prompt
 
-	// iterate through messages to build up {system,user,response} prompts
+	hasResponse := func() bool {
+		return p.Response != "" || len(p.ToolCalls) > 0
+	}
+
+	isEmpty := func() bool {
+		return p.System == "" && p.Prompt == "" && !hasResponse() && len(p.ToolResults) == 0
+	}
+
+	// iterate through messages to build up {system,user,response,tool} prompts
 	var imgId int
 	var prompts []prompt
 	for _, msg := range messages {
 		switch strings.ToLower(msg.Role) {
 		case "system":
-			if p.System != "" || p.Prompt != "" || p.Response != "" {
+			if !isEmpty() {
 				prompts = append(prompts, p)
 				p = prompt{}
 			}
 
 			p.System = msg.Content
 		case "user":
-			if p.Prompt != "" || p.Response != "" {
+			if p.Prompt != "" || hasResponse() || len(p.ToolResults) > 0 {
 				prompts = append(prompts, p)
 				p = prompt{}
 			}
@@ -131,25 +157,50 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 			sb.WriteString(msg.Content)
 			p.Prompt = sb.String()
 		case "assistant":
-			if p.Response != "" {
+			if hasResponse() {
 				prompts = append(prompts, p)
 				p = prompt{}
 			}
 
 			p.Response = msg.Content
+			p.ToolCalls = msg.ToolCalls
+		case "tool":
+			// consecutive tool results are grouped into the same prompt
+			if hasResponse() {
+				prompts = append(prompts, p)
+				p = prompt{}
+			}
+
+			p.ToolResults = append(p.ToolResults, msg.Content)
 		default:
-			return "", fmt.Errorf("invalid role: %s, role must be one of [system, user, assistant]", msg.Role)
+			return "", fmt.Errorf("invalid role: %s, role must be one of [system, user, assistant, tool]", msg.Role)
 		}
 	}
 
 	// add final prompt
-	if p.System != "" || p.Prompt != "" || p.Response != "" {
+	if !isEmpty() {
 		prompts = append(prompts, p)
 	}
 
+	vars := func(i int) map[string]any {
+		v := map[string]any{
+			"System":      prompts[i].System,
+			"Prompt":      prompts[i].Prompt,
+			"Response":    prompts[i].Response,
+			"ToolCalls":   prompts[i].ToolCalls,
+			"ToolResults": prompts[i].ToolResults,
+		}
+
+		if i == len(prompts)-1 && len(tools) > 0 {
+			v["Tools"] = tools
+		}
+
+		return v
+	}
+
 	// calculate token lengths for each prompt, estimating 768 tokens per images
-	for i, p := range prompts {
-		tokens, err := countTokens(tmpl, p.System, p.Prompt, p.Response, encode)
+	for i := range prompts {
+		tokens, err := countTokens(tmpl, vars(i), encode)
 		if err != nil {
 			return "", err
 		}
@@ -192,7 +243,7 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 			if system != "" && prompts[0].System == "" {
 				prompts[0].System = system
 
-				tokens, err := countTokens(tmpl, prompts[0].System, prompts[0].Prompt, prompts[0].Response, encode)
+				tokens, err := countTokens(tmpl, vars(0), encode)
 				if err != nil {
 					return "", err
 				}
@@ -208,9 +259,9 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 	}
 
 	var sb strings.Builder
-	for i, p := range prompts {
+	for i := range prompts {
 		// last prompt should leave the response unrendered (for completion)
-		rendered, err := Prompt(tmpl, p.System, p.Prompt, p.Response, i == len(prompts)-1)
+		rendered, err := renderPrompt(tmpl, vars(i), i == len(prompts)-1)
 		if err != nil {
 			return "", err
 		}
@@ -219,3 +270,243 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 
 	return sb.String(), nil
 }
+
+// templateHasField reports whether tmpl references the top level field name
+// anywhere in its parse tree, e.g. {{ .Tools }} or {{ range .Tools }}
+func templateHasField(tmpl, name string) bool {
+	parsed, err := parseTemplate(tmpl)
+	if err != nil {
+		return false
+	}
+
+	var found bool
+	walkTemplate(parsed.Tree.Root, func(n parse.Node) bool {
+		if f, ok := n.(*parse.FieldNode); ok && len(f.Ident) > 0 && f.Ident[0] == name {
+			found = true
+		}
+		return !found
+	})
+
+	return found
+}
+
+// walkTemplate visits every node of a template parse tree depth first until fn returns false
+func walkTemplate(n parse.Node, fn func(parse.Node) bool) bool {
+	if n == nil || !fn(n) {
+		return false
+	}
+
+	var children []parse.Node
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			children = append(children, n.Nodes...)
+		}
+	case *parse.ActionNode:
+		children = append(children, n.Pipe)
+	case *parse.PipeNode:
+		for _, c := range n.Cmds {
+			children = append(children, c)
+		}
+	case *parse.CommandNode:
+		children = append(children, n.Args...)
+	case *parse.IfNode:
+		children = append(children, n.Pipe, n.List, n.ElseList)
+	case *parse.RangeNode:
+		children = append(children, n.Pipe, n.List, n.ElseList)
+	case *parse.WithNode:
+		children = append(children, n.Pipe, n.List, n.ElseList)
+	}
+
+	for _, c := range children {
+		if !walkTemplate(c, fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// toolCallFormat learns the shape of a tool call from the part of the
+// template which ranges over .ToolCalls, by rendering it with placeholder
+// values and looking up which keys hold the function name and arguments.
+// The prefix is the text the template writes before the first tool call,
+// e.g. "[TOOL_CALLS] [" or "<tool_call>".
+func toolCallFormat(tmpl string) (prefix, name, arguments string, ok bool) {
+	parsed, err := parseTemplate(tmpl)
+	if err != nil {
+		return "", "", "", false
+	}
+
+	// find the range over .ToolCalls along with the text before it
+	var rangeNode *parse.RangeNode
+	var text string
+	walkTemplate(parsed.Tree.Root, func(n parse.Node) bool {
+		l, ok := n.(*parse.ListNode)
+		if !ok || l == nil {
+			return true
+		}
+
+		for i, n := range l.Nodes {
+			r, ok := n.(*parse.RangeNode)
+			if !ok {
+				continue
+			}
+
+			walkTemplate(r.Pipe, func(n parse.Node) bool {
+				if f, ok := n.(*parse.FieldNode); ok && len(f.Ident) > 0 && f.Ident[0] == "ToolCalls" {
+					rangeNode = r
+				}
+				return rangeNode == nil
+			})
+
+			if rangeNode != nil {
+				for j := i - 1; j >= 0; j-- {
+					t, ok := l.Nodes[j].(*parse.TextNode)
+					if !ok {
+						break
+					}
+					text = string(t.Text) + text
+				}
+				return false
+			}
+		}
+
+		return true
+	})
+
+	if rangeNode == nil {
+		return "", "", "", false
+	}
+
+	subtree, err := template.New("").Option("missingkey=zero").Funcs(templateFuncs).AddParseTree("", &parse.Tree{
+		Root: &parse.ListNode{NodeType: parse.NodeList, Nodes: []parse.Node{rangeNode}},
+	})
+	if err != nil {
+		return "", "", "", false
+	}
+
+	var b bytes.Buffer
+	if err := subtree.Execute(&b, map[string]any{
+		"ToolCalls": []api.ToolCall{{
+			Function: api.ToolCallFunction{
+				Name:      "@@name@@",
+				Arguments: api.ToolCallFunctionArguments{"@@argument@@": 1},
+			},
+		}},
+	}); err != nil {
+		return "", "", "", false
+	}
+
+	var kv map[string]any
+	i := bytes.IndexByte(b.Bytes(), '{')
+	if i < 0 {
+		return "", "", "", false
+	} else if err := json.NewDecoder(bytes.NewReader(b.Bytes()[i:])).Decode(&kv); err != nil {
+		return "", "", "", false
+	}
+
+	// find the keys that hold the placeholder name and arguments
+	for k, v := range kv {
+		switch v := v.(type) {
+		case string:
+			if v == "@@name@@" {
+				name = k
+			}
+		case map[string]any:
+			if _, ok := v["@@argument@@"]; ok {
+				arguments = k
+			}
+		}
+	}
+
+	if name == "" || arguments == "" {
+		return "", "", "", false
+	}
+
+	// only the last line of the text before the tool calls is written by
+	// the model, earlier lines are headers such as the role
+	prefix = strings.TrimSpace(text + b.String()[:i])
+	if j := strings.LastIndexByte(prefix, '\n'); j >= 0 {
+		prefix = strings.TrimSpace(prefix[j+1:])
+	}
+
+	return prefix, name, arguments, true
+}
+
+// mayBeToolCall reports whether a partial response could still turn out to be
+// a tool call written with the given prefix, so that it isn't streamed as text
+func mayBeToolCall(prefix, s string) bool {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return true
+	case s[0] == '{' || s[0] == '[':
+		return true
+	case prefix == "":
+		return false
+	}
+
+	return strings.HasPrefix(s, prefix) || strings.HasPrefix(prefix, s)
+}
+
+// parseToolCalls extracts tool calls from a model response, in the shape
+// learned by toolCallFormat
+func parseToolCalls(tmpl, s string) ([]api.ToolCall, bool) {
+	_, name, arguments, ok := toolCallFormat(tmpl)
+	if !ok {
+		return nil, false
+	}
+
+	// collect the JSON objects in the response, and in arrays in the
+	// response, but not the objects nested in them, which are arguments
+	collect := func(v any) (all []map[string]any) {
+		switch v := v.(type) {
+		case map[string]any:
+			all = append(all, v)
+		case []any:
+			for _, e := range v {
+				if m, ok := e.(map[string]any); ok {
+					all = append(all, m)
+				}
+			}
+		}
+		return all
+	}
+
+	var objs []map[string]any
+	for offset := 0; offset < len(s); {
+		i := strings.IndexAny(s[offset:], "{[")
+		if i < 0 {
+			break
+		}
+
+		offset += i
+
+		var v any
+		d := json.NewDecoder(strings.NewReader(s[offset:]))
+		if err := d.Decode(&v); err != nil {
+			offset++
+			continue
+		}
+
+		objs = append(objs, collect(v)...)
+		offset += int(d.InputOffset())
+	}
+
+	var toolCalls []api.ToolCall
+	for _, obj := range objs {
+		n, nok := obj[name].(string)
+		a, aok := obj[arguments].(map[string]any)
+		if nok && aok {
+			toolCalls = append(toolCalls, api.ToolCall{
+				Function: api.ToolCallFunction{
+					Name:      n,
+					Arguments: a,
+				},
+			})
+		}
+	}
+
+	return toolCalls, len(toolCalls) > 0
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
@@ -78,6 +79,7 @@ func TestChatPrompt(t *testing.T) {
 		name     string
 		template string
 		messages []api.Message
+		tools    api.Tools
 		window   int
 		want     string
 	}{
@@ -183,6 +185,51 @@ func TestChatPrompt(t *testing.T) {
 			window: 1024,
 			want:   "",
 		},
+		{
+			name:     "tools",
+			template: `{{ if .Tools }}[AVAILABLE_TOOLS] {{ range .Tools }}{{ .Function.Name }} {{ end }}[/AVAILABLE_TOOLS]{{ end }}{{ if .Prompt }}[INST] {{ .Prompt }} [/INST]{{ end }}{{ range .ToolResults }}[TOOL_RESULTS] {{ . }} [/TOOL_RESULTS]{{ end }}{{ if .ToolCalls }}[TOOL_CALLS] [{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}]{{ end }}{{ .Response }}`,
+			messages: []api.Message{
+				{Role: "user", Content: "What's the weather in Paris?"},
+			},
+			tools: api.Tools{
+				{Type: "function", Function: api.ToolFunction{Name: "get_weather"}},
+				{Type: "function", Function: api.ToolFunction{Name: "get_time"}},
+			},
+			window: 1024,
+			want:   "[AVAILABLE_TOOLS] get_weather get_time [/AVAILABLE_TOOLS][INST] What's the weather in Paris? [/INST]",
+		},
+		{
+			name:     "tool parameters",
+			template: `{{ if .Tools }}[AVAILABLE_TOOLS] {{ json .Tools }}[/AVAILABLE_TOOLS]{{ end }}{{ if .Prompt }}[INST] {{ .Prompt }} [/INST]{{ end }}{{ .Response }}`,
+			messages: []api.Message{
+				{Role: "user", Content: "What's the weather in Paris?"},
+			},
+			tools: api.Tools{
+				{Type: "function", Function: api.ToolFunction{
+					Name:       "get_weather",
+					Parameters: api.ToolFunctionParameters(`{"type": "object", "properties": {"days": {"type": "array", "items": {"type": "integer"}}}}`),
+				}},
+			},
+			window: 1024,
+			want:   `[AVAILABLE_TOOLS] [{"type":"function","function":{"name":"get_weather","description":"","parameters":{"type":"object","properties":{"days":{"type":"array","items":{"type":"integer"}}}}}}][/AVAILABLE_TOOLS][INST] What's the weather in Paris? [/INST]`,
+		},
+		{
+			name:     "tool calls and results",
+			template: `{{ if .Tools }}[AVAILABLE_TOOLS] {{ range .Tools }}{{ .Function.Name }} {{ end }}[/AVAILABLE_TOOLS]{{ end }}{{ if .Prompt }}[INST] {{ .Prompt }} [/INST]{{ end }}{{ range .ToolResults }}[TOOL_RESULTS] {{ . }} [/TOOL_RESULTS]{{ end }}{{ if .ToolCalls }}[TOOL_CALLS] [{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}]{{ end }}{{ .Response }}`,
+			messages: []api.Message{
+				{Role: "user", Content: "What's the weather in Paris?"},
+				{Role: "assistant", ToolCalls: []api.ToolCall{
+					{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}},
+				}},
+				{Role: "tool", Content: "22C"},
+				{Role: "tool", Content: "sunny"},
+			},
+			tools: api.Tools{
+				{Type: "function", Function: api.ToolFunction{Name: "get_weather"}},
+			},
+			window: 1024,
+			want:   `[INST] What's the weather in Paris? [/INST][TOOL_CALLS] [{"name": "get_weather", "arguments": {"city":"Paris"}}][AVAILABLE_TOOLS] get_weather [/AVAILABLE_TOOLS][TOOL_RESULTS] 22C [/TOOL_RESULTS][TOOL_RESULTS] sunny [/TOOL_RESULTS]`,
+		},
 	}
 
 	encode := func(s string) ([]int, error) {
@@ -192,7 +239,7 @@ func TestChatPrompt(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got, err := ChatPrompt(tc.template, tc.messages, tc.window, encode)
+			got, err := ChatPrompt(tc.template, tc.messages, tc.tools, tc.window, encode)
 			if err != nil {
 				t.Errorf("error = %v", err)
 			}
@@ -203,3 +250,145 @@ func TestChatPrompt(t *testing.T) {
 		})
 	}
 }
+
+func TestParseToolCalls(t *testing.T) {
+	mistral := `{{ .Prompt }}{{ if .ToolCalls }}[TOOL_CALLS] [{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}]{{ end }}{{ .Response }}`
+	hermes := `{{ .Prompt }}{{ range .ToolCalls }}<tool_call>{"function": "{{ .Function.Name }}", "parameters": {{ .Function.Arguments }}}</tool_call>{{ end }}{{ .Response }}`
+	typed := `{{ .Prompt }}{{ range .ToolCalls }}{"type": "function", "name": "{{ .Function.Name }}", "options": {}, "arguments": {{ json .Function.Arguments }}}{{ end }}{{ .Response }}`
+
+	weather := api.ToolCall{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}
+	clock := api.ToolCall{Function: api.ToolCallFunction{Name: "get_time", Arguments: api.ToolCallFunctionArguments{"timezone": "CET"}}}
+
+	tests := []struct {
+		name     string
+		template string
+		output   string
+		want     []api.ToolCall
+		ok       bool
+	}{
+		{
+			name:     "single",
+			template: mistral,
+			output:   `[TOOL_CALLS] [{"name": "get_weather", "arguments": {"city": "Paris"}}]`,
+			want:     []api.ToolCall{weather},
+			ok:       true,
+		},
+		{
+			name:     "multiple",
+			template: mistral,
+			output:   `[TOOL_CALLS] [{"name": "get_weather", "arguments": {"city": "Paris"}}, {"name": "get_time", "arguments": {"timezone": "CET"}}]`,
+			want:     []api.ToolCall{weather, clock},
+			ok:       true,
+		},
+		{
+			name:     "custom keys",
+			template: hermes,
+			output:   `<tool_call>{"function": "get_weather", "parameters": {"city": "Paris"}}</tool_call>` + "\n" + `<tool_call>{"function": "get_time", "parameters": {"timezone": "CET"}}</tool_call>`,
+			want:     []api.ToolCall{weather, clock},
+			ok:       true,
+		},
+		{
+			name:     "other fields",
+			template: typed,
+			output:   `{"type": "function", "name": "get_weather", "options": {}, "arguments": {"city": "Paris"}}`,
+			want:     []api.ToolCall{weather},
+			ok:       true,
+		},
+		{
+			name:     "nested",
+			template: mistral,
+			output:   `[TOOL_CALLS] [{"name": "get_weather", "arguments": {"city": "Paris", "then": {"name": "get_time", "arguments": {"timezone": "CET"}}}}]`,
+			want: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{
+				"city": "Paris",
+				"then": map[string]any{"name": "get_time", "arguments": map[string]any{"timezone": "CET"}},
+			}}}},
+			ok: true,
+		},
+		{
+			name:     "text",
+			template: mistral,
+			output:   "The weather in Paris is {unknown}.",
+			ok:       false,
+		},
+		{
+			name:     "no tool calls in template",
+			template: "{{ .Prompt }} {{ .Response }}",
+			output:   `[{"name": "get_weather", "arguments": {"city": "Paris"}}]`,
+			ok:       false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := parseToolCalls(tc.template, tc.output)
+			if ok != tc.ok {
+				t.Fatalf("ok = %v, want %v", ok, tc.ok)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMayBeToolCall(t *testing.T) {
+	tests := []struct {
+		template string
+		prefix   string
+	}{
+		{
+			template: `{{ .Prompt }}{{ if .ToolCalls }}[TOOL_CALLS] [{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}]{{ end }}{{ .Response }}`,
+			prefix:   "[TOOL_CALLS] [",
+		},
+		{
+			template: `{{ .Prompt }}{{ range .ToolCalls }}<tool_call>{"function": "{{ .Function.Name }}", "parameters": {{ .Function.Arguments }}}</tool_call>{{ end }}{{ .Response }}`,
+			prefix:   "<tool_call>",
+		},
+		{
+			template: `{{ .Prompt }}<|im_start|>assistant
+{{ if .ToolCalls }}<tool_call>
+{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}</tool_call>{{ end }}{{ .Response }}`,
+			prefix: "<tool_call>",
+		},
+		{
+			template: `{{ .Prompt }}{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}{{ .Response }}`,
+			prefix:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		prefix, _, _, ok := toolCallFormat(tt.template)
+		if !ok {
+			t.Fatalf("expected tool call format in %q", tt.template)
+		}
+
+		if prefix != tt.prefix {
+			t.Errorf("prefix = %q, want %q", prefix, tt.prefix)
+		}
+	}
+
+	cases := []struct {
+		prefix string
+		s      string
+		want   bool
+	}{
+		{"[TOOL_CALLS] [", "", true},
+		{"[TOOL_CALLS] [", " \n", true},
+		{"[TOOL_CALLS] [", "[TOOL", true},
+		{"[TOOL_CALLS] [", "[TOOL_CALLS] [{", true},
+		{"[TOOL_CALLS] [", `{"name"`, true},
+		{"[TOOL_CALLS] [", "It's sunny", false},
+		{"<tool_call>", "<tool", true},
+		{"<tool_call>", "<tool_call>\n{", true},
+		{"<tool_call>", "<b>", false},
+		{"", `[{"name"`, true},
+		{"", "<tool_call>", false},
+	}
+
+	for _, tt := range cases {
+		if got := mayBeToolCall(tt.prefix, tt.s); got != tt.want {
+			t.Errorf("mayBeToolCall(%q, %q) = %v, want %v", tt.prefix, tt.s, got, tt.want)
+		}
+	}
+}
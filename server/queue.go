@@ -0,0 +1,188 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// priority is the scheduling class of a request
+type priority string
+
+const (
+	// priorityInteractive is for requests a user is waiting on. It is the
+	// default.
+	priorityInteractive priority = "interactive"
+
+	// priorityBatch is for bulk requests that can wait behind interactive
+	// ones
+	priorityBatch priority = "batch"
+)
+
+// priorityWeights is the share of scheduling each priority gets relative to
+// the others when requests of several priorities are waiting, so batch
+// requests are delayed by interactive ones but never starved
+var priorityWeights = map[priority]float64{
+	priorityInteractive: 4,
+	priorityBatch:       1,
+}
+
+// priorityHeader sets the priority of requests that have no priority field
+const priorityHeader = "X-Ollama-Priority"
+
+func parsePriority(s string) (priority, error) {
+	switch p := priority(s); p {
+	case "":
+		return priorityInteractive, nil
+	case priorityInteractive, priorityBatch:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid priority %q, must be %q or %q", s, priorityInteractive, priorityBatch)
+	}
+}
+
+type schedulingKey struct{}
+
+// scheduling identifies the queue of a request in the scheduler
+type scheduling struct {
+	priority priority
+	client   string
+
+	// adapters identifies the adapters the request applies to the runner,
+	// so requests using the same adapters can share it
+	adapters string
+
+	// load marks requests to load a model, which may reload a pinned runner
+	// with different options
+	load bool
+}
+
+// schedulingContext returns the request context annotated with the priority
+// and client the scheduler queues the request by. The priority is field if it
+// is set, or else the priority header.
+func schedulingContext(c *gin.Context, field string) (context.Context, error) {
+	p, err := parsePriority(cmp.Or(field, c.GetHeader(priorityHeader)))
+	if err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(c.Request.Context(), schedulingKey{}, scheduling{priority: p, client: requestClient(c)}), nil
+}
+
+// withAdapters returns ctx annotated with the adapters the request applies to
+// the runner
+func withAdapters(ctx context.Context, adapters []llm.Adapter) context.Context {
+	s := schedulingFromContext(ctx)
+	if len(adapters) > 0 {
+		s.adapters = fmt.Sprint(adapters)
+	}
+
+	return context.WithValue(ctx, schedulingKey{}, s)
+}
+
+// withLoad returns ctx annotated as a request to load a model
+func withLoad(ctx context.Context) context.Context {
+	s := schedulingFromContext(ctx)
+	s.load = true
+	return context.WithValue(ctx, schedulingKey{}, s)
+}
+
+func schedulingFromContext(ctx context.Context) scheduling {
+	if s, ok := ctx.Value(schedulingKey{}).(scheduling); ok {
+		return s
+	}
+
+	return scheduling{priority: priorityInteractive}
+}
+
+// pendingQueue orders pending requests by weighted fair queuing. Requests are
+// grouped into flows by client, model and priority. Each flow is served in
+// turn in proportion to the weight of its priority, so a client sending many
+// requests delays others by at most one request per turn.
+type pendingQueue struct {
+	mu sync.Mutex
+
+	// items are kept in the order they're served, by virtual finish time and
+	// then by arrival
+	items []*LlmRequest
+
+	// flows holds the virtual finish time of the last request queued in each
+	// flow that still has requests waiting
+	flows map[string]float64
+
+	// vtime is the latest virtual start time of the requests served
+	vtime float64
+	seq   uint64
+}
+
+func newPendingQueue() *pendingQueue {
+	return &pendingQueue{flows: make(map[string]float64)}
+}
+
+func comparePending(a, b *LlmRequest) int {
+	return cmp.Or(cmp.Compare(a.vfinish, b.vfinish), cmp.Compare(a.seq, b.seq))
+}
+
+func (q *pendingQueue) push(req *LlmRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	flow := fmt.Sprintf("%s\x00%s\x00%s", req.scheduling.client, req.model.ModelPath, req.scheduling.priority)
+	req.vstart = max(q.vtime, q.flows[flow])
+	req.vfinish = req.vstart + 1/priorityWeights[req.scheduling.priority]
+	q.flows[flow] = req.vfinish
+
+	q.seq++
+	req.seq = q.seq
+	i, _ := slices.BinarySearchFunc(q.items, req, comparePending)
+	q.items = slices.Insert(q.items, i, req)
+}
+
+// pop returns the next request to schedule that ready accepts, or nil if
+// there is none. A nil ready accepts every request.
+func (q *pendingQueue) pop(ready func(*LlmRequest) bool) *LlmRequest {
+	// ready may block, so requests are checked in order without holding the
+	// lock. Only the scheduler pushes and pops, so the queue doesn't change
+	// while it's walked.
+	for i := 0; i < q.len(); i++ {
+		q.mu.Lock()
+		req := q.items[i]
+		q.mu.Unlock()
+
+		if ready == nil || ready(req) {
+			q.remove(i)
+			return req
+		}
+	}
+
+	return nil
+}
+
+func (q *pendingQueue) remove(i int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	req := q.items[i]
+	q.items = slices.Delete(q.items, i, i+1)
+	q.vtime = max(q.vtime, req.vstart)
+
+	// flows that have caught up with the virtual time have nothing waiting,
+	// and neither does any flow once the queue is empty
+	for flow, finish := range q.flows {
+		if finish <= q.vtime || len(q.items) == 0 {
+			delete(q.flows, flow)
+		}
+	}
+}
+
+func (q *pendingQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func queueRequest(client, model string, p priority) *LlmRequest {
+	return &LlmRequest{
+		model:      &Model{ModelPath: model},
+		scheduling: scheduling{priority: p, client: client},
+	}
+}
+
+func popAll(q *pendingQueue) []string {
+	var order []string
+	for req := q.pop(nil); req != nil; req = q.pop(nil) {
+		order = append(order, req.scheduling.client)
+	}
+
+	return order
+}
+
+func TestPendingQueue(t *testing.T) {
+	t.Run("interactive before batch", func(t *testing.T) {
+		q := newPendingQueue()
+		for range 4 {
+			q.push(queueRequest("batch", "a", priorityBatch))
+		}
+
+		q.push(queueRequest("chat", "a", priorityInteractive))
+		q.push(queueRequest("chat", "a", priorityInteractive))
+
+		if got, want := strings.Join(popAll(q), " "), "chat chat batch batch batch batch"; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("clients share", func(t *testing.T) {
+		q := newPendingQueue()
+		for range 3 {
+			q.push(queueRequest("a", "m", priorityInteractive))
+		}
+
+		for range 3 {
+			q.push(queueRequest("b", "m", priorityInteractive))
+		}
+
+		if got, want := strings.Join(popAll(q), " "), "a b a b a b"; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("batch is not starved", func(t *testing.T) {
+		q := newPendingQueue()
+		q.push(queueRequest("batch", "m", priorityBatch))
+		for range 10 {
+			q.push(queueRequest("chat", "m", priorityInteractive))
+		}
+
+		order := popAll(q)
+		for i, client := range order {
+			if client == "batch" {
+				if i > 4 {
+					t.Errorf("expected batch request within the first 5, got position %d: %v", i, order)
+				}
+				return
+			}
+		}
+
+		t.Errorf("batch request was not served: %v", order)
+	})
+
+	t.Run("fifo within a flow", func(t *testing.T) {
+		q := newPendingQueue()
+		reqs := make([]*LlmRequest, 5)
+		for i := range reqs {
+			reqs[i] = queueRequest("a", "m", priorityBatch)
+			q.push(reqs[i])
+		}
+
+		for i := range reqs {
+			if req := q.pop(nil); req != reqs[i] {
+				t.Fatalf("expected request %d", i)
+			}
+		}
+
+		if q.len() != 0 || len(q.flows) != 0 {
+			t.Errorf("expected empty queue, got %d requests and %d flows", q.len(), len(q.flows))
+		}
+	})
+}
+
+func TestPendingQueueReady(t *testing.T) {
+	q := newPendingQueue()
+	q.push(queueRequest("batch", "a", priorityBatch))
+	q.push(queueRequest("chat", "a", priorityInteractive))
+	q.push(queueRequest("other", "b", priorityBatch))
+
+	// requests for a are held back while its runner is busy
+	busy := func(req *LlmRequest) bool { return req.model.ModelPath != "a" }
+	if req := q.pop(busy); req == nil || req.scheduling.client != "other" {
+		t.Fatalf("expected the request for b, got %v", req)
+	}
+
+	if req := q.pop(busy); req != nil {
+		t.Fatalf("expected no request, got %v", req)
+	}
+
+	if got, want := strings.Join(popAll(q), " "), "chat batch"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSchedulingContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		field  string
+		header string
+		want   priority
+		err    bool
+	}{
+		{"", "", priorityInteractive, false},
+		{"", "batch", priorityBatch, false},
+		{"interactive", "batch", priorityInteractive, false},
+		{"batch", "", priorityBatch, false},
+		{"urgent", "", "", true},
+		{"", "urgent", "", true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.field+"/"+tt.header, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+			if tt.header != "" {
+				c.Request.Header.Set(priorityHeader, tt.header)
+			}
+
+			ctx, err := schedulingContext(c, tt.field)
+			if tt.err {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := schedulingFromContext(ctx).priority; got != tt.want {
+				t.Errorf("expected priority %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitContextKey is the gin context key holding the *clientBudget of the
+// client that made the request
+const rateLimitContextKey = "rateLimit"
+
+// tokenBucket holds up to capacity tokens and refills at capacity tokens per
+// minute. The level can drop below zero when more is spent than was
+// available, which delays the next request until the debt is repaid.
+type tokenBucket struct {
+	capacity float64
+	level    float64
+	updated  time.Time
+}
+
+func newTokenBucket(capacity int, now time.Time) *tokenBucket {
+	return &tokenBucket{capacity: float64(capacity), level: float64(capacity), updated: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.level = min(b.capacity, b.level+now.Sub(b.updated).Minutes()*b.capacity)
+	b.updated = now
+}
+
+// wait returns how long until the bucket holds at least n tokens
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.level >= n {
+		return 0
+	}
+
+	return time.Duration((n - b.level) / b.capacity * float64(time.Minute))
+}
+
+func (b *tokenBucket) remaining() int {
+	return max(0, int(b.level))
+}
+
+// clientBudget is the remaining request and token budget of a single client.
+// Either bucket is nil if that limit is disabled.
+type clientBudget struct {
+	mu       sync.Mutex
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+// spend removes n generated tokens from the budget
+func (b *clientBudget) spend(n int, now time.Time) {
+	if b.tokens == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens.refill(now)
+	b.tokens.level -= float64(n)
+}
+
+// full reports whether the budget has refilled completely, in which case it
+// is no different from a new one
+func (b *clientBudget) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, bucket := range []*tokenBucket{b.requests, b.tokens} {
+		if bucket != nil {
+			bucket.refill(now)
+			if bucket.level < bucket.capacity {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// rateLimiter limits the number of inference requests each client can make,
+// and the number of tokens generated for it, per minute
+type rateLimiter struct {
+	requests int
+	tokens   int
+
+	mu      sync.Mutex
+	clients map[string]*clientBudget
+	pruned  time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing requests and tokens per
+// minute for each client, or nil if both limits are disabled
+func newRateLimiter(requests, tokens int) *rateLimiter {
+	if requests <= 0 && tokens <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{requests: requests, tokens: tokens, clients: make(map[string]*clientBudget)}
+}
+
+func (l *rateLimiter) budget(client string, now time.Time) *clientBudget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// drop budgets that have refilled so clients that have gone away don't
+	// accumulate
+	if now.Sub(l.pruned) > time.Minute {
+		for k, b := range l.clients {
+			if b.full(now) {
+				delete(l.clients, k)
+			}
+		}
+
+		l.pruned = now
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &clientBudget{}
+		if l.requests > 0 {
+			b.requests = newTokenBucket(l.requests, now)
+		}
+
+		if l.tokens > 0 {
+			b.tokens = newTokenBucket(l.tokens, now)
+		}
+
+		l.clients[client] = b
+	}
+
+	return b
+}
+
+// allow takes a request from the budget of client. It returns how long the
+// client must wait if the request or token budget is exhausted.
+func (l *rateLimiter) allow(c *gin.Context, client string, now time.Time) (*clientBudget, time.Duration) {
+	b := l.budget(client, now)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var wait time.Duration
+	if b.requests != nil {
+		b.requests.refill(now)
+		wait = max(wait, b.requests.wait(1))
+	}
+
+	if b.tokens != nil {
+		b.tokens.refill(now)
+		wait = max(wait, b.tokens.wait(1))
+	}
+
+	if wait == 0 && b.requests != nil {
+		b.requests.level--
+	}
+
+	if b.requests != nil {
+		c.Header("X-RateLimit-Limit-Requests", strconv.Itoa(l.requests))
+		c.Header("X-RateLimit-Remaining-Requests", strconv.Itoa(b.requests.remaining()))
+	}
+
+	if b.tokens != nil {
+		c.Header("X-RateLimit-Limit-Tokens", strconv.Itoa(l.tokens))
+		c.Header("X-RateLimit-Remaining-Tokens", strconv.Itoa(b.tokens.remaining()))
+	}
+
+	return b, wait
+}
+
+// rateLimitMiddleware applies limits to inference requests from each client.
+// All requests are allowed when limits is nil.
+func rateLimitMiddleware(limits *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limits == nil || routePermissions[c.FullPath()] != permissionInference {
+			c.Next()
+			return
+		}
+
+		b, wait := limits.allow(c, requestClient(c), time.Now())
+		if wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			abortWithError(c, http.StatusTooManyRequests, errors.New("rate limit exceeded, please retry later"))
+			return
+		}
+
+		c.Set(rateLimitContextKey, b)
+		c.Next()
+	}
+}
+
+// requestClient identifies the client that made a request by the API key it
+// authenticated with, or else by the address it connected from. Forwarding
+// headers are ignored since any client can set them.
+func requestClient(c *gin.Context) string {
+	if name := c.GetString(apiKeyContextKey); name != "" {
+		return "key:" + name
+	}
+
+	return "ip:" + c.RemoteIP()
+}
+
+// spendTokens removes n generated tokens from the budget of the client that
+// requested them
+func spendTokens(c *gin.Context, n int) {
+	if v, ok := c.Get(rateLimitContextKey); ok {
+		v.(*clientBudget).spend(n, time.Now())
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(60, now)
+
+	b.level -= 90
+	if wait := b.wait(1); wait != 31*time.Second {
+		t.Errorf("expected to wait 31s, got %s", wait)
+	}
+
+	b.refill(now.Add(30 * time.Second))
+	if b.level != 0 || b.remaining() != 0 {
+		t.Errorf("expected level 0, got %f", b.level)
+	}
+
+	b.refill(now.Add(time.Hour))
+	if b.level != 60 {
+		t.Errorf("expected bucket to refill to capacity, got %f", b.level)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys, err := loadAPIKeys(writeAPIKeys(t, `{"keys": [
+		{"name": "a", "key": "a-secret", "permissions": ["inference"]},
+		{"name": "b", "key": "b-secret", "permissions": ["inference"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(authMiddleware(keys), rateLimitMiddleware(newRateLimiter(2, 10)))
+	r.POST("/api/generate", func(c *gin.Context) {
+		spendTokens(c, 8)
+		c.Status(http.StatusOK)
+	})
+	r.GET("/api/tags", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/generate", "a-secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	for k, v := range map[string]string{
+		"X-RateLimit-Limit-Requests":     "2",
+		"X-RateLimit-Remaining-Requests": "1",
+		"X-RateLimit-Limit-Tokens":       "10",
+		"X-RateLimit-Remaining-Tokens":   "10",
+	} {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("expected %s %q, got %q", k, v, got)
+		}
+	}
+
+	// the second request is within the request limit but spends more tokens
+	// than remain
+	if w := do(http.MethodPost, "/api/generate", "a-secret"); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	w = do(http.MethodPost, "/api/generate", "a-secret")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+
+	if w := do(http.MethodPost, "/api/generate", "b-secret"); w.Code != http.StatusOK {
+		t.Errorf("expected other clients not to be limited, got %d", w.Code)
+	}
+
+	if w := do(http.MethodGet, "/api/tags", "a-secret"); w.Code != http.StatusOK {
+		t.Errorf("expected non-inference routes not to be limited, got %d", w.Code)
+	}
+}
+
+func TestRateLimitClientAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(rateLimitMiddleware(newRateLimiter(1, 0)))
+	r.POST("/api/generate", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// clients can't get a new budget by claiming to be forwarded for
+	// another address
+	for i, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("request %d: expected status %d, got %d", i, code, w.Code)
+		}
+	}
+}
+
+func TestRateLimitCancelledCompletion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the completion is cancelled after streaming three tokens
+	mock := mockLlm{
+		completionChunks: []llm.CompletionResponse{{Content: "a"}, {Content: "b"}, {Content: "c"}},
+		completionResp:   context.Canceled,
+	}
+
+	s := Server{sched: InitScheduler(ctx), limits: newRateLimiter(0, 10)}
+	s.sched.getCpuFn = func() gpu.GpuInfoList { return gpu.GpuInfoList{{Library: "cpu"}} }
+	s.sched.getGpuFn = s.sched.getCpuFn
+	s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ gpu.GpuInfoList) {
+		req.successCh <- &runnerRef{llama: &mock}
+	}
+	s.sched.Run(ctx)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	r := gin.New()
+	r.Use(rateLimitMiddleware(s.limits))
+	r.POST("/api/generate", s.GenerateHandler)
+	r.POST("/api/chat", s.ChatHandler)
+
+	for path, req := range map[string]any{
+		"/api/generate": api.GenerateRequest{Model: "test", Prompt: "hello", Stream: &stream},
+		"/api/chat":     api.ChatRequest{Model: "test", Messages: []api.Message{{Role: "user", Content: "hello"}}, Stream: &stream},
+	} {
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(req); err != nil {
+			t.Fatal(err)
+		}
+
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, &b))
+	}
+
+	b := s.limits.budget("ip:192.0.2.1", time.Now())
+	if remaining := b.tokens.remaining(); remaining > 4 {
+		t.Errorf("expected the tokens of cancelled completions to be spent, %d remain", remaining)
+	}
+}
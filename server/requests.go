@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// requestIDHeader is the response header holding the ID of a tracked request
+const requestIDHeader = "X-Request-Id"
+
+// requestIDContextKey is the gin context key holding the ID of a tracked
+// request
+const requestIDContextKey = "requestID"
+
+type inflightRequest struct {
+	info   api.RequestInfo
+	cancel context.CancelFunc
+}
+
+// inflightRequests tracks inference requests from when they are received
+// until their response is complete so they can be listed and cancelled. The
+// zero value is ready to use.
+type inflightRequests struct {
+	mu       sync.Mutex
+	requests map[string]*inflightRequest
+}
+
+func (r *inflightRequests) add(req *inflightRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.requests == nil {
+		r.requests = make(map[string]*inflightRequest)
+	}
+
+	r.requests[req.info.ID] = req
+}
+
+func (r *inflightRequests) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.requests, id)
+}
+
+func (r *inflightRequests) list() []api.RequestInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]api.RequestInfo, 0, len(r.requests))
+	for _, req := range r.requests {
+		infos = append(infos, req.info)
+	}
+
+	slices.SortFunc(infos, func(a, b api.RequestInfo) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+
+	return infos
+}
+
+// cancel cancels the request with id and reports whether it was found
+func (r *inflightRequests) cancel(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	req, ok := r.requests[id]
+	if ok {
+		req.cancel()
+	}
+
+	return ok
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// requestsMiddleware assigns an ID to each inference request and tracks it
+// in requests until it completes
+func requestsMiddleware(requests *inflightRequests) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if routePermissions[c.FullPath()] != permissionInference {
+			c.Next()
+			return
+		}
+
+		client := c.RemoteIP()
+		if name := c.GetString(apiKeyContextKey); name != "" {
+			client = name
+		}
+
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		req := &inflightRequest{
+			info: api.RequestInfo{
+				ID:        newRequestID(),
+				Route:     c.FullPath(),
+				Model:     requestModel(c),
+				Client:    client,
+				StartedAt: time.Now().UTC(),
+			},
+			cancel: cancel,
+		}
+
+		requests.add(req)
+		defer requests.remove(req.info.ID)
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Set(requestIDContextKey, req.info.ID)
+		c.Header(requestIDHeader, req.info.ID)
+		c.Next()
+	}
+}
+
+func (s *Server) ListRequestsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, api.ListRequestsResponse{Requests: s.requests.list()})
+}
+
+func (s *Server) CancelRequestHandler(c *gin.Context) {
+	id := strings.TrimSpace(c.Param("id"))
+	if !s.requests.cancel(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("request '%s' not found", id)})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestInflightRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+	started := make(chan string)
+
+	r := gin.New()
+	r.Use(requestsMiddleware(&s.requests))
+	r.POST("/api/generate", func(c *gin.Context) {
+		started <- c.GetString(requestIDContextKey)
+		<-c.Request.Context().Done()
+		handleErrorResponse(c, c.Request.Context().Err())
+	})
+	r.GET("/api/requests", s.ListRequestsHandler)
+	r.DELETE("/api/requests/:id", s.CancelRequestHandler)
+
+	generate := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.ServeHTTP(generate, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model": "test"}`)))
+	}()
+
+	id := <-started
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/requests", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var list api.ListRequestsResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(list.Requests))
+	}
+
+	if got := list.Requests[0]; got.ID != id || got.Route != "/api/generate" || got.Model != "test:latest" {
+		t.Errorf("unexpected request %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/requests/"+id, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	<-done
+	if generate.Code != 499 {
+		t.Errorf("expected cancelled request to fail with status 499, got %d", generate.Code)
+	}
+
+	if got := generate.Header().Get(requestIDHeader); got != id {
+		t.Errorf("expected request ID header %q, got %q", id, got)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/requests/"+id, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected finished request to be gone, got status %d", w.Code)
+	}
+
+	if len(s.requests.list()) != 0 {
+		t.Errorf("expected no requests, got %v", s.requests.list())
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"cmp"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -39,8 +40,12 @@ import (
 var mode string = gin.DebugMode
 
 type Server struct {
-	addr  net.Addr
-	sched *Scheduler
+	addr    net.Addr
+	sched   *Scheduler
+	apiKeys apiKeys
+	limits  *rateLimiter
+
+	requests inflightRequests
 }
 
 func init() {
@@ -70,6 +75,66 @@ func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options
 	return opts, nil
 }
 
+// modelDraft sets the draft model used for speculative decoding when opts
+// names one, in place of the DRAFT of the Modelfile
+func modelDraft(model *Model, opts api.Options) error {
+	if opts.Draft == "" {
+		return nil
+	}
+
+	draft, err := GetModel(opts.Draft)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("draft model '%s' not found, try pulling it first", opts.Draft)
+	} else if err != nil {
+		return err
+	}
+
+	model.DraftPath = draft.ModelPath
+	return nil
+}
+
+// scheduleRunner loads the named model through the scheduler with the given
+// options and returns its runner once it is ready to serve requests
+func (s *Server) scheduleRunner(ctx context.Context, name string, requestOpts map[string]interface{}, keepAlive *api.Duration) (*runnerRef, error) {
+	model, err := GetModel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := modelOptions(model, requestOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := modelDraft(model, opts); err != nil {
+		return nil, err
+	}
+
+	sessionDuration := getDefaultSessionDuration()
+	if keepAlive != nil {
+		sessionDuration = keepAlive.Duration
+	}
+
+	rCh, eCh := s.sched.GetRunner(ctx, model, opts, sessionDuration)
+	select {
+	case runner := <-rCh:
+		return runner, nil
+	case err := <-eCh:
+		return nil, err
+	}
+}
+
+// handleScheduleError writes the response for an error returned by scheduleRunner
+func handleScheduleError(c *gin.Context, name string, err error) {
+	var pErr *fs.PathError
+	if errors.As(err, &pErr) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found, try pulling it first", name)})
+		return
+	}
+
+	handleErrorResponse(c, err)
+}
+
 func isSupportedImageType(image []byte) bool {
 	contentType := http.DetectContentType(image)
 	allowedTypes := []string{"image/jpeg", "image/jpg", "image/png"}
@@ -95,12 +160,22 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	case req.Model == "":
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
 		return
-	case len(req.Format) > 0 && req.Format != "json":
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "format must be json"})
-		return
 	case req.Raw && (req.Template != "" || req.System != "" || len(req.Context) > 0):
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "raw mode does not support template, system, or context"})
 		return
+	case req.Raw && req.Suffix != "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "raw mode does not support suffix"})
+		return
+	}
+
+	if err := checkLogprobs(req.Logprobs, req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := llm.FormatGrammar(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	for _, img := range req.Images {
@@ -121,17 +196,65 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	setModelLabel(c, model.ShortName)
+
 	if model.IsEmbedding() {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "embedding models do not support generate"})
 		return
 	}
 
+	snapshot, err := findSnapshot(model, req.Snapshot)
+	if err != nil {
+		abortWithSnapshotError(c, req.Snapshot, err)
+		return
+	}
+
+	adapters, err := requestAdapters(model, req.Adapters)
+	if err != nil {
+		abortWithAdapterError(c, err)
+		return
+	}
+
+	if snapshot != "" && len(adapters) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "snapshots do not support adapters"})
+		return
+	}
+
+	if req.Suffix != "" {
+		tmpl := req.Template
+		if tmpl == "" {
+			tmpl = model.Template
+		}
+
+		if !templateHasField(tmpl, "Suffix") {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s does not support insert", req.Model)})
+			return
+		}
+	}
+
 	opts, err := modelOptions(model, req.Options)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := checkGrammar(req.Format, req.Options, &opts); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := modelDraft(model, opts); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(adapters) > 0 {
+		if err := adapterOptions(&opts); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	var sessionDuration time.Duration
 	if req.KeepAlive == nil {
 		sessionDuration = getDefaultSessionDuration()
@@ -139,7 +262,14 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		sessionDuration = req.KeepAlive.Duration
 	}
 
-	rCh, eCh := s.sched.GetRunner(c.Request.Context(), model, opts, sessionDuration)
+	ctx, err := schedulingContext(c, req.Priority)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx = withAdapters(ctx, adapters)
+	rCh, eCh := s.sched.GetRunner(ctx, model, opts, sessionDuration)
 	var runner *runnerRef
 	select {
 	case runner = <-rCh:
@@ -151,7 +281,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	// an empty request loads the model
 	// note: for a short while template was used in lieu
 	// of `raw` mode so we need to check for it too
-	if req.Prompt == "" && req.Template == "" && req.System == "" {
+	if req.Prompt == "" && req.Suffix == "" && req.Template == "" && req.System == "" {
 		c.JSON(http.StatusOK, api.GenerateResponse{
 			CreatedAt:  time.Now().UTC(),
 			Model:      req.Model,
@@ -167,7 +297,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	switch {
 	case req.Raw:
 		prompt = req.Prompt
-	case req.Prompt != "":
+	case req.Prompt != "" || req.Suffix != "":
 		if req.Template == "" {
 			req.Template = model.Template
 		}
@@ -177,6 +307,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		}
 
 		slog.Debug("generate handler", "prompt", req.Prompt)
+		slog.Debug("generate handler", "suffix", req.Suffix)
 		slog.Debug("generate handler", "template", req.Template)
 		slog.Debug("generate handler", "system", req.System)
 
@@ -187,7 +318,11 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 		sb.WriteString(req.Prompt)
 
-		p, err := Prompt(req.Template, req.System, sb.String(), "", true)
+		p, err := renderPrompt(req.Template, map[string]any{
+			"System": req.System,
+			"Prompt": sb.String(),
+			"Suffix": req.Suffix,
+		}, true)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -211,11 +346,17 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 	slog.Debug("generate handler", "prompt", prompt)
 
+	// the request ID is only sent in the first response
+	requestID := c.GetString(requestIDContextKey)
+
 	ch := make(chan any)
 	var generated strings.Builder
 	go func() {
 		defer close(ch)
 
+		// tokens are charged as they are generated so that cancelled
+		// completions are charged too
+		var charged int
 		fn := func(r llm.CompletionResponse) {
 			// Build up the full response
 			if _, err := generated.WriteString(r.Content); err != nil {
@@ -229,20 +370,34 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 				Done:       r.Done,
 				Response:   r.Content,
 				DoneReason: r.DoneReason,
+				RequestID:  requestID,
+				Logprobs:   r.Logprobs,
 				Metrics: api.Metrics{
 					PromptEvalCount:    r.PromptEvalCount,
 					PromptEvalDuration: r.PromptEvalDuration,
+					PromptCacheCount:   r.PromptCacheCount,
 					EvalCount:          r.EvalCount,
 					EvalDuration:       r.EvalDuration,
+					DraftCount:         r.DraftCount,
+					DraftAcceptedCount: r.DraftAcceptedCount,
 				},
 			}
 
 			if r.Done {
 				resp.TotalDuration = time.Since(checkpointStart)
 				resp.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				recordTokens(model.ShortName, resp.Metrics)
+
+				// the final count replaces the count of chunks charged so far
+				spendTokens(c, r.EvalCount-charged)
 
 				if !req.Raw {
-					p, err := Prompt(req.Template, req.System, req.Prompt, generated.String(), false)
+					p, err := renderPrompt(req.Template, map[string]any{
+						"System":   req.System,
+						"Prompt":   req.Prompt,
+						"Suffix":   req.Suffix,
+						"Response": generated.String(),
+					}, false)
 					if err != nil {
 						c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 						return
@@ -257,9 +412,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 					resp.Context = append(req.Context, tokens...)
 				}
+			} else {
+				spendTokens(c, 1)
+				charged++
 			}
 
 			ch <- resp
+			requestID = ""
 		}
 
 		var images []llm.ImageData
@@ -272,13 +431,17 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 		// Start prediction
 		req := llm.CompletionRequest{
-			Prompt:  prompt,
-			Format:  req.Format,
-			Images:  images,
-			Options: opts,
+			Prompt:      prompt,
+			Format:      req.Format,
+			Images:      images,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
+			Snapshot:    snapshot,
+			Adapters:    adapters,
 		}
 		if err := runner.llama.Completion(c.Request.Context(), req, fn); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			ch <- completionError(err)
 		}
 	}()
 
@@ -286,14 +449,21 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		// Accumulate responses into the final response
 		var final api.GenerateResponse
 		var sb strings.Builder
+		var logprobs []api.Logprob
 		for resp := range ch {
 			switch r := resp.(type) {
 			case api.GenerateResponse:
 				sb.WriteString(r.Response)
+				logprobs = append(logprobs, r.Logprobs...)
 				final = r
 			case gin.H:
+				status, ok := r["status"].(int)
+				if !ok {
+					status = http.StatusInternalServerError
+				}
+
 				if errorMsg, ok := r["error"].(string); ok {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
+					c.JSON(status, gin.H{"error": errorMsg})
 					return
 				} else {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error format in response"})
@@ -306,6 +476,8 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		}
 
 		final.Response = sb.String()
+		final.Logprobs = logprobs
+		final.RequestID = c.GetString(requestIDContextKey)
 		c.JSON(http.StatusOK, final)
 		return
 	}
@@ -351,9 +523,22 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	if req.Model == "" {
+	switch {
+	case req.Model == "":
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
 		return
+	case req.Prompt != "" && req.Input != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "prompt and input are mutually exclusive"})
+		return
+	}
+
+	var inputs []embeddingInput
+	if req.Input != nil {
+		inputs, err = embeddingInputs(req.Input)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
 	model, err := GetModel(req.Model)
@@ -367,12 +552,19 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
+	setModelLabel(c, model.ShortName)
+
 	opts, err := modelOptions(model, req.Options)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := modelDraft(model, opts); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var sessionDuration time.Duration
 	if req.KeepAlive == nil {
 		sessionDuration = getDefaultSessionDuration()
@@ -380,7 +572,13 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		sessionDuration = req.KeepAlive.Duration
 	}
 
-	rCh, eCh := s.sched.GetRunner(c.Request.Context(), model, opts, sessionDuration)
+	ctx, err := schedulingContext(c, req.Priority)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rCh, eCh := s.sched.GetRunner(ctx, model, opts, sessionDuration)
 	var runner *runnerRef
 	select {
 	case runner = <-rCh:
@@ -390,24 +588,265 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 	}
 
 	// an empty request loads the model
-	if req.Prompt == "" {
+	if req.Prompt == "" && req.Input == nil {
 		c.JSON(http.StatusOK, api.EmbeddingResponse{Embedding: []float64{}})
 		return
 	}
 
-	embedding, err := runner.llama.Embedding(c.Request.Context(), req.Prompt)
+	if req.Input != nil {
+		// the runner tokenizes the inputs and checks their length in the
+		// same request that embeds them
+		content := make([]any, len(inputs))
+		for i, input := range inputs {
+			content[i] = input.content
+			if input.tokens != nil {
+				content[i] = input.tokens
+			}
+		}
+
+		embedding, err := runner.llama.Embed(c.Request.Context(), llm.EmbeddingRequest{Content: content, Truncate: &req.Truncate})
+		if err != nil {
+			var serr api.StatusError
+			if errors.As(err, &serr) {
+				c.JSON(serr.StatusCode, gin.H{"error": serr.ErrorMessage})
+				return
+			}
+
+			slog.Info(fmt.Sprintf("embedding generation failed: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate embedding"})
+			return
+		}
+
+		if req.Normalize {
+			for _, e := range embedding.Embedding {
+				normalize(e)
+			}
+		}
+
+		var count int
+		for _, n := range embedding.Tokens {
+			count += n
+		}
+
+		c.JSON(http.StatusOK, api.EmbeddingResponse{
+			Embeddings:      embedding.Embedding,
+			PromptEvalCount: count,
+		})
+		return
+	}
+
+	embedding, err := runner.llama.Embed(c.Request.Context(), llm.EmbeddingRequest{Content: []any{req.Prompt}})
 	if err != nil {
 		slog.Info(fmt.Sprintf("embedding generation failed: %v", err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate embedding"})
 		return
 	}
 
+	if req.Normalize {
+		normalize(embedding.Embedding[0])
+	}
+
 	resp := api.EmbeddingResponse{
-		Embedding: embedding,
+		Embedding: embedding.Embedding[0],
 	}
 	c.JSON(http.StatusOK, resp)
 }
 
+// normalize scales vec in place to unit length. Zero vectors are left as is.
+func normalize(vec []float64) {
+	var sum float64
+	for _, v := range vec {
+		sum += v * v
+	}
+
+	if sum == 0 {
+		return
+	}
+
+	norm := math.Sqrt(sum)
+	for i := range vec {
+		vec[i] /= norm
+	}
+}
+
+func (s *Server) TokenizeHandler(c *gin.Context) {
+	var req api.TokenizeRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	ctx, err := schedulingContext(c, "")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	runner, err := s.scheduleRunner(ctx, req.Model, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	setModelLabel(c, req.Model)
+
+	tokens, err := runner.llama.Tokenize(c.Request.Context(), req.Prompt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if tokens == nil {
+		tokens = []int{}
+	}
+
+	resp := api.TokenizeResponse{
+		Model:  req.Model,
+		Tokens: tokens,
+		Count:  len(tokens),
+	}
+
+	if req.Pieces {
+		resp.Pieces, err = runner.llama.Pieces(c.Request.Context(), tokens)
+		if err != nil {
+			handleErrorResponse(c, err)
+			return
+		}
+
+		if resp.Pieces == nil {
+			resp.Pieces = []string{}
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) DetokenizeHandler(c *gin.Context) {
+	var req api.DetokenizeRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	for _, token := range req.Tokens {
+		if token < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid token id %d", token)})
+			return
+		}
+	}
+
+	ctx, err := schedulingContext(c, "")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	runner, err := s.scheduleRunner(ctx, req.Model, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	setModelLabel(c, req.Model)
+
+	// the runner rejects tokens outside the model's vocabulary
+	content, err := runner.llama.Detokenize(c.Request.Context(), req.Tokens)
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, api.DetokenizeResponse{
+		Model:   req.Model,
+		Content: content,
+		Count:   len(req.Tokens),
+	})
+}
+
+type embeddingInput struct {
+	content string
+	tokens  []int
+}
+
+// embeddingInputs normalizes the input of an embedding request, which may be
+// a string, a list of strings, a list of tokens or a list of lists of tokens
+func embeddingInputs(input any) ([]embeddingInput, error) {
+	tokens := func(v []any) ([]int, bool) {
+		ints := make([]int, len(v))
+		for i, t := range v {
+			f, ok := t.(float64)
+			if !ok || f != math.Trunc(f) {
+				return nil, false
+			}
+			ints[i] = int(f)
+		}
+		return ints, true
+	}
+
+	var inputs []embeddingInput
+	switch input := input.(type) {
+	case string:
+		inputs = append(inputs, embeddingInput{content: input})
+	case []any:
+		if len(input) == 0 {
+			break
+		}
+
+		// a flat list of numbers is a single tokenized input
+		if _, ok := input[0].(float64); ok {
+			t, ok := tokens(input)
+			if !ok {
+				return nil, errors.New("invalid input: tokens must be integers")
+			}
+			inputs = append(inputs, embeddingInput{tokens: t})
+			break
+		}
+
+		for _, v := range input {
+			switch v := v.(type) {
+			case string:
+				inputs = append(inputs, embeddingInput{content: v})
+			case []any:
+				t, ok := tokens(v)
+				if !ok || len(t) == 0 {
+					return nil, errors.New("invalid input: tokens must be a non-empty list of integers")
+				}
+				inputs = append(inputs, embeddingInput{tokens: t})
+			default:
+				return nil, fmt.Errorf("invalid input type %T", v)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid input type %T", input)
+	}
+
+	if len(inputs) == 0 {
+		return nil, errors.New("input must not be empty")
+	}
+
+	return inputs, nil
+}
+
 func (s *Server) PullModelHandler(c *gin.Context) {
 	var req api.PullRequest
 	err := c.ShouldBindJSON(&req)
@@ -447,6 +886,8 @@ func (s *Server) PullModelHandler(c *gin.Context) {
 
 		if err := PullModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
+		} else {
+			setModelLabel(c, name.DisplayShortest())
 		}
 	}()
 
@@ -496,6 +937,8 @@ func (s *Server) PushModelHandler(c *gin.Context) {
 
 		if err := PushModel(ctx, model, regOpts, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
+		} else {
+			setModelLabel(c, model)
 		}
 	}()
 
@@ -579,6 +1022,8 @@ func (s *Server) CreateModelHandler(c *gin.Context) {
 		quantization := cmp.Or(r.Quantize, r.Quantization)
 		if err := CreateModel(ctx, name, filepath.Dir(r.Path), strings.ToUpper(quantization), f, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
+		} else {
+			setModelLabel(c, name.DisplayShortest())
 		}
 	}()
 
@@ -621,6 +1066,10 @@ func (s *Server) DeleteModelHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	if err := pruneSnapshots(); err != nil {
+		slog.Warn("couldn't prune snapshots", "error", err)
+	}
 }
 
 func (s *Server) ShowModelHandler(c *gin.Context) {
@@ -657,6 +1106,7 @@ func (s *Server) ShowModelHandler(c *gin.Context) {
 		return
 	}
 
+	setModelLabel(c, req.Model)
 	c.JSON(http.StatusOK, resp)
 }
 
@@ -707,6 +1157,12 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		ModifiedAt: manifest.fi.ModTime(),
 	}
 
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == "application/vnd.ollama.image.adapter" {
+			resp.Adapters = append(resp.Adapters, api.AdapterDetails{Digest: layer.Digest, Scale: cmp.Or(layer.Scale, 1)})
+		}
+	}
+
 	var params []string
 	cs := 30
 	for k, v := range m.Options {
@@ -973,23 +1429,30 @@ func (s *Server) GenerateRoutes() http.Handler {
 	config := cors.DefaultConfig()
 	config.AllowWildcard = true
 	config.AllowBrowserExtensions = true
-	config.AllowHeaders = []string{"Authorization", "Content-Type", "User-Agent", "Accept", "X-Requested-With"}
+	config.AllowHeaders = []string{"Authorization", "Content-Type", "User-Agent", "Accept", "X-Requested-With", priorityHeader}
 	openAIProperties := []string{"lang", "package-version", "os", "arch", "runtime", "runtime-version", "async"}
 	for _, prop := range openAIProperties {
 		config.AllowHeaders = append(config.AllowHeaders, "x-stainless-"+prop)
 	}
 	config.AllowOrigins = envconfig.AllowOrigins
+	config.ExposeHeaders = []string{requestIDHeader, "Retry-After", "X-RateLimit-Limit-Requests", "X-RateLimit-Remaining-Requests", "X-RateLimit-Limit-Tokens", "X-RateLimit-Remaining-Tokens"}
 
 	r := gin.Default()
 	r.Use(
 		cors.New(config),
 		allowedHostsMiddleware(s.addr),
+		authMiddleware(s.apiKeys),
+		metricsMiddleware(),
+		rateLimitMiddleware(s.limits),
+		requestsMiddleware(&s.requests),
 	)
 
 	r.POST("/api/pull", s.PullModelHandler)
 	r.POST("/api/generate", s.GenerateHandler)
 	r.POST("/api/chat", s.ChatHandler)
 	r.POST("/api/embeddings", s.EmbeddingsHandler)
+	r.POST("/api/tokenize", s.TokenizeHandler)
+	r.POST("/api/detokenize", s.DetokenizeHandler)
 	r.POST("/api/create", s.CreateModelHandler)
 	r.POST("/api/push", s.PushModelHandler)
 	r.POST("/api/copy", s.CopyModelHandler)
@@ -998,9 +1461,19 @@ func (s *Server) GenerateRoutes() http.Handler {
 	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
 	r.GET("/api/ps", s.ProcessHandler)
+	r.POST("/api/load", s.LoadHandler)
+	r.POST("/api/unload", s.UnloadHandler)
+	r.POST("/api/snapshot", s.SnapshotHandler)
+	r.GET("/api/requests", s.ListRequestsHandler)
+	r.DELETE("/api/requests/:id", s.CancelRequestHandler)
+	r.GET("/metrics", s.MetricsHandler)
 
 	// Compatibility endpoints
-	r.POST("/v1/chat/completions", openai.Middleware(), s.ChatHandler)
+	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbeddingsHandler)
+	r.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
+	r.GET("/v1/models", openai.ListMiddleware(), s.ListModelsHandler)
+	r.GET("/v1/models/*model", openai.RetrieveMiddleware(), s.ShowModelHandler)
 
 	for _, method := range []string{http.MethodGet, http.MethodHead} {
 		r.Handle(method, "/", func(c *gin.Context) {
@@ -1060,12 +1533,42 @@ func Serve(ln net.Listener) error {
 		if err := PruneDirectory(manifestsPath); err != nil {
 			return err
 		}
+
+		if err := pruneSnapshots(); err != nil {
+			return err
+		}
+	}
+
+	var keys apiKeys
+	if envconfig.APIKeysFile != "" {
+		var err error
+		keys, err = loadAPIKeys(envconfig.APIKeysFile)
+		if err != nil {
+			return fmt.Errorf("loading API keys: %w", err)
+		}
+
+		slog.Info("API key authentication enabled", "keys", len(keys))
+	}
+
+	preload, err := parsePreload(envconfig.Preload)
+	if err != nil {
+		return err
+	}
+
+	tlsConf, err := tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	if tlsConf != nil {
+		ln = tls.NewListener(ln, tlsConf)
+		slog.Info("TLS enabled", "client_certificates", tlsConf.ClientAuth == tls.RequireAndVerifyClientCert)
 	}
 
 	ctx, done := context.WithCancel(context.Background())
 	schedCtx, schedDone := context.WithCancel(ctx)
 	sched := InitScheduler(schedCtx)
-	s := &Server{addr: ln.Addr(), sched: sched}
+	s := &Server{addr: ln.Addr(), sched: sched, apiKeys: keys, limits: newRateLimiter(envconfig.RateLimitRequests, envconfig.RateLimitTokens)}
 	r := s.GenerateRoutes()
 
 	slog.Info(fmt.Sprintf("Listening on %s (version %s)", ln.Addr(), version.Version))
@@ -1096,6 +1599,9 @@ func Serve(ln net.Listener) error {
 	gpus := gpu.GetGPUInfo()
 	gpus.LogDetails()
 
+	// load models in the background so the API is available while they load
+	go s.sched.Preload(schedCtx, preload)
+
 	err = srvr.Serve(ln)
 	// If server is closed from the signal handler, wait for the ctx to be done
 	// otherwise error out quickly
@@ -1132,11 +1638,28 @@ func waitForStream(c *gin.Context, ch chan interface{}) {
 }
 
 func streamResponse(c *gin.Context, ch chan any) {
+	val, ok := <-ch
+	if !ok {
+		return
+	}
+
+	// an error with a status that arrives before anything has been streamed
+	// is returned as a regular error response
+	if r, ok := val.(gin.H); ok {
+		if status, ok := r["status"].(int); ok {
+			c.JSON(status, gin.H{"error": r["error"]})
+			return
+		}
+	}
+
 	c.Header("Content-Type", "application/x-ndjson")
 	c.Stream(func(w io.Writer) bool {
-		val, ok := <-ch
-		if !ok {
-			return false
+		// once streaming, the status can no longer be sent so errors end
+		// the stream like any other
+		if r, ok := val.(gin.H); ok {
+			if _, ok := r["status"]; ok {
+				val = gin.H{"error": r["error"]}
+			}
 		}
 
 		bts, err := json.Marshal(val)
@@ -1152,10 +1675,79 @@ func streamResponse(c *gin.Context, ch chan any) {
 			return false
 		}
 
-		return true
+		val, ok = <-ch
+		return ok
 	})
 }
 
+func (s *Server) LoadHandler(c *gin.Context) {
+	var req api.LoadRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	runner, err := s.scheduleRunner(withLoad(c.Request.Context()), req.Model, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	setModelLabel(c, req.Model)
+
+	// the request holds a reference to the runner until it returns, so it
+	// can't be unloaded before it is pinned
+	runner.refMu.Lock()
+	runner.pinned = req.Pinned
+	runner.refMu.Unlock()
+
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) UnloadHandler(c *gin.Context) {
+	var req api.UnloadRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	model, err := GetModel(req.Model)
+	if err != nil {
+		var pErr *fs.PathError
+		if errors.As(err, &pErr) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setModelLabel(c, model.ShortName)
+	s.sched.Unload(model)
+	c.Status(http.StatusOK)
+}
+
 func (s *Server) ProcessHandler(c *gin.Context) {
 	models := []api.ProcessModelResponse{}
 
@@ -1186,19 +1778,27 @@ func (s *Server) ProcessHandler(c *gin.Context) {
 			mr.ExpiresAt = time.Now().Add(v.sessionDuration)
 		}
 
+		// pinned models don't expire
+		v.refMu.Lock()
+		if v.pinned {
+			mr.Pinned = true
+			mr.ExpiresAt = time.Time{}
+		}
+		v.refMu.Unlock()
+
 		models = append(models, mr)
 	}
 
-	c.JSON(http.StatusOK, api.ProcessResponse{Models: models})
+	c.JSON(http.StatusOK, api.ProcessResponse{Models: models, PreloadErrors: s.sched.preloadFailures()})
 }
 
 // ChatPrompt builds up a prompt from a series of messages for the currently `loaded` model
-func chatPrompt(ctx context.Context, runner *runnerRef, template string, messages []api.Message, numCtx int) (string, error) {
+func chatPrompt(ctx context.Context, runner *runnerRef, template string, messages []api.Message, tools api.Tools, numCtx int) (string, error) {
 	encode := func(s string) ([]int, error) {
 		return runner.llama.Tokenize(ctx, s)
 	}
 
-	prompt, err := ChatPrompt(template, messages, numCtx, encode)
+	prompt, err := ChatPrompt(template, messages, tools, numCtx, encode)
 	if err != nil {
 		return "", err
 	}
@@ -1225,8 +1825,15 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	case req.Model == "":
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
 		return
-	case len(req.Format) > 0 && req.Format != "json":
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "format must be json"})
+	}
+
+	if err := checkLogprobs(req.Logprobs, req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := llm.FormatGrammar(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -1241,17 +1848,58 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	setModelLabel(c, model.ShortName)
+
 	if model.IsEmbedding() {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "embedding models do not support chat"})
 		return
 	}
 
+	snapshot, err := findSnapshot(model, req.Snapshot)
+	if err != nil {
+		abortWithSnapshotError(c, req.Snapshot, err)
+		return
+	}
+
+	adapters, err := requestAdapters(model, req.Adapters)
+	if err != nil {
+		abortWithAdapterError(c, err)
+		return
+	}
+
+	if snapshot != "" && len(adapters) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "snapshots do not support adapters"})
+		return
+	}
+
+	if len(req.Tools) > 0 && !templateHasField(model.Template, "Tools") {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s does not support tools", req.Model)})
+		return
+	}
+
 	opts, err := modelOptions(model, req.Options)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := checkGrammar(req.Format, req.Options, &opts); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := modelDraft(model, opts); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(adapters) > 0 {
+		if err := adapterOptions(&opts); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	var sessionDuration time.Duration
 	if req.KeepAlive == nil {
 		sessionDuration = getDefaultSessionDuration()
@@ -1259,7 +1907,14 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		sessionDuration = req.KeepAlive.Duration
 	}
 
-	rCh, eCh := s.sched.GetRunner(c.Request.Context(), model, opts, sessionDuration)
+	ctx, err := schedulingContext(c, req.Priority)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx = withAdapters(ctx, adapters)
+	rCh, eCh := s.sched.GetRunner(ctx, model, opts, sessionDuration)
 	var runner *runnerRef
 	select {
 	case runner = <-rCh:
@@ -1280,7 +1935,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		}, req.Messages...)
 	}
 
-	prompt, err := chatPrompt(c.Request.Context(), runner, model.Template, req.Messages, opts.NumCtx)
+	prompt, err := chatPrompt(c.Request.Context(), runner, model.Template, req.Messages, req.Tools, opts.NumCtx)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -1318,11 +1973,30 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 	slog.Debug("chat handler", "prompt", prompt, "images", len(images))
 
+	// the request ID is only sent in the first response
+	requestID := c.GetString(requestIDContextKey)
+
 	ch := make(chan any)
 
 	go func() {
 		defer close(ch)
 
+		// tokens are charged as they are generated so that cancelled
+		// completions are charged too
+		var charged int
+
+		// when tools are given, content is held back for as long as the
+		// response could be a tool call, so that tool calls aren't also
+		// streamed as text. Tool calls are parsed from the held content and
+		// sent with the final chunk.
+		var prefix string
+		holding := len(req.Tools) > 0
+		if holding {
+			prefix, _, _, holding = toolCallFormat(model.Template)
+		}
+
+		var held strings.Builder
+		var heldLogprobs []api.Logprob
 		fn := func(r llm.CompletionResponse) {
 			resp := api.ChatResponse{
 				Model:      req.Model,
@@ -1330,29 +2004,70 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				Message:    api.Message{Role: "assistant", Content: r.Content},
 				Done:       r.Done,
 				DoneReason: r.DoneReason,
+				RequestID:  requestID,
+				Logprobs:   r.Logprobs,
 				Metrics: api.Metrics{
 					PromptEvalCount:    r.PromptEvalCount,
 					PromptEvalDuration: r.PromptEvalDuration,
+					PromptCacheCount:   r.PromptCacheCount,
 					EvalCount:          r.EvalCount,
 					EvalDuration:       r.EvalDuration,
+					DraftCount:         r.DraftCount,
+					DraftAcceptedCount: r.DraftAcceptedCount,
 				},
 			}
 
 			if r.Done {
 				resp.TotalDuration = time.Since(checkpointStart)
 				resp.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				recordTokens(model.ShortName, resp.Metrics)
+
+				// the final count replaces the count of chunks charged so far
+				spendTokens(c, r.EvalCount-charged)
+			} else {
+				spendTokens(c, 1)
+				charged++
+			}
+
+			if holding {
+				held.WriteString(r.Content)
+				heldLogprobs = append(heldLogprobs, r.Logprobs...)
+
+				switch {
+				case r.Done:
+					if toolCalls, ok := parseToolCalls(model.Template, held.String()); ok {
+						resp.Message.ToolCalls = toolCalls
+						resp.Message.Content = ""
+					} else {
+						resp.Message.Content = held.String()
+					}
+				case mayBeToolCall(prefix, held.String()):
+					return
+				default:
+					// not a tool call, send what was held back and stream
+					// the rest as it's generated
+					resp.Message.Content = held.String()
+					holding = false
+				}
+
+				resp.Logprobs = heldLogprobs
 			}
 
 			ch <- resp
+			requestID = ""
 		}
 
 		if err := runner.llama.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Format:  req.Format,
-			Images:  images,
-			Options: opts,
+			Prompt:      prompt,
+			Format:      req.Format,
+			Images:      images,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
+			Snapshot:    snapshot,
+			Adapters:    adapters,
 		}, fn); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			ch <- completionError(err)
 		}
 	}()
 
@@ -1360,14 +2075,21 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		// Accumulate responses into the final response
 		var final api.ChatResponse
 		var sb strings.Builder
+		var logprobs []api.Logprob
 		for resp := range ch {
 			switch r := resp.(type) {
 			case api.ChatResponse:
 				sb.WriteString(r.Message.Content)
+				logprobs = append(logprobs, r.Logprobs...)
 				final = r
 			case gin.H:
+				status, ok := r["status"].(int)
+				if !ok {
+					status = http.StatusInternalServerError
+				}
+
 				if errorMsg, ok := r["error"].(string); ok {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
+					c.JSON(status, gin.H{"error": errorMsg})
 					return
 				} else {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error format in response"})
@@ -1379,7 +2101,10 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			}
 		}
 
-		final.Message = api.Message{Role: "assistant", Content: sb.String()}
+		final.Message = api.Message{Role: "assistant", Content: sb.String(), ToolCalls: final.Message.ToolCalls}
+		final.Logprobs = logprobs
+		final.RequestID = c.GetString(requestIDContextKey)
+
 		c.JSON(http.StatusOK, final)
 		return
 	}
@@ -1387,14 +2112,69 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	streamResponse(c, ch)
 }
 
+// maxTopLogprobs is the largest number of alternatives that can be requested
+// for each generated token
+const maxTopLogprobs = 20
+
+func checkLogprobs(logprobs bool, topLogprobs int) error {
+	switch {
+	case topLogprobs < 0 || topLogprobs > maxTopLogprobs:
+		return fmt.Errorf("top_logprobs must be between 0 and %d", maxTopLogprobs)
+	case topLogprobs > 0 && !logprobs:
+		return errors.New("top_logprobs requires logprobs")
+	}
+
+	return nil
+}
+
+// completionError converts an error from a runner into a response, keeping
+// the status code of errors the runner attributes to the request
+func completionError(err error) gin.H {
+	if errors.Is(err, context.Canceled) {
+		return gin.H{"error": "request canceled", "status": 499}
+	}
+
+	var serr api.StatusError
+	if errors.As(err, &serr) {
+		return gin.H{"error": serr.ErrorMessage, "status": serr.StatusCode}
+	}
+
+	return gin.H{"error": err.Error()}
+}
+
+// checkGrammar validates the raw grammar in opts. A grammar in the request
+// options replaces the one derived from format so the two cannot be
+// combined, while format replaces a grammar set in the Modelfile.
+func checkGrammar(format json.RawMessage, requestOpts map[string]any, opts *api.Options) error {
+	if opts.Grammar == "" {
+		return nil
+	}
+
+	if g, _ := llm.FormatGrammar(format); g != "" {
+		if grammar, _ := requestOpts["grammar"].(string); grammar != "" {
+			return errors.New("format and grammar cannot be used together")
+		}
+
+		opts.Grammar = ""
+		return nil
+	}
+
+	return llm.ValidateGrammar(opts.Grammar)
+}
+
 func handleErrorResponse(c *gin.Context, err error) {
 	if errors.Is(err, context.Canceled) {
 		c.JSON(499, gin.H{"error": "request canceled"})
 		return
 	}
-	if errors.Is(err, ErrMaxQueue) {
+	if errors.Is(err, ErrMaxQueue) || errors.Is(err, errAllPinned) || errors.Is(err, errPinnedOptions) {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
 		return
 	}
+	var serr api.StatusError
+	if errors.As(err, &serr) {
+		c.JSON(serr.StatusCode, gin.H{"error": serr.ErrorMessage})
+		return
+	}
 	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -11,11 +13,13 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/gpu"
 	"github.com/ollama/ollama/llm"
 )
 
@@ -37,6 +41,74 @@ func createBinFile(t *testing.T, kv map[string]any, ti []llm.Tensor) string {
 	return f.Name()
 }
 
+func createAdapterFile(t *testing.T, r uint32) string {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// a ggla header without tensors: magic, version, rank and alpha
+	for _, v := range []uint32{llm.FILE_MAGIC_GGLA, 1, r, r} {
+		if err := binary.Write(f, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return f.Name()
+}
+
+// createPEFTFile writes a zipped PEFT adapter for the q projection of the
+// first layer of a model with an embedding length of n
+func createPEFTFile(t *testing.T, n, r uint64) string {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	prefix := "base_model.model.model.layers.0.self_attn.q_proj"
+	header, err := json.Marshal(map[string]any{
+		prefix + ".lora_A.weight": map[string]any{"dtype": "F32", "shape": []uint64{r, n}, "data_offsets": []uint64{0, r * n * 4}},
+		prefix + ".lora_B.weight": map[string]any{"dtype": "F32", "shape": []uint64{n, r}, "data_offsets": []uint64{r * n * 4, 2 * r * n * 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var st bytes.Buffer
+	for _, v := range []any{uint64(len(header)), header, make([]float32, 2*r*n)} {
+		if err := binary.Write(&st, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := zip.NewWriter(f)
+	for name, b := range map[string][]byte{
+		"adapter_config.json":       []byte(fmt.Sprintf(`{"peft_type": "LORA", "r": %d, "lora_alpha": 16}`, r)),
+		"adapter_model.safetensors": st.Bytes(),
+	} {
+		zf, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := zf.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
 type responseRecorder struct {
 	*httptest.ResponseRecorder
 	http.CloseNotifier
@@ -568,3 +640,257 @@ func TestCreateDetectTemplate(t *testing.T) {
 		})
 	})
 }
+
+func TestCreateDraft(t *testing.T) {
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	envconfig.LoadConfig()
+	var s Server
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "draft",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{"general.name": "draft"}, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nDRAFT draft", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	draft, err := GetModel("draft")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.DraftPath != draft.ModelPath {
+		t.Errorf("expected draft %s, actual %s", draft.ModelPath, m.DraftPath)
+	}
+
+	// the draft model is inherited and can be replaced
+	other := createBinFile(t, llm.KV{"general.name": "other"}, nil)
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test2",
+		Modelfile: fmt.Sprintf("FROM test\nDRAFT %s", other),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err = GetModel("test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.DraftPath == "" || m.DraftPath == draft.ModelPath || m.DraftPath == m.ModelPath {
+		t.Errorf("expected draft to be replaced, actual %s", m.DraftPath)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test3",
+		Modelfile: "FROM test\nDRAFT ./missing.gguf",
+		Stream:    &stream,
+	})
+
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected an error for a missing draft model")
+	}
+}
+
+func TestRequestDraft(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	drafts := make(chan string, 1)
+
+	s := Server{sched: InitScheduler(ctx)}
+	s.sched.getCpuFn = func() gpu.GpuInfoList { return gpu.GpuInfoList{{Library: "cpu"}} }
+	s.sched.getGpuFn = s.sched.getCpuFn
+	s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ gpu.GpuInfoList) {
+		drafts <- req.model.DraftPath
+		req.successCh <- &runnerRef{llama: &mockLlm{}}
+	}
+	s.sched.Run(ctx)
+
+	for _, name := range []string{"draft", "test"} {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      name,
+			Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{"general.name": name}, nil)),
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	draft, err := GetModel("draft")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := map[string]any{"draft": "draft"}
+	for name, handler := range map[string]func() *httptest.ResponseRecorder{
+		"generate": func() *httptest.ResponseRecorder {
+			return createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Options: opts})
+		},
+		"chat": func() *httptest.ResponseRecorder {
+			return createRequest(t, s.ChatHandler, api.ChatRequest{Model: "test", Options: opts})
+		},
+		"embeddings": func() *httptest.ResponseRecorder {
+			return createRequest(t, s.EmbeddingsHandler, api.EmbeddingRequest{Model: "test", Options: opts})
+		},
+	} {
+		if w := handler(); w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code 200, actual %d: %s", name, w.Code, w.Body.String())
+		}
+
+		if path := <-drafts; path != draft.ModelPath {
+			t.Errorf("%s: expected draft %s, actual %s", name, draft.ModelPath, path)
+		}
+	}
+
+	w := createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Options: map[string]any{"draft": "missing"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code 400 for a missing draft model, actual %d", w.Code)
+	}
+}
+
+func TestCreateAdapters(t *testing.T) {
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	envconfig.LoadConfig()
+	var s Server
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nADAPTER %s\nADAPTER %s 0.5", createBinFile(t, nil, nil), createAdapterFile(t, 8), createAdapterFile(t, 16)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	// adapters are inherited with their scale
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test2",
+		Modelfile: "FROM test",
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	for _, name := range []string{"test", "test2"} {
+		m, err := GetModel(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(m.Adapters) != 2 || m.Adapters[0].Scale != 1 || m.Adapters[1].Scale != 0.5 || m.Adapters[0].Path == m.Adapters[1].Path {
+			t.Errorf("%s: unexpected adapters %v", name, m.Adapters)
+		}
+
+		resp, err := GetModelInfo(api.ShowRequest{Model: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Adapters) != 2 || resp.Adapters[0].Scale != 1 || resp.Adapters[1].Scale != 0.5 {
+			t.Errorf("%s: unexpected adapters %v", name, resp.Adapters)
+		}
+
+		if !strings.Contains(resp.Modelfile, fmt.Sprintf("ADAPTER %s 0.5\n", m.Adapters[1].Path)) {
+			t.Errorf("%s: expected scaled adapter in Modelfile, got %s", name, resp.Modelfile)
+		}
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test3",
+		Modelfile: fmt.Sprintf("FROM test\nADAPTER %s 0", createAdapterFile(t, 32)),
+		Stream:    &stream,
+	})
+
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected an error for an adapter scale of 0")
+	}
+}
+
+func TestCreatePEFTAdapter(t *testing.T) {
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	envconfig.LoadConfig()
+	var s Server
+
+	base := func(n uint64) string {
+		return createBinFile(t, llm.KV{
+			"general.architecture":          "llama",
+			"llama.attention.head_count":    uint32(2),
+			"llama.attention.head_count_kv": uint32(2),
+		}, []llm.Tensor{
+			{Name: "blk.0.attn_q.weight", Shape: []uint64{n, n}, WriterTo: bytes.NewReader(make([]byte, n*n*4))},
+		})
+	}
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nADAPTER %s", base(8), createPEFTFile(t, 8, 2)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Adapters) != 1 {
+		t.Fatalf("expected 1 adapter, got %v", m.Adapters)
+	}
+
+	ggml, err := llm.LoadModel(m.Adapters[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ggml.Name() != "ggla" || len(ggml.Tensors()) != 2 {
+		t.Errorf("expected a ggla adapter with 2 tensors, got %s with %d", ggml.Name(), len(ggml.Tensors()))
+	}
+
+	// the adapter doesn't fit a base model of another size
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test2",
+		Modelfile: fmt.Sprintf("FROM %s\nADAPTER %s", base(16), createPEFTFile(t, 8, 2)),
+		Stream:    &stream,
+	})
+
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected an error for an adapter of another base model")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -105,3 +106,52 @@ func TestDeleteDuplicateLayers(t *testing.T) {
 
 	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), []string{})
 }
+
+func TestDeleteSnapshots(t *testing.T) {
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	envconfig.LoadConfig()
+
+	var s Server
+
+	for _, name := range []string{"test", "test2"} {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      name,
+			Modelfile: fmt.Sprintf("FROM %s\nSYSTEM %s", createBinFile(t, nil, nil), name),
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(p, "snapshots"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshots []string
+	for _, name := range []string{"test", "test2"} {
+		m, err := GetModel(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path, err := GetSnapshotPath(m.Digest, snapshotDigest("a long document"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		snapshots = append(snapshots, path)
+	}
+
+	w := createRequest(t, s.DeleteModelHandler, api.DeleteRequest{Name: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	checkFileExists(t, filepath.Join(p, "snapshots", "*"), snapshots[1:])
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestGrammar(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mock := mockLlm{completionResp: api.StatusError{StatusCode: http.StatusBadRequest, ErrorMessage: "failed to parse grammar"}}
+
+	s := Server{sched: InitScheduler(ctx)}
+	s.sched.getCpuFn = func() gpu.GpuInfoList { return gpu.GpuInfoList{{Library: "cpu"}} }
+	s.sched.getGpuFn = s.sched.getCpuFn
+	s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ gpu.GpuInfoList) {
+		req.successCh <- &runnerRef{llama: &mock}
+	}
+	s.sched.Run(ctx)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	cases := []struct {
+		name   string
+		format json.RawMessage
+		opts   map[string]any
+		stream bool
+		want   string
+	}{
+		{
+			name: "undefined rule",
+			opts: map[string]any{"grammar": `root ::= answer`},
+			want: `invalid grammar: line 1: undefined rule "answer"`,
+		},
+		{
+			name:   "format and grammar",
+			format: json.RawMessage(`"json"`),
+			opts:   map[string]any{"grammar": `root ::= "yes" | "no"`},
+			want:   "format and grammar cannot be used together",
+		},
+		{
+			name: "runner error",
+			opts: map[string]any{"grammar": `root ::= "yes" | "no"`},
+			want: "failed to parse grammar",
+		},
+		{
+			name:   "runner error streaming",
+			opts:   map[string]any{"grammar": `root ::= "yes" | "no"`},
+			stream: true,
+			want:   "failed to parse grammar",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := tt.stream
+			for name, w := range map[string]*httptest.ResponseRecorder{
+				"generate": createRequest(t, s.GenerateHandler, api.GenerateRequest{
+					Model:   "test",
+					Prompt:  "yes or no?",
+					Format:  tt.format,
+					Options: tt.opts,
+					Stream:  &stream,
+				}),
+				"chat": createRequest(t, s.ChatHandler, api.ChatRequest{
+					Model:    "test",
+					Messages: []api.Message{{Role: "user", Content: "yes or no?"}},
+					Format:   tt.format,
+					Options:  tt.opts,
+					Stream:   &stream,
+				}),
+			} {
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("%s: expected status 400, got %d: %s", name, w.Code, w.Body.String())
+				}
+
+				var resp struct {
+					Error string `json:"error"`
+				}
+
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if resp.Error != tt.want {
+					t.Errorf("%s: expected error %q, got %q", name, tt.want, resp.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestGrammarModelfile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mock mockLlm
+
+	s := Server{sched: InitScheduler(ctx)}
+	s.sched.getCpuFn = func() gpu.GpuInfoList { return gpu.GpuInfoList{{Library: "cpu"}} }
+	s.sched.getGpuFn = s.sched.getCpuFn
+	s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ gpu.GpuInfoList) {
+		req.successCh <- &runnerRef{llama: &mock}
+	}
+	s.sched.Run(ctx)
+
+	grammar := `root ::= [0-9]+`
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nPARAMETER grammar %q", createBinFile(t, nil, nil), grammar),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	cases := []struct {
+		name   string
+		format json.RawMessage
+		want   string
+	}{
+		{name: "grammar", want: grammar},
+		{name: "format replaces grammar", format: json.RawMessage(`"json"`)},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, w := range map[string]*httptest.ResponseRecorder{
+				"generate": createRequest(t, s.GenerateHandler, api.GenerateRequest{
+					Model:  "test",
+					Prompt: "yes or no?",
+					Format: tt.format,
+					Stream: &stream,
+				}),
+				"chat": createRequest(t, s.ChatHandler, api.ChatRequest{
+					Model:    "test",
+					Messages: []api.Message{{Role: "user", Content: "yes or no?"}},
+					Format:   tt.format,
+					Stream:   &stream,
+				}),
+			} {
+				if w.Code != http.StatusOK {
+					t.Fatalf("%s: expected status 200, got %d: %s", name, w.Code, w.Body.String())
+				}
+
+				req := mock.completionReqs[len(mock.completionReqs)-1]
+				if req.Options.Grammar != tt.want {
+					t.Errorf("%s: expected grammar %q, got %q", name, tt.want, req.Options.Grammar)
+				}
+
+				if string(req.Format) != string(tt.format) {
+					t.Errorf("%s: expected format %s, got %s", name, tt.format, req.Format)
+				}
+			}
+		})
+	}
+}
@@ -14,11 +14,15 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/openai"
 	"github.com/ollama/ollama/parser"
 	"github.com/ollama/ollama/types/model"
 	"github.com/ollama/ollama/version"
@@ -214,6 +218,132 @@ func Test_Routes(t *testing.T) {
 				assert.Equal(t, expectedParams, params)
 			},
 		},
+		{
+			Name:   "Generate Handler (suffix unsupported)",
+			Method: http.MethodPost,
+			Path:   "/api/generate",
+			Setup: func(t *testing.T, req *http.Request) {
+				generateReq := api.GenerateRequest{
+					Model:  "show-model",
+					Prompt: "def add(",
+					Suffix: "    return c",
+				}
+				jsonData, err := json.Marshal(generateReq)
+				require.NoError(t, err)
+				req.Body = io.NopCloser(bytes.NewReader(jsonData))
+			},
+			Expected: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, `{"error":"show-model does not support insert"}`, string(body))
+			},
+		},
+		{
+			Name:   "Chat Handler (unsupported schema)",
+			Method: http.MethodPost,
+			Path:   "/api/chat",
+			Setup: func(t *testing.T, req *http.Request) {
+				chatReq := api.ChatRequest{
+					Model:    "show-model",
+					Messages: []api.Message{{Role: "user", Content: "hello"}},
+					Format:   json.RawMessage(`{"type": "string", "pattern": "^[a-z]+$"}`),
+				}
+				jsonData, err := json.Marshal(chatReq)
+				require.NoError(t, err)
+				req.Body = io.NopCloser(bytes.NewReader(jsonData))
+			},
+			Expected: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, `{"error":"unsupported schema keyword \"pattern\""}`, string(body))
+			},
+		},
+		{
+			Name:   "OpenAI Completions Handler (suffix unsupported)",
+			Method: http.MethodPost,
+			Path:   "/v1/completions",
+			Setup: func(t *testing.T, req *http.Request) {
+				completionReq := openai.CompletionRequest{
+					Model:  "show-model",
+					Prompt: "def add(",
+					Suffix: "    return c",
+				}
+				jsonData, err := json.Marshal(completionReq)
+				require.NoError(t, err)
+				req.Body = io.NopCloser(bytes.NewReader(jsonData))
+			},
+			Expected: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				var errResp openai.ErrorResponse
+				err = json.Unmarshal(body, &errResp)
+				require.NoError(t, err)
+				assert.Equal(t, "invalid_request_error", errResp.Error.Type)
+				assert.Equal(t, "show-model does not support insert", errResp.Error.Message)
+			},
+		},
+		{
+			Name:   "OpenAI List Models Handler",
+			Method: http.MethodGet,
+			Path:   "/v1/models",
+			Expected: func(t *testing.T, resp *http.Response) {
+				contentType := resp.Header.Get("Content-Type")
+				assert.Equal(t, "application/json", contentType)
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				var modelList openai.ListCompletion
+				err = json.Unmarshal(body, &modelList)
+				require.NoError(t, err)
+
+				assert.Equal(t, "list", modelList.Object)
+				assert.NotEmpty(t, modelList.Data)
+				for _, m := range modelList.Data {
+					assert.Equal(t, "model", m.Object)
+					assert.Equal(t, "library", m.OwnedBy)
+					assert.NotZero(t, m.Created)
+				}
+			},
+		},
+		{
+			Name:   "OpenAI Retrieve Model Handler",
+			Method: http.MethodGet,
+			Path:   "/v1/models/show-model",
+			Expected: func(t *testing.T, resp *http.Response) {
+				contentType := resp.Header.Get("Content-Type")
+				assert.Equal(t, "application/json", contentType)
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				var retrieveResp openai.Model
+				err = json.Unmarshal(body, &retrieveResp)
+				require.NoError(t, err)
+
+				assert.Equal(t, "show-model", retrieveResp.Id)
+				assert.Equal(t, "model", retrieveResp.Object)
+				assert.Equal(t, "library", retrieveResp.OwnedBy)
+				assert.NotZero(t, retrieveResp.Created)
+			},
+		},
+		{
+			Name:   "OpenAI Retrieve Model Handler (not found)",
+			Method: http.MethodGet,
+			Path:   "/v1/models/someone/missing-model",
+			Expected: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				var errResp openai.ErrorResponse
+				err = json.Unmarshal(body, &errResp)
+				require.NoError(t, err)
+				assert.Equal(t, "not_found_error", errResp.Error.Type)
+			},
+		},
 	}
 
 	t.Setenv("OLLAMA_MODELS", t.TempDir())
@@ -325,3 +455,350 @@ func TestCase(t *testing.T) {
 		})
 	}
 }
+
+func TestEmbeddingInputs(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  []embeddingInput
+		err   bool
+	}{
+		{"string", `"hello"`, []embeddingInput{{content: "hello"}}, false},
+		{"strings", `["hello", "world"]`, []embeddingInput{{content: "hello"}, {content: "world"}}, false},
+		{"tokens", `[1, 2, 3]`, []embeddingInput{{tokens: []int{1, 2, 3}}}, false},
+		{"token lists", `[[1, 2], [3]]`, []embeddingInput{{tokens: []int{1, 2}}, {tokens: []int{3}}}, false},
+		{"mixed", `["hello", [1, 2]]`, []embeddingInput{{content: "hello"}, {tokens: []int{1, 2}}}, false},
+		{"empty list", `[]`, nil, true},
+		{"fractional tokens", `[1.5]`, nil, true},
+		{"empty token list", `[[]]`, nil, true},
+		{"object", `{"text": "hello"}`, nil, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var input any
+			require.NoError(t, json.Unmarshal([]byte(tt.input), &input))
+
+			got, err := embeddingInputs(input)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEmbeddingsInput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mock := mockLlm{embedResp: &llm.EmbeddingResponse{Embedding: [][]float64{{3, 4}, {1, 0}}, Tokens: []int{5, 2}}}
+
+	s := Server{sched: InitScheduler(ctx)}
+	s.sched.getCpuFn = func() gpu.GpuInfoList { return gpu.GpuInfoList{{Library: "cpu"}} }
+	s.sched.getGpuFn = s.sched.getCpuFn
+	s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ gpu.GpuInfoList) {
+		req.successCh <- &runnerRef{llama: &mock}
+	}
+	s.sched.Run(ctx)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// all inputs are sent to the runner in one request, which counts
+	// their tokens
+	w = createRequest(t, s.EmbeddingsHandler, api.EmbeddingRequest{
+		Model:     "test",
+		Input:     []any{"hello", []int{1, 2}},
+		Truncate:  true,
+		Normalize: true,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp api.EmbeddingResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, [][]float64{{0.6, 0.8}, {1, 0}}, resp.Embeddings)
+	assert.Equal(t, 7, resp.PromptEvalCount)
+
+	require.Len(t, mock.embedReqs, 1)
+	assert.Equal(t, []any{"hello", []int{1, 2}}, mock.embedReqs[0].Content)
+	require.NotNil(t, mock.embedReqs[0].Truncate)
+	assert.True(t, *mock.embedReqs[0].Truncate)
+
+	// inputs the runner rejects keep its status
+	mock.embedRespErr = api.StatusError{StatusCode: http.StatusBadRequest, ErrorMessage: "input 0 length of 5000 tokens exceeds maximum context length of 2047"}
+	w = createRequest(t, s.EmbeddingsHandler, api.EmbeddingRequest{Model: "test", Input: "hello"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "exceeds maximum context length")
+}
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		input []float64
+		want  []float64
+	}{
+		{input: []float64{3, 4}, want: []float64{0.6, 0.8}},
+		{input: []float64{-2, 0, 0}, want: []float64{-1, 0, 0}},
+		{input: []float64{0, 0, 0}, want: []float64{0, 0, 0}},
+	}
+
+	for _, tt := range cases {
+		normalize(tt.input)
+		assert.InDeltaSlice(t, tt.want, tt.input, 1e-9)
+	}
+}
+
+func TestCheckLogprobs(t *testing.T) {
+	cases := []struct {
+		logprobs    bool
+		topLogprobs int
+		wantErr     bool
+	}{
+		{},
+		{logprobs: true},
+		{logprobs: true, topLogprobs: 5},
+		{logprobs: true, topLogprobs: 20},
+		{logprobs: true, topLogprobs: 21, wantErr: true},
+		{logprobs: true, topLogprobs: -1, wantErr: true},
+		{topLogprobs: 5, wantErr: true},
+	}
+
+	for _, tt := range cases {
+		err := checkLogprobs(tt.logprobs, tt.topLogprobs)
+		if tt.wantErr {
+			assert.Error(t, err, "logprobs=%v top_logprobs=%d", tt.logprobs, tt.topLogprobs)
+		} else {
+			assert.NoError(t, err, "logprobs=%v top_logprobs=%d", tt.logprobs, tt.topLogprobs)
+		}
+	}
+}
+
+func TestChatToolCalls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mock := mockLlm{completionChunks: []llm.CompletionResponse{
+		{Content: `[TOOL_CALLS] [{"name": "get_weather", `},
+		{Content: `"arguments": {"city": "Paris"}}]`},
+		{Done: true, DoneReason: "stop"},
+	}}
+
+	s := Server{sched: InitScheduler(ctx)}
+	s.sched.getCpuFn = func() gpu.GpuInfoList { return gpu.GpuInfoList{{Library: "cpu"}} }
+	s.sched.getGpuFn = s.sched.getCpuFn
+	s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ gpu.GpuInfoList) {
+		req.successCh <- &runnerRef{llama: &mock}
+	}
+	s.sched.Run(ctx)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name: "test",
+		Modelfile: fmt.Sprintf(`FROM %s
+TEMPLATE """{{ if .Tools }}[AVAILABLE_TOOLS] {{ json .Tools }}[/AVAILABLE_TOOLS]{{ end }}{{ .Prompt }}{{ if .ToolCalls }}[TOOL_CALLS] [{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}]{{ end }}{{ .Response }}"""`, createBinFile(t, nil, nil)),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	want := []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}}
+	tools := []api.Tool{{Type: "function", Function: api.ToolFunction{Name: "get_weather"}}}
+
+	for _, stream := range []bool{false, true} {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "What's the weather in Paris?"}},
+			Tools:    tools,
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("stream %t: expected status 200, got %d: %s", stream, w.Code, w.Body.String())
+		}
+
+		// the tool calls are in the final response
+		var final api.ChatResponse
+		for d := json.NewDecoder(w.Body); d.More(); {
+			if err := d.Decode(&final); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !final.Done {
+			t.Errorf("stream %t: expected final response to be done", stream)
+		}
+
+		assert.Equal(t, want, final.Message.ToolCalls, "stream %t", stream)
+	}
+}
+
+func TestChatToolCallsStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mock mockLlm
+
+	s := Server{sched: InitScheduler(ctx)}
+	s.sched.getCpuFn = func() gpu.GpuInfoList { return gpu.GpuInfoList{{Library: "cpu"}} }
+	s.sched.getGpuFn = s.sched.getCpuFn
+	s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ gpu.GpuInfoList) {
+		req.successCh <- &runnerRef{llama: &mock}
+	}
+	s.sched.Run(ctx)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name: "test",
+		Modelfile: fmt.Sprintf(`FROM %s
+TEMPLATE """{{ if .Tools }}[AVAILABLE_TOOLS] {{ json .Tools }}[/AVAILABLE_TOOLS]{{ end }}{{ .Prompt }}{{ if .ToolCalls }}[TOOL_CALLS] [{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}]{{ end }}{{ .Response }}"""`, createBinFile(t, nil, nil)),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	tools := []api.Tool{{Type: "function", Function: api.ToolFunction{Name: "get_weather"}}}
+
+	tests := []struct {
+		name      string
+		chunks    []llm.CompletionResponse
+		content   []string
+		toolCalls []api.ToolCall
+	}{
+		{
+			name: "tool call",
+			chunks: []llm.CompletionResponse{
+				{Content: "[TOOL"},
+				{Content: `_CALLS] [{"name": "get_weather", `},
+				{Content: `"arguments": {"city": "Paris"}}]`},
+				{Done: true, DoneReason: "stop"},
+			},
+			content:   []string{""},
+			toolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}},
+		},
+		{
+			name: "text",
+			chunks: []llm.CompletionResponse{
+				{Content: "It's "},
+				{Content: "sunny in {Paris}."},
+				{Done: true, DoneReason: "stop"},
+			},
+			content: []string{"It's ", "sunny in {Paris}.", ""},
+		},
+		{
+			name: "incomplete tool call",
+			chunks: []llm.CompletionResponse{
+				{Content: "[TOOL_CALLS] "},
+				{Content: `[{"name": "get_weather"`},
+				{Done: true, DoneReason: "length"},
+			},
+			content: []string{`[TOOL_CALLS] [{"name": "get_weather"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.completionChunks = tt.chunks
+
+			streaming := true
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:    "test",
+				Messages: []api.Message{{Role: "user", Content: "What's the weather in Paris?"}},
+				Tools:    tools,
+				Stream:   &streaming,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var content []string
+			var resp api.ChatResponse
+			for d := json.NewDecoder(w.Body); d.More(); {
+				resp = api.ChatResponse{}
+				if err := d.Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				content = append(content, resp.Message.Content)
+			}
+
+			if !resp.Done {
+				t.Error("expected final response to be done")
+			}
+
+			assert.Equal(t, tt.content, content)
+			assert.Equal(t, tt.toolCalls, resp.Message.ToolCalls)
+		})
+	}
+}
+
+func TestStreamCancelled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	envconfig.LoadConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mock := mockLlm{
+		completionChunks: []llm.CompletionResponse{{Content: "The sky"}},
+		completionResp:   context.Canceled,
+	}
+
+	s := Server{sched: InitScheduler(ctx)}
+	s.sched.getCpuFn = func() gpu.GpuInfoList { return gpu.GpuInfoList{{Library: "cpu"}} }
+	s.sched.getGpuFn = s.sched.getCpuFn
+	s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ gpu.GpuInfoList) {
+		req.successCh <- &runnerRef{llama: &mock}
+	}
+	s.sched.Run(ctx)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	streaming := true
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"generate": createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "Why is the sky blue?", Stream: &streaming}),
+		"chat": createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Why is the sky blue?"}},
+			Stream:   &streaming,
+		}),
+	} {
+		require.Equal(t, http.StatusOK, w.Code, name)
+
+		// the stream ends with an error like any other, without a status
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		require.Len(t, lines, 2, name)
+		assert.JSONEq(t, `{"error": "request canceled"}`, lines[1], name)
+	}
+}
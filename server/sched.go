@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -27,32 +28,62 @@ type LlmRequest struct {
 	successCh       chan *runnerRef
 	errCh           chan error
 	schedAttempts   uint
+
+	// pinned pins the runner loaded for the request
+	pinned bool
+
+	// scheduling and the virtual times and sequence number are used to order
+	// the request in the pending queue
+	scheduling scheduling
+	vstart     float64
+	vfinish    float64
+	seq        uint64
+
+	// stopWake stops waking the scheduler when the request is cancelled
+	// once it's no longer queued
+	stopWake func() bool
 }
 
 type Scheduler struct {
 	pendingReqCh  chan *LlmRequest
+	pendingQueue  *pendingQueue
 	finishedReqCh chan *LlmRequest
 	expiredCh     chan *runnerRef
 	unloadedCh    chan interface{}
 
+	// releasedCh is signaled when a request releases its runner, freeing a
+	// slot for requests waiting in the queue, or when a queued request is
+	// cancelled, so it's dropped from the queue
+	releasedCh chan struct{}
+
 	loaded   map[string]*runnerRef
 	loadedMu sync.Mutex
 
 	loadFn       func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList)
-	newServerFn  func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options) (llm.LlamaServer, error)
+	newServerFn  func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []llm.Adapter, projectors []string, draft string, opts api.Options) (llm.LlamaServer, error)
 	getGpuFn     func() gpu.GpuInfoList
 	getCpuFn     func() gpu.GpuInfoList
 	reschedDelay time.Duration
+
+	// preloadErrors holds the models that failed to load at startup
+	preloadErrors []api.PreloadError
+	preloadMu     sync.Mutex
 }
 
 var ErrMaxQueue = fmt.Errorf("server busy, please try again.  maximum pending requests exceeded")
 
+var errAllPinned = errors.New("cannot load model: there is no room for it and all loaded models are pinned")
+
+var errPinnedOptions = errors.New("cannot reload model: it is pinned with different options, load it again to change them")
+
 func InitScheduler(ctx context.Context) *Scheduler {
 	sched := &Scheduler{
 		pendingReqCh:  make(chan *LlmRequest, envconfig.MaxQueuedRequests),
+		pendingQueue:  newPendingQueue(),
 		finishedReqCh: make(chan *LlmRequest, envconfig.MaxQueuedRequests),
 		expiredCh:     make(chan *runnerRef, envconfig.MaxQueuedRequests),
 		unloadedCh:    make(chan interface{}, envconfig.MaxQueuedRequests),
+		releasedCh:    make(chan struct{}, 1),
 		loaded:        make(map[string]*runnerRef),
 		newServerFn:   llm.NewLlamaServer,
 		getGpuFn:      gpu.GetGPUInfo,
@@ -79,6 +110,12 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 		sessionDuration: sessionDuration,
 		successCh:       make(chan *runnerRef),
 		errCh:           make(chan error, 1),
+		scheduling:      schedulingFromContext(c),
+	}
+
+	if s.queued() >= envconfig.MaxQueuedRequests {
+		req.errCh <- ErrMaxQueue
+		return req.successCh, req.errCh
 	}
 
 	select {
@@ -89,6 +126,24 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 	return req.successCh, req.errCh
 }
 
+// enqueue queues req to be scheduled. If req is cancelled while it's queued
+// the scheduler is woken to drop it, so its flow doesn't hold back others
+// until the next scheduling event.
+func (s *Scheduler) enqueue(req *LlmRequest) {
+	req.stopWake = context.AfterFunc(req.ctx, func() {
+		select {
+		case s.releasedCh <- struct{}{}:
+		default:
+		}
+	})
+	s.pendingQueue.push(req)
+}
+
+// queued returns the number of requests waiting to be scheduled
+func (s *Scheduler) queued() int {
+	return len(s.pendingReqCh) + s.pendingQueue.len()
+}
+
 // Returns immediately, spawns go routines for the scheduler which will shutdown when ctx is done
 func (s *Scheduler) Run(ctx context.Context) {
 	slog.Debug("starting llm scheduler")
@@ -103,151 +158,217 @@ func (s *Scheduler) Run(ctx context.Context) {
 
 func (s *Scheduler) processPending(ctx context.Context) {
 	for {
-		select {
-		case <-ctx.Done():
-			slog.Debug("shutting down scheduler pending loop")
-			return
-		case pending := <-s.pendingReqCh:
-			// Block other requests until we get this pending request running
-			pending.schedAttempts++
+		// Move newly arrived requests into the queue so the next request is
+		// picked fairly from everything waiting
+		for drained := false; !drained; {
+			select {
+			case <-ctx.Done():
+				slog.Debug("shutting down scheduler pending loop")
+				return
+			case req := <-s.pendingReqCh:
+				s.enqueue(req)
+			default:
+				drained = true
+			}
+		}
 
-			if pending.ctx.Err() != nil {
-				slog.Debug("pending request cancelled or timed out, skipping scheduling")
-				continue
+		pending := s.pendingQueue.pop(s.readyToSchedule())
+		if pending == nil {
+			select {
+			case <-ctx.Done():
+				slog.Debug("shutting down scheduler pending loop")
+				return
+			case req := <-s.pendingReqCh:
+				s.enqueue(req)
+			case <-s.releasedCh:
+				// A slot was freed for requests waiting on a busy runner, or a
+				// queued request was cancelled
+			case <-s.unloadedCh:
+				// An unload request when there are no pending request can be ignored
+				slog.Debug("ignoring unload event with no pending requests")
 			}
+			continue
+		}
+
+		if pending.stopWake != nil {
+			pending.stopWake()
+		}
+
+		// Block other requests until we get this pending request running
+		pending.schedAttempts++
 
-			for {
-				var runnerToExpire *runnerRef
-				s.loadedMu.Lock()
-				runner := s.loaded[pending.model.ModelPath]
-				loadedCount := len(s.loaded)
-				s.loadedMu.Unlock()
-				if runner != nil {
-					if runner.needsReload(ctx, pending) {
-						runnerToExpire = runner
-					} else {
-						// Runner is usable, return it
-						pending.useLoadedRunner(runner, s.finishedReqCh)
+		if err := pending.ctx.Err(); err != nil {
+			slog.Debug("pending request cancelled or timed out, skipping scheduling")
+			pending.errCh <- err
+			continue
+		}
+
+		for {
+			var runnerToExpire *runnerRef
+			s.loadedMu.Lock()
+			runner := s.loaded[pending.model.ModelPath]
+			loadedCount := len(s.loaded)
+			s.loadedMu.Unlock()
+			if runner != nil {
+				if runner.needsReload(ctx, pending) {
+					// only loads change the options of a pinned runner, other
+					// requests only reload it if it stopped responding
+					runner.refMu.Lock()
+					pinned := runner.pinned
+					runner.refMu.Unlock()
+					if pinned && !pending.scheduling.load && runner.llama.Ping(ctx) == nil {
+						pending.errCh <- errPinnedOptions
 						break
 					}
-				} else if envconfig.MaxRunners > 0 && loadedCount >= envconfig.MaxRunners {
-					slog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
-					runnerToExpire = s.findRunnerToUnload()
+					runnerToExpire = runner
 				} else {
-					// Either no models are loaded or below envconfig.MaxRunners
-					// Get a refreshed GPU list
-					var gpus gpu.GpuInfoList
-					if pending.opts.NumGPU == 0 {
-						gpus = s.getCpuFn()
-					} else {
-						gpus = s.getGpuFn()
-					}
+					// Runner is usable, return it
+					pending.useLoadedRunner(runner, s.finishedReqCh)
+					break
+				}
+			} else if envconfig.MaxRunners > 0 && loadedCount >= envconfig.MaxRunners {
+				slog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
+				runnerToExpire = s.findRunnerToUnload()
+				if runnerToExpire == nil {
+					pending.errCh <- errAllPinned
+					break
+				}
+			} else {
+				// Either no models are loaded or below envconfig.MaxRunners
+				// Get a refreshed GPU list
+				var gpus gpu.GpuInfoList
+				if pending.opts.NumGPU == 0 {
+					gpus = s.getCpuFn()
+				} else {
+					gpus = s.getGpuFn()
+				}
 
-					// Load model for fitting
-					ggml, err := llm.LoadModel(pending.model.ModelPath)
+				// Load model for fitting
+				ggml, err := llm.LoadModel(pending.model.ModelPath)
+				if err != nil {
+					pending.errCh <- err
+					break
+				}
+
+				// Evaluate if the model will fit in the available system memory, or if we should unload a model first
+				if len(gpus) == 1 && gpus[0].Library == "cpu" {
+					if loadedCount == 0 {
+						slog.Debug("cpu mode with first model, loading")
+						s.loadFn(pending, ggml, gpus)
+						break
+					}
+					runnerToExpire, err = s.maybeFindCPURunnerToUnload(pending, ggml, gpus)
 					if err != nil {
 						pending.errCh <- err
 						break
 					}
-
-					// Evaluate if the model will fit in the available system memory, or if we should unload a model first
-					if len(gpus) == 1 && gpus[0].Library == "cpu" {
-						if loadedCount == 0 {
-							slog.Debug("cpu mode with first model, loading")
-							s.loadFn(pending, ggml, gpus)
-							break
-						}
-						runnerToExpire = s.maybeFindCPURunnerToUnload(pending, ggml, gpus)
-						if runnerToExpire == nil {
-							slog.Debug("cpu mode with available system memory or first model, loading")
-							s.loadFn(pending, ggml, gpus)
-							break
-						}
-						// else we need to expire a runner
-					} else if loadedCount == 0 {
-						// No models loaded. Load the model but prefer the best fit.
-						slog.Debug("loading first model", "model", pending.model.ModelPath)
-						g := pickBestFitGPUs(pending, ggml, gpus)
-						if g != nil {
-							gpus = g
-						}
+					if runnerToExpire == nil {
+						slog.Debug("cpu mode with available system memory or first model, loading")
 						s.loadFn(pending, ggml, gpus)
 						break
 					}
-
-					if runnerToExpire == nil {
-						// More than one loaded model, so we have to see if the
-						// new one fits
-						//
-						// We want to avoid loading on any GPUs that have other
-						// models still loading on them to avoid potential races
-						// with VRAM consumption ramping up during load
-						availGpus := s.filterGPUsWithoutLoadingModels(gpus)
-
-						// Update free memory from currently loaded models
-						s.updateFreeSpace(availGpus)
-						fitGpus := pickBestFitGPUs(pending, ggml, availGpus)
-						if fitGpus != nil {
-							slog.Debug("new model fits with existing models, loading")
-							s.loadFn(pending, ggml, fitGpus)
-							break
-						}
-
-						// We couldn't find a set of GPUs to fully load the new
-						// model. If no other models are loading (both GPU lists
-						// are the same) then we need to unload another model to
-						// make room
-						if len(availGpus) < len(gpus) {
-							// There are other requests pending, and this one
-							// needs more time, so put it on the back of the
-							// queue so that we might satisfy other pending
-							// requests that aren't blocked
-							go func() {
-								// Process in a go routine to avoid deadlocking
-								// the scheduler if our queue is full
-								slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
-								time.Sleep(s.reschedDelay)
-								s.pendingReqCh <- pending
-							}()
-							break
-						}
-						runnerToExpire = s.findRunnerToUnload()
+					// else we need to expire a runner
+				} else if loadedCount == 0 {
+					// No models loaded. Load the model but prefer the best fit.
+					slog.Debug("loading first model", "model", pending.model.ModelPath)
+					g := pickBestFitGPUs(pending, ggml, gpus)
+					if g != nil {
+						gpus = g
 					}
+					s.loadFn(pending, ggml, gpus)
+					break
 				}
 
 				if runnerToExpire == nil {
-					// Shouildn't happen
-					slog.Error("runner to expire was nil!")
-					continue
-				}
-				// Trigger an expiration to unload once it's done
-				runnerToExpire.refMu.Lock()
-				slog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
-				if runnerToExpire.expireTimer != nil {
-					runnerToExpire.expireTimer.Stop()
-					runnerToExpire.expireTimer = nil
-				}
-				runnerToExpire.sessionDuration = 0
-				if runnerToExpire.refCount <= 0 {
-					s.expiredCh <- runnerToExpire
-				}
-				runnerToExpire.refMu.Unlock()
-				// Wait for the unload to happen
-				// Note: at this point we're queueing up all incoming requests, even if they were for
-				// a different model that's loaded and not scheduled to be removed.
-				slog.Debug("waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
-				select {
-				case <-ctx.Done():
-					slog.Debug("shutting down scheduler pending loop")
-					return
-				case <-s.unloadedCh:
-					slog.Debug("unload completed", "modelPath", runnerToExpire.modelPath)
-					continue
+					// More than one loaded model, so we have to see if the
+					// new one fits
+					//
+					// We want to avoid loading on any GPUs that have other
+					// models still loading on them to avoid potential races
+					// with VRAM consumption ramping up during load
+					availGpus := s.filterGPUsWithoutLoadingModels(gpus)
+
+					// Update free memory from currently loaded models
+					s.updateFreeSpace(availGpus)
+					fitGpus := pickBestFitGPUs(pending, ggml, availGpus)
+					if fitGpus != nil {
+						slog.Debug("new model fits with existing models, loading")
+						s.loadFn(pending, ggml, fitGpus)
+						break
+					}
+
+					// We couldn't find a set of GPUs to fully load the new
+					// model. If no other models are loading (both GPU lists
+					// are the same) then we need to unload another model to
+					// make room
+					if len(availGpus) < len(gpus) {
+						// There are other requests pending, and this one
+						// needs more time, so put it on the back of the
+						// queue so that we might satisfy other pending
+						// requests that aren't blocked
+						go func() {
+							// Process in a go routine to avoid deadlocking
+							// the scheduler if our queue is full
+							slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
+							time.Sleep(s.reschedDelay)
+							s.pendingReqCh <- pending
+						}()
+						break
+					}
+					runnerToExpire = s.findRunnerToUnload()
+					if runnerToExpire == nil {
+						pending.errCh <- errAllPinned
+						break
+					}
 				}
 			}
-		case <-s.unloadedCh:
-			// An unload request when there are no pending request can be ignored
-			slog.Debug("ignoring unload event with no pending requests")
+
+			if runnerToExpire == nil {
+				// Shouildn't happen
+				slog.Error("runner to expire was nil!")
+				continue
+			}
+			reason := "capacity"
+			if runnerToExpire.modelPath == pending.model.ModelPath {
+				reason = "reload"
+			}
+
+			s.loadedMu.Lock()
+			if runnerToExpire.model != nil {
+				runnerEvictions.add(1, runnerToExpire.model.ShortName, reason)
+			}
+			s.loadedMu.Unlock()
+
+			// Trigger an expiration to unload once it's done
+			runnerToExpire.refMu.Lock()
+			if runnerToExpire.pinned {
+				// a pinned runner is only expired to reload it, which
+				// stays pinned
+				pending.pinned = true
+				runnerToExpire.pinned = false
+			}
+			slog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
+			if runnerToExpire.expireTimer != nil {
+				runnerToExpire.expireTimer.Stop()
+				runnerToExpire.expireTimer = nil
+			}
+			runnerToExpire.sessionDuration = 0
+			if runnerToExpire.refCount <= 0 {
+				s.expiredCh <- runnerToExpire
+			}
+			runnerToExpire.refMu.Unlock()
+			// Wait for the unload to happen
+			// Note: at this point we're queueing up all incoming requests, even if they were for
+			// a different model that's loaded and not scheduled to be removed.
+			slog.Debug("waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
+			select {
+			case <-ctx.Done():
+				slog.Debug("shutting down scheduler pending loop")
+				return
+			case <-s.unloadedCh:
+				slog.Debug("unload completed", "modelPath", runnerToExpire.modelPath)
+				continue
+			}
 		}
 	}
 }
@@ -269,8 +390,14 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			}
 			runner.refMu.Lock()
 			runner.refCount--
+			select {
+			case s.releasedCh <- struct{}{}:
+			default:
+			}
 			if runner.refCount <= 0 {
-				if runner.sessionDuration <= 0 {
+				if runner.pinned {
+					slog.Debug("pinned runner has gone idle, keeping it loaded", "modelPath", runner.modelPath)
+				} else if runner.sessionDuration <= 0 {
 					slog.Debug("runner with zero duration has gone idle, expiring to unload", "modelPath", runner.modelPath)
 					if runner.expireTimer != nil {
 						runner.expireTimer.Stop()
@@ -330,6 +457,53 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 	}
 }
 
+// readyToSchedule returns a function reporting whether each pending request,
+// taken in the queue's order, can be scheduled now. Requests for a loaded
+// model stay queued until its runner has a free slot, so slots are handed out
+// in the queue's fair order rather than first come first served by the runner.
+// Since a runner applies one set of adapters at a time, requests using other
+// adapters than those it is serving also wait until it is idle.
+func (s *Scheduler) readyToSchedule() func(*LlmRequest) bool {
+	// draining holds the runners that an earlier request is waiting on to
+	// switch adapters, which later requests don't skip ahead of
+	draining := make(map[string]bool)
+	return func(req *LlmRequest) bool {
+		// cancelled requests are dropped as soon as they're popped
+		if req.ctx.Err() != nil {
+			return true
+		}
+
+		s.loadedMu.Lock()
+		runner := s.loaded[req.model.ModelPath]
+		s.loadedMu.Unlock()
+		if runner == nil {
+			return true
+		}
+
+		runner.refMu.Lock()
+		defer runner.refMu.Unlock()
+		switch {
+		case draining[req.model.ModelPath]:
+			return false
+		case runner.refCount > 0 && runner.adapters != req.scheduling.adapters:
+			draining[req.model.ModelPath] = true
+			return false
+		}
+
+		return runner.refCount < uint(runner.numParallel)
+	}
+}
+
+// numParallel returns the number of requests a runner for model serves at once
+func numParallel(model *Model) int {
+	// multimodal models don't support parallel requests yet
+	if len(model.ProjectorPaths) > 0 {
+		return 1
+	}
+
+	return envconfig.NumParallel
+}
+
 // Complete the pending request and send the runner back to the requester
 // Wires up a finished event after the request context is completed
 // Updates session duration, and resets expiration timer
@@ -337,6 +511,7 @@ func (pending *LlmRequest) useLoadedRunner(runner *runnerRef, finished chan *Llm
 	runner.refMu.Lock()
 	defer runner.refMu.Unlock()
 	runner.refCount++
+	runner.adapters = pending.scheduling.adapters
 	if runner.expireTimer != nil {
 		runner.expireTimer.Stop()
 		runner.expireTimer = nil
@@ -351,7 +526,8 @@ func (pending *LlmRequest) useLoadedRunner(runner *runnerRef, finished chan *Llm
 }
 
 func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList) {
-	llama, err := s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts)
+	start := time.Now()
+	llama, err := s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.Adapters, req.model.ProjectorPaths, req.model.DraftPath, req.opts)
 	if err != nil {
 		// some older models are not compatible with newer versions of llama.cpp
 		// show a generalized compatibility error until there is a better way to
@@ -369,11 +545,14 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList)
 		llama:           llama,
 		Options:         &req.opts,
 		sessionDuration: req.sessionDuration,
+		pinned:          req.pinned,
+		numParallel:     numParallel(req.model),
 		gpus:            gpus,
 		estimatedVRAM:   llama.EstimatedVRAM(),
 		estimatedTotal:  llama.EstimatedTotal(),
 		loading:         true,
 		refCount:        1,
+		adapters:        req.scheduling.adapters,
 	}
 	runner.refMu.Lock()
 
@@ -393,6 +572,8 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList)
 			return
 		}
 		slog.Debug("finished setting up runner", "model", req.model.ModelPath)
+		loadDuration.observe(time.Since(start).Seconds(), req.model.ShortName)
+		s.clearPreloadError(req.model)
 		runner.loading = false
 		go func() {
 			<-req.ctx.Done()
@@ -483,6 +664,17 @@ type runnerRef struct {
 	expireTimer     *time.Timer
 	expiresAt       time.Time
 
+	// pinned runners don't expire and aren't unloaded to make room for
+	// other models
+	pinned bool
+
+	// numParallel is the number of requests the runner serves at once
+	numParallel int
+
+	// adapters identifies the per request adapters of the requests the
+	// runner is serving
+	adapters string
+
 	model     *Model
 	modelPath string
 	*api.Options
@@ -525,10 +717,17 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 		optsNew.NumGPU = -1
 	}
 
+	// Nor if use_mmap wasn't, so requests without adapters can share a runner
+	// loaded without mmap for requests with them
+	if optsNew.UseMMap == api.TriStateUndefined {
+		optsExisting.UseMMap = api.TriStateUndefined
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	if !reflect.DeepEqual(runner.model.AdapterPaths, req.model.AdapterPaths) || // have the adapters changed?
+	if !reflect.DeepEqual(runner.model.Adapters, req.model.Adapters) || // have the adapters changed?
 		!reflect.DeepEqual(runner.model.ProjectorPaths, req.model.ProjectorPaths) || // have the projectors changed?
+		runner.model.DraftPath != req.model.DraftPath || // has the draft model changed?
 		!reflect.DeepEqual(optsExisting, optsNew) || // have the runner options changed?
 		runner.llama.Ping(ctx) != nil {
 		return true
@@ -624,7 +823,7 @@ func pickBestFitGPUs(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList) gpu.
 		// First attempt to fit the model into a single GPU
 		if !envconfig.SchedSpread {
 			for _, g := range sgl {
-				if ok, estimatedVRAM = llm.PredictServerFit([]gpu.GpuInfo{g}, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
+				if ok, estimatedVRAM = llm.PredictServerFit([]gpu.GpuInfo{g}, ggml, req.model.Adapters, req.model.ProjectorPaths, req.model.DraftPath, req.opts); ok {
 					slog.Debug("new model will fit in available VRAM in single GPU, loading", "model", req.model.ModelPath, "gpu", g.ID, "available", g.FreeMemory, "required", format.HumanBytes2(estimatedVRAM))
 					return []gpu.GpuInfo{g}
 				}
@@ -636,7 +835,7 @@ func pickBestFitGPUs(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList) gpu.
 		// - try subsets of GPUs instead of just falling back to 1 or all in a family
 
 		// Now try all the GPUs
-		if ok, estimatedVRAM = llm.PredictServerFit(sgl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
+		if ok, estimatedVRAM = llm.PredictServerFit(sgl, ggml, req.model.Adapters, req.model.ProjectorPaths, req.model.DraftPath, req.opts); ok {
 			slog.Debug("new model will fit in available VRAM, loading", "model", req.model.ModelPath, "library", sgl[0].Library, "required", format.HumanBytes2(estimatedVRAM))
 			return sgl
 		}
@@ -661,6 +860,16 @@ func (s *Scheduler) findRunnerToUnload() *runnerRef {
 	// e.g., if we have multiple options, will one make room for the request?
 	sort.Sort(ByDuration(runnerList))
 
+	runnerList = slices.DeleteFunc(runnerList, func(runner *runnerRef) bool {
+		runner.refMu.Lock()
+		defer runner.refMu.Unlock()
+		return runner.pinned
+	})
+	if len(runnerList) == 0 {
+		slog.Debug("all loaded runners are pinned")
+		return nil
+	}
+
 	// First try to find a runner that's already idle
 	for _, runner := range runnerList {
 		runner.refMu.Lock()
@@ -676,6 +885,31 @@ func (s *Scheduler) findRunnerToUnload() *runnerRef {
 	return runnerList[0]
 }
 
+// Unload unpins the runner for model and unloads it once the requests using
+// it finish. It does nothing if the model isn't loaded.
+func (s *Scheduler) Unload(model *Model) {
+	s.loadedMu.Lock()
+	runner := s.loaded[model.ModelPath]
+	s.loadedMu.Unlock()
+	if runner == nil {
+		return
+	}
+
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	slog.Debug("unloading runner", "modelPath", runner.modelPath, "refCount", runner.refCount)
+	runner.pinned = false
+	runner.sessionDuration = 0
+	if runner.expireTimer != nil {
+		runner.expireTimer.Stop()
+		runner.expireTimer = nil
+	}
+
+	if runner.refCount <= 0 {
+		s.expiredCh <- runner
+	}
+}
+
 func (s *Scheduler) unloadAllRunners() {
 	s.loadedMu.Lock()
 	defer s.loadedMu.Unlock()
@@ -688,16 +922,23 @@ func (s *Scheduler) unloadAllRunners() {
 }
 
 // If other runners are loaded, make sure the pending request will fit in system memory
-// If not, pick a runner to unload, else return nil and the request can be loaded
-func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList) *runnerRef {
+// If not, pick a runner to unload, else return nil and the request can be loaded.
+// It returns errAllPinned if the request doesn't fit and every loaded runner
+// is pinned
+func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList) (*runnerRef, error) {
 	slog.Debug("evaluating if CPU model load will fit in available system memory")
-	estimate := llm.EstimateGPULayers(gpus, ggml, req.model.ProjectorPaths, req.opts)
+	estimate := llm.EstimateGPULayers(gpus, ggml, req.model.Adapters, req.model.ProjectorPaths, req.model.DraftPath, req.opts)
 	if estimate.TotalSize <= gpus[0].FreeMemory {
 		slog.Debug("cpu inference mode, model fits in available system memory", "model", format.HumanBytes2(estimate.TotalSize), "available", format.HumanBytes2(gpus[0].FreeMemory))
-		return nil
+		return nil, nil
 	}
 
 	// TODO - optimization: try to find CPU only runners first, or partial offloads with enough in system memory to make room
 
-	return s.findRunnerToUnload()
+	runner := s.findRunnerToUnload()
+	if runner == nil {
+		return nil, errAllPinned
+	}
+
+	return runner, nil
 }
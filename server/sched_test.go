@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 	"testing"
 	"time"
 
@@ -47,7 +48,7 @@ func TestLoad(t *testing.T) {
 		sessionDuration: 2,
 	}
 	// Fail to load model first
-	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options) (llm.LlamaServer, error) {
+	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []llm.Adapter, projectors []string, draft string, opts api.Options) (llm.LlamaServer, error) {
 		return nil, fmt.Errorf("something failed to load model blah")
 	}
 	gpus := gpu.GpuInfoList{}
@@ -61,7 +62,7 @@ func TestLoad(t *testing.T) {
 	require.Contains(t, err.Error(), "this model may be incompatible")
 
 	server := &mockLlm{estimatedVRAM: 10, estimatedVRAMByGPU: map[string]uint64{}}
-	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options) (llm.LlamaServer, error) {
+	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []llm.Adapter, projectors []string, draft string, opts api.Options) (llm.LlamaServer, error) {
 		return server, nil
 	}
 	s.load(req, ggml, gpus)
@@ -102,7 +103,7 @@ type bundle struct {
 	ggml    *llm.GGML
 }
 
-func (scenario *bundle) newServer(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options) (llm.LlamaServer, error) {
+func (scenario *bundle) newServer(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []llm.Adapter, projectors []string, draft string, opts api.Options) (llm.LlamaServer, error) {
 	return scenario.srv, nil
 }
 
@@ -154,6 +155,10 @@ func TestRequests(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 10*time.Second)
 	defer done()
 
+	// two slots, so the first two requests share a runner
+	defer func(n int) { envconfig.NumParallel = n }(envconfig.NumParallel)
+	envconfig.NumParallel = 2
+
 	// Same model, same request
 	scenario1a := newScenario(t, ctx, "ollama-model-1", 10)
 	scenario1a.req.sessionDuration = 5 * time.Millisecond
@@ -218,7 +223,7 @@ func TestRequests(t *testing.T) {
 
 	// Trigger a reload
 	s.newServerFn = scenario2a.newServer
-	scenario2a.req.model.AdapterPaths = []string{"new"}
+	scenario2a.req.model.Adapters = []llm.Adapter{{Path: "new", Scale: 1}}
 	slog.Info("scenario2a")
 	s.pendingReqCh <- scenario2a.req
 	// finish first two requests, so model can reload
@@ -417,6 +422,162 @@ func TestPrematureExpired(t *testing.T) {
 	time.Sleep(5 * time.Millisecond)
 }
 
+func TestReadyToScheduleAdapters(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+
+	s := InitScheduler(ctx)
+	model := &Model{ModelPath: "/models/blobs/sha256-1234"}
+	runner := &runnerRef{model: model, modelPath: model.ModelPath, numParallel: 4, refCount: 1, adapters: "a"}
+	s.loaded[model.ModelPath] = runner
+
+	request := func(adapters string) *LlmRequest {
+		return &LlmRequest{ctx: ctx, model: model, scheduling: scheduling{adapters: adapters}}
+	}
+
+	// requests using the adapters the runner is serving share it
+	ready := s.readyToSchedule()
+	require.True(t, ready(request("a")))
+
+	// requests using other adapters wait for it to be idle, and later
+	// requests don't skip ahead of them
+	ready = s.readyToSchedule()
+	require.False(t, ready(request("b")))
+	require.False(t, ready(request("a")))
+
+	runner.refCount = 0
+	ready = s.readyToSchedule()
+	require.True(t, ready(request("b")))
+}
+
+func TestLoadedRunnerPriority(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+
+	scenario := newScenario(t, ctx, "ollama-model-1", 10)
+	scenario.req.sessionDuration = time.Minute
+	s := InitScheduler(ctx)
+	s.getGpuFn = func() gpu.GpuInfoList {
+		g := gpu.GpuInfo{Library: "metal"}
+		g.TotalMemory = 24 * format.GigaByte
+		g.FreeMemory = 12 * format.GigaByte
+		return []gpu.GpuInfo{g}
+	}
+	s.newServerFn = scenario.newServer
+	s.pendingReqCh <- scenario.req
+	s.Run(ctx)
+	select {
+	case <-scenario.req.successCh:
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	request := func(p priority, client string) (*LlmRequest, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(ctx)
+		return &LlmRequest{
+			ctx:             ctx,
+			model:           scenario.req.model,
+			opts:            scenario.req.opts,
+			sessionDuration: time.Minute,
+			successCh:       make(chan *runnerRef, 1),
+			errCh:           make(chan error, 1),
+			scheduling:      scheduling{priority: p, client: client},
+		}, cancel
+	}
+
+	// while the runner's only slot is busy, a batch client floods the model
+	// and then an interactive request arrives
+	var batch []*LlmRequest
+	for range 4 {
+		req, cancel := request(priorityBatch, "batch")
+		defer cancel()
+		batch = append(batch, req)
+		s.pendingReqCh <- req
+	}
+
+	chat, chatDone := request(priorityInteractive, "chat")
+	defer chatDone()
+	s.pendingReqCh <- chat
+
+	require.Eventually(t, func() bool { return s.pendingQueue.len() == 5 }, time.Second, time.Millisecond)
+	for _, req := range append(batch, chat) {
+		require.Empty(t, req.successCh)
+	}
+
+	// the interactive request gets the slot when it frees
+	scenario.ctxDone()
+	select {
+	case <-chat.successCh:
+	case <-batch[0].successCh:
+		t.Fatal("batch request was served before the interactive request")
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	for _, req := range batch {
+		require.Empty(t, req.successCh)
+	}
+
+	chatDone()
+	select {
+	case <-batch[0].successCh:
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	require.Equal(t, 3, s.pendingQueue.len())
+}
+
+func TestQueuedRequestCancelled(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+
+	scenario := newScenario(t, ctx, "ollama-model-1", 10)
+	scenario.req.sessionDuration = time.Minute
+	defer scenario.ctxDone()
+	s := InitScheduler(ctx)
+	s.getGpuFn = func() gpu.GpuInfoList {
+		g := gpu.GpuInfo{Library: "metal"}
+		g.TotalMemory = 24 * format.GigaByte
+		g.FreeMemory = 12 * format.GigaByte
+		return []gpu.GpuInfo{g}
+	}
+	s.newServerFn = scenario.newServer
+	s.pendingReqCh <- scenario.req
+	s.Run(ctx)
+	select {
+	case <-scenario.req.successCh:
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// a request waiting on the busy runner is dropped from the queue as soon
+	// as it's cancelled, without waiting for the runner to free a slot
+	reqCtx, cancel := context.WithCancel(ctx)
+	req := &LlmRequest{
+		ctx:             reqCtx,
+		model:           scenario.req.model,
+		opts:            scenario.req.opts,
+		sessionDuration: time.Minute,
+		successCh:       make(chan *runnerRef, 1),
+		errCh:           make(chan error, 1),
+	}
+	s.pendingReqCh <- req
+	require.Eventually(t, func() bool { return s.pendingQueue.len() == 1 }, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-req.errCh:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-req.successCh:
+		t.Fatal("cancelled request was scheduled")
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	require.Zero(t, s.pendingQueue.len())
+}
+
 func TestUseLoadedRunner(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	req := &LlmRequest{
@@ -527,29 +688,224 @@ func TestFindRunnerToUnload(t *testing.T) {
 	r2.refCount = 1
 	resp = s.findRunnerToUnload()
 	require.Equal(t, r1, resp)
+
+	r1.pinned = true
+	resp = s.findRunnerToUnload()
+	require.Equal(t, r2, resp)
+	r2.pinned = true
+	require.Nil(t, s.findRunnerToUnload())
+}
+
+func TestPinnedRunner(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+
+	s := InitScheduler(ctx)
+	go s.processCompleted(ctx)
+
+	llama := &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}
+	model := &Model{ModelPath: "a"}
+	r1 := &runnerRef{llama: llama, model: model, modelPath: "a", refCount: 1, pinned: true}
+
+	s.loadedMu.Lock()
+	s.loaded["a"] = r1
+	s.loadedMu.Unlock()
+
+	// a pinned runner is kept loaded without an expiry when it goes idle.
+	// releasedCh is signaled while the runner is locked, so locking it
+	// again waits for the finished request to be processed
+	s.finishedReqCh <- &LlmRequest{model: model}
+	select {
+	case <-s.releasedCh:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for release")
+	}
+	r1.refMu.Lock()
+	require.Equal(t, uint(0), r1.refCount)
+	require.Nil(t, r1.expireTimer)
+	r1.refMu.Unlock()
+	s.loadedMu.Lock()
+	require.Len(t, s.loaded, 1)
+	s.loadedMu.Unlock()
+
+	s.Unload(model)
+	select {
+	case <-s.unloadedCh:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for unload")
+	}
+
+	require.True(t, llama.closeCalled)
+	s.loadedMu.Lock()
+	require.Empty(t, s.loaded)
+	s.loadedMu.Unlock()
+
+	// unloading a model that isn't loaded does nothing
+	s.Unload(model)
+}
+
+func TestAllRunnersPinned(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+
+	// room for more models, so only memory keeps them from loading
+	defer func(n int) { envconfig.MaxRunners = n }(envconfig.MaxRunners)
+	envconfig.MaxRunners = 2
+
+	s := InitScheduler(ctx)
+	s.getGpuFn = func() gpu.GpuInfoList {
+		g := gpu.GpuInfo{Library: "metal"}
+		g.TotalMemory = 24 * format.GigaByte
+		g.FreeMemory = 24 * format.GigaByte
+		return []gpu.GpuInfo{g}
+	}
+	s.getCpuFn = func() gpu.GpuInfoList {
+		g := gpu.GpuInfo{Library: "cpu"}
+		g.TotalMemory = 32 * format.GigaByte
+		g.FreeMemory = 1 * format.GigaByte
+		return []gpu.GpuInfo{g}
+	}
+	s.loadFn = func(req *LlmRequest, _ *llm.GGML, _ gpu.GpuInfoList) {
+		t.Errorf("unexpected load of %s", req.model.ModelPath)
+	}
+
+	// a pinned runner using all of the VRAM
+	pinned := &runnerRef{
+		llama:     &mockLlm{estimatedVRAMByGPU: map[string]uint64{"": 24 * format.GigaByte}},
+		model:     &Model{ModelPath: "pinned"},
+		modelPath: "pinned",
+		pinned:    true,
+	}
+	s.loadedMu.Lock()
+	s.loaded["pinned"] = pinned
+	s.loadedMu.Unlock()
+	s.Run(ctx)
+
+	gpuLoad := newScenario(t, ctx, "ollama-model-gpu", 10)
+	cpuLoad := newScenario(t, ctx, "ollama-model-cpu", 10)
+	cpuLoad.req.opts.NumGPU = 0
+	cpuLoad.req.opts.NumCtx = 1 << 20 // too large for the free system memory
+
+	for _, scenario := range []*bundle{gpuLoad, cpuLoad} {
+		s.pendingReqCh <- scenario.req
+		select {
+		case err := <-scenario.req.errCh:
+			require.ErrorIs(t, err, errAllPinned)
+		case <-scenario.req.successCh:
+			t.Fatalf("%s loaded alongside pinned runners it doesn't fit with", scenario.req.model.Name)
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+	}
+
+	s.loadedMu.Lock()
+	require.Equal(t, map[string]*runnerRef{"pinned": pinned}, s.loaded)
+	s.loadedMu.Unlock()
+}
+
+func TestPinnedRunnerReload(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 2*time.Second)
+	defer done()
+
+	s := InitScheduler(ctx)
+	s.getGpuFn = func() gpu.GpuInfoList {
+		g := gpu.GpuInfo{Library: "cpu"}
+		g.TotalMemory = 32 * format.GigaByte
+		g.FreeMemory = 26 * format.GigaByte
+		return []gpu.GpuInfo{g}
+	}
+	s.getCpuFn = s.getGpuFn
+
+	load := newScenario(t, ctx, "ollama-model-pinned", 10)
+	load.req.scheduling.load = true
+	s.newServerFn = load.newServer
+	s.pendingReqCh <- load.req
+	s.Run(ctx)
+
+	var pinned *runnerRef
+	select {
+	case pinned = <-load.req.successCh:
+	case err := <-load.req.errCh:
+		t.Fatal(err)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	pinned.refMu.Lock()
+	pinned.pinned = true
+	pinned.refMu.Unlock()
+	load.ctxDone()
+
+	// other requests don't reload the pinned runner with their options
+	other := newScenario(t, ctx, "ollama-model-pinned", 10)
+	other.req.model = load.req.model
+	other.req.opts.NumCtx = 4096
+	s.newServerFn = func(gpu.GpuInfoList, string, *llm.GGML, []llm.Adapter, []string, string, api.Options) (llm.LlamaServer, error) {
+		t.Error("unexpected reload of the pinned runner")
+		return other.srv, nil
+	}
+	s.pendingReqCh <- other.req
+	select {
+	case err := <-other.req.errCh:
+		require.ErrorIs(t, err, errPinnedOptions)
+	case <-other.req.successCh:
+		t.Fatal("pinned runner reloaded with the options of another request")
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s.loadedMu.Lock()
+	require.Equal(t, map[string]*runnerRef{load.req.model.ModelPath: pinned}, s.loaded)
+	s.loadedMu.Unlock()
+	pinned.refMu.Lock()
+	require.True(t, pinned.pinned)
+	pinned.refMu.Unlock()
+
+	// loads do, and the reloaded runner stays pinned
+	reload := newScenario(t, ctx, "ollama-model-pinned", 10)
+	reload.req.model = load.req.model
+	reload.req.opts.NumCtx = 4096
+	reload.req.scheduling.load = true
+	s.newServerFn = reload.newServer
+	s.pendingReqCh <- reload.req
+	select {
+	case runner := <-reload.req.successCh:
+		require.Equal(t, reload.srv, runner.llama)
+		runner.refMu.Lock()
+		require.True(t, runner.pinned)
+		runner.refMu.Unlock()
+	case err := <-reload.req.errCh:
+		t.Fatal(err)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+	require.True(t, load.srv.closeCalled)
+	reload.ctxDone()
 }
 
 func TestNeedsReload(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer done()
 
+	adapters := []llm.Adapter{{Path: "adapter1", Scale: 1}}
 	llm := &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}
 	do := api.DefaultOptions()
 	runner := &runnerRef{
-		model:   &Model{AdapterPaths: []string{"adapter1"}, ProjectorPaths: []string{"projector1"}},
+		model:   &Model{Adapters: adapters, ProjectorPaths: []string{"projector1"}},
 		Options: &do,
 		llama:   llm,
 	}
 	req := &LlmRequest{
 		model: &Model{
-			AdapterPaths:   []string{"adapter2"},
+			Adapters:       slices.Clone(adapters),
 			ProjectorPaths: []string{"projector2"},
 		},
 		opts: api.DefaultOptions(),
 	}
+	req.model.Adapters[0].Scale = 0.5
 	resp := runner.needsReload(ctx, req)
 	require.True(t, resp)
-	req.model.AdapterPaths = runner.model.AdapterPaths
+	req.model.Adapters = runner.model.Adapters
 	resp = runner.needsReload(ctx, req)
 	require.True(t, resp)
 	req.model.ProjectorPaths = runner.model.ProjectorPaths
@@ -570,6 +926,12 @@ func TestNeedsReload(t *testing.T) {
 	req.opts.NumGPU = -1
 	resp = runner.needsReload(ctx, req)
 	require.False(t, resp)
+	runner.Options.UseMMap = api.TriStateFalse
+	resp = runner.needsReload(ctx, req)
+	require.False(t, resp)
+	req.opts.UseMMap = api.TriStateTrue
+	resp = runner.needsReload(ctx, req)
+	require.True(t, resp)
 }
 
 func TestUnloadAllRunners(t *testing.T) {
@@ -597,7 +959,7 @@ func TestUnloadAllRunners(t *testing.T) {
 func TestUnload(t *testing.T) {
 	llm1 := &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}
 	r1 := &runnerRef{llama: llm1}
-	r2 := &runnerRef{model: &Model{AdapterPaths: []string{"A"}}}
+	r2 := &runnerRef{model: &Model{Adapters: []llm.Adapter{{Path: "A", Scale: 1}}}}
 	r1.unload()
 	require.True(t, llm1.closeCalled)
 	r2.unload()
@@ -608,8 +970,11 @@ type mockLlm struct {
 	pingResp           error
 	waitResp           error
 	completionResp     error
-	embeddingResp      []float64
-	embeddingRespErr   error
+	completionChunks   []llm.CompletionResponse
+	completionReqs     []llm.CompletionRequest
+	embedResp          *llm.EmbeddingResponse
+	embedRespErr       error
+	embedReqs          []llm.EmbeddingRequest
 	tokenizeResp       []int
 	tokenizeRespErr    error
 	detokenizeResp     string
@@ -624,10 +989,16 @@ type mockLlm struct {
 func (s *mockLlm) Ping(ctx context.Context) error             { return s.pingResp }
 func (s *mockLlm) WaitUntilRunning(ctx context.Context) error { return s.waitResp }
 func (s *mockLlm) Completion(ctx context.Context, req llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+	s.completionReqs = append(s.completionReqs, req)
+	for _, chunk := range s.completionChunks {
+		fn(chunk)
+	}
+
 	return s.completionResp
 }
-func (s *mockLlm) Embedding(ctx context.Context, prompt string) ([]float64, error) {
-	return s.embeddingResp, s.embeddingRespErr
+func (s *mockLlm) Embed(ctx context.Context, req llm.EmbeddingRequest) (*llm.EmbeddingResponse, error) {
+	s.embedReqs = append(s.embedReqs, req)
+	return s.embedResp, s.embedRespErr
 }
 func (s *mockLlm) Tokenize(ctx context.Context, content string) ([]int, error) {
 	return s.tokenizeResp, s.tokenizeRespErr
@@ -635,6 +1006,13 @@ func (s *mockLlm) Tokenize(ctx context.Context, content string) ([]int, error) {
 func (s *mockLlm) Detokenize(ctx context.Context, tokens []int) (string, error) {
 	return s.detokenizeResp, s.detonekizeRespErr
 }
+func (s *mockLlm) Pieces(ctx context.Context, tokens []int) ([]string, error) {
+	pieces := make([]string, len(tokens))
+	for i := range tokens {
+		pieces[i] = s.detokenizeResp
+	}
+	return pieces, s.detonekizeRespErr
+}
 func (s *mockLlm) Close() error {
 	s.closeCalled = true
 	return s.closeResp
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// snapshotDigest returns the digest identifying the snapshot of prompt
+func snapshotDigest(prompt string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(prompt)))
+}
+
+// findSnapshot returns the file name the runner restores the snapshot with
+// digest taken with model by, or an empty name if digest is empty
+func findSnapshot(model *Model, digest string) (string, error) {
+	if digest == "" {
+		return "", nil
+	}
+
+	path, err := GetSnapshotPath(model.Digest, digest)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+
+	return filepath.Base(path), nil
+}
+
+// pruneSnapshots removes the snapshots of models that are no longer installed
+func pruneSnapshots() error {
+	dir, err := modelsDir()
+	if err != nil {
+		return err
+	}
+
+	dir = filepath.Join(dir, "snapshots")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	ms, err := Manifests()
+	if err != nil {
+		return err
+	}
+
+	digests := make(map[string]struct{})
+	for _, m := range ms {
+		digests[m.digest] = struct{}{}
+	}
+
+	for _, entry := range entries {
+		// snapshots are named sha256-<model digest>-<prompt digest>
+		digest, _, _ := strings.Cut(strings.TrimPrefix(entry.Name(), "sha256-"), "-")
+		if _, ok := digests[digest]; !ok {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				slog.Warn("couldn't remove snapshot", "snapshot", entry.Name(), "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// abortWithSnapshotError writes the response for an error returned by
+// findSnapshot
+func abortWithSnapshotError(c *gin.Context, digest string, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidDigestFormat):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid snapshot '%s'", digest)})
+	case errors.Is(err, os.ErrNotExist):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("snapshot '%s' not found", digest)})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (s *Server) SnapshotHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.SnapshotRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case req.Model == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	case req.Prompt == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "prompt is required"})
+		return
+	}
+
+	ctx, err := schedulingContext(c, "")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	runner, err := s.scheduleRunner(ctx, req.Model, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	setModelLabel(c, req.Model)
+
+	checkpointLoaded := time.Now()
+
+	opts, err := modelOptions(runner.model, req.Options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the prompt only needs to be evaluated, not answered
+	opts.NumPredict = 1
+
+	digest := snapshotDigest(req.Prompt)
+	path, err := GetSnapshotPath(runner.model.Digest, digest)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the runner saves to a temporary file so that a failed save doesn't
+	// replace an earlier snapshot
+	tmp := path + ".partial"
+	defer os.Remove(tmp)
+
+	resp := api.SnapshotResponse{Snapshot: digest}
+	if err := runner.llama.Completion(c.Request.Context(), llm.CompletionRequest{
+		Prompt:       req.Prompt,
+		Options:      opts,
+		SaveSnapshot: filepath.Base(tmp),
+	}, func(r llm.CompletionResponse) {
+		if r.Done {
+			resp.Metrics = api.Metrics{
+				PromptEvalCount:    r.PromptEvalCount,
+				PromptEvalDuration: r.PromptEvalDuration,
+				PromptCacheCount:   r.PromptCacheCount,
+			}
+		}
+	}); err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp.TotalDuration = time.Since(checkpointStart)
+	resp.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+func TestFindSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", dir)
+	envconfig.LoadConfig()
+
+	model := &Model{Digest: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
+	digest := snapshotDigest("a long document")
+
+	if path, err := findSnapshot(model, ""); path != "" || err != nil {
+		t.Errorf("expected no snapshot, got %q, %v", path, err)
+	}
+
+	if _, err := findSnapshot(model, "sha256:1234"); !errors.Is(err, ErrInvalidDigestFormat) {
+		t.Errorf("expected invalid digest, got %v", err)
+	}
+
+	if _, err := findSnapshot(model, digest); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected snapshot not to exist, got %v", err)
+	}
+
+	// looking up a snapshot doesn't create the snapshots directory
+	if _, err := os.Stat(filepath.Join(dir, "snapshots")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected snapshots directory not to exist, got %v", err)
+	}
+
+	want := "sha256-" + model.Digest + "-" + digest[len("sha256:"):]
+	if err := os.MkdirAll(filepath.Join(dir, "snapshots"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "snapshots", want), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if name, err := findSnapshot(model, digest); name != want || err != nil {
+		t.Errorf("expected %q, got %q, %v", want, name, err)
+	}
+
+	// snapshots belong to the model they were taken with
+	other := &Model{Digest: "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"}
+	if _, err := findSnapshot(other, digest); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected snapshot not to exist for another model, got %v", err)
+	}
+}
+
+func TestAbortWithSnapshotError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{ErrInvalidDigestFormat, http.StatusBadRequest},
+		{os.ErrNotExist, http.StatusNotFound},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		abortWithSnapshotError(c, "sha256:1234", tt.err)
+
+		if w.Code != tt.code {
+			t.Errorf("%v: expected status %d, got %d", tt.err, tt.code, w.Code)
+		}
+	}
+}
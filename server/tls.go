@@ -0,0 +1,54 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// tlsConfig returns the TLS configuration for the API listener, or nil if the
+// API should be served without TLS. Clients must present a certificate signed
+// by one of the CAs in OLLAMA_TLS_CLIENT_CA when it is set.
+func tlsConfig() (*tls.Config, error) {
+	switch {
+	case envconfig.TLSCert == "" && envconfig.TLSKey == "":
+		if envconfig.TLSClientCA != "" {
+			return nil, errors.New("OLLAMA_TLS_CLIENT_CA requires OLLAMA_TLS_CERT and OLLAMA_TLS_KEY")
+		}
+
+		return nil, nil
+	case envconfig.TLSCert == "" || envconfig.TLSKey == "":
+		return nil, errors.New("OLLAMA_TLS_CERT and OLLAMA_TLS_KEY must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(envconfig.TLSCert, envconfig.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if envconfig.TLSClientCA != "" {
+		bts, err := os.ReadFile(envconfig.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bts) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", envconfig.TLSClientCA)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
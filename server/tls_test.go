@@ -0,0 +1,202 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+
+	certPath string
+	keyPath  string
+}
+
+// newTestCert creates a certificate for 127.0.0.1 signed by parent, or a CA
+// certificate if parent is nil, and writes it and its key to dir
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certPath: filepath.Join(dir, name+".crt"),
+		keyPath:  filepath.Join(dir, name+".key"),
+	}
+
+	if err := os.WriteFile(c.certPath, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(c.keyPath, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.tls, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+
+	cases := []struct {
+		name     string
+		cert     string
+		key      string
+		clientCA string
+		want     string
+	}{
+		{"cert only", server.certPath, "", "", "must be set together"},
+		{"key only", "", server.keyPath, "", "must be set together"},
+		{"client ca only", "", "", ca.certPath, "requires OLLAMA_TLS_CERT"},
+		{"missing cert", filepath.Join(dir, "missing.crt"), server.keyPath, "", "loading TLS certificate"},
+		{"bad client ca", server.certPath, server.keyPath, server.keyPath, "no certificates found"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_TLS_CERT", tt.cert)
+			t.Setenv("OLLAMA_TLS_KEY", tt.key)
+			t.Setenv("OLLAMA_TLS_CLIENT_CA", tt.clientCA)
+			envconfig.LoadConfig()
+
+			_, err := tlsConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CERT", "")
+		t.Setenv("OLLAMA_TLS_KEY", "")
+		t.Setenv("OLLAMA_TLS_CLIENT_CA", "")
+		envconfig.LoadConfig()
+
+		config, err := tlsConfig()
+		if err != nil || config != nil {
+			t.Errorf("expected no TLS config, got %v, %v", config, err)
+		}
+	})
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+	client := newTestCert(t, dir, "client", ca)
+	other := newTestCert(t, dir, "other", newTestCert(t, dir, "other-ca", nil))
+
+	t.Setenv("OLLAMA_TLS_CERT", server.certPath)
+	t.Setenv("OLLAMA_TLS_KEY", server.keyPath)
+	t.Setenv("OLLAMA_TLS_CLIENT_CA", ca.certPath)
+	envconfig.LoadConfig()
+
+	config, err := tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(tls.NewListener(ln, config)) //nolint:errcheck
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cases := []struct {
+		name  string
+		certs []tls.Certificate
+		ok    bool
+	}{
+		{"no certificate", nil, false},
+		{"untrusted certificate", []tls.Certificate{other.tls}, false},
+		{"trusted certificate", []tls.Certificate{client.tls}, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: tt.certs},
+			}}
+
+			resp, err := c.Get("https://" + ln.Addr().String())
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			if (err == nil) != tt.ok {
+				t.Errorf("expected success %t, got %v", tt.ok, err)
+			}
+		})
+	}
+}